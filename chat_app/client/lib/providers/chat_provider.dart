@@ -17,6 +17,7 @@ enum WebSocketMessageType {
   typing,
   readReceipt,
   system,
+  control,
 }
 
 class ChatProvider extends ChangeNotifier {
@@ -62,8 +63,12 @@ class ChatProvider extends ChangeNotifier {
         (data) {
           try {
             print('收到WebSocket消息: $data');
-            final jsonData = json.decode(data);
-            final messageType = _parseWebSocketMessageType(jsonData['type'] ?? 'message');
+            final frame = json.decode(data) as Map<String, dynamic>;
+            // 服务端帧使用统一信封 {v, type, id, payload}，兼容旧的扁平格式
+            final jsonData = frame['payload'] is Map<String, dynamic>
+                ? frame['payload'] as Map<String, dynamic>
+                : frame;
+            final messageType = _parseWebSocketMessageType(frame['type'] ?? 'message');
             
             switch (messageType) {
               case WebSocketMessageType.message:
@@ -84,6 +89,11 @@ class ChatProvider extends ChangeNotifier {
               case WebSocketMessageType.system:
                 _handleSystemMessage(jsonData);
                 break;
+              case WebSocketMessageType.control:
+                if (frame['type'] == 'error') {
+                  print('WebSocket错误回复: $jsonData');
+                }
+                break;
             }
           } catch (e) {
             print('处理WebSocket消息错误: $e');
@@ -140,6 +150,10 @@ class ChatProvider extends ChangeNotifier {
         return WebSocketMessageType.readReceipt;
       case 'system':
         return WebSocketMessageType.system;
+      case 'pong':
+      case 'ack':
+      case 'error':
+        return WebSocketMessageType.control;
       case 'message':
       default:
         return WebSocketMessageType.message;
@@ -3,7 +3,10 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
+	"time"
 	
+	"chat_app/server/database"
 	"chat_app/server/services"
 	"chat_app/server/utils"
 )
@@ -11,11 +14,12 @@ import (
 // AuthHandler 处理认证相关的API请求
 type AuthHandler struct {
 	userService *services.UserService
+	redisDB     *database.RedisDB
 }
 
 // NewAuthHandler 创建新的认证处理器
-func NewAuthHandler(userService *services.UserService) *AuthHandler {
-	return &AuthHandler{userService: userService}
+func NewAuthHandler(userService *services.UserService, redisDB *database.RedisDB) *AuthHandler {
+	return &AuthHandler{userService: userService, redisDB: redisDB}
 }
 
 // RegisterRequest 注册请求
@@ -136,4 +140,39 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	// 返回响应
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
-} 
\ No newline at end of file
+} 
+
+// Logout 处理用户登出，吊销当前令牌
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if h.redisDB == nil {
+		http.Error(w, "登出服务不可用", http.StatusServiceUnavailable)
+		return
+	}
+	
+	// 提取令牌
+	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	claims, err := utils.ParseToken(tokenString)
+	if err != nil {
+		http.Error(w, "无效的令牌", http.StatusUnauthorized)
+		return
+	}
+	
+	// 旧令牌没有ID，无法吊销
+	if claims.ID == "" {
+		http.Error(w, "令牌不支持吊销", http.StatusBadRequest)
+		return
+	}
+	
+	// 吊销记录保留到令牌自然过期为止
+	var ttl time.Duration
+	if claims.ExpiresAt != nil {
+		ttl = time.Until(claims.ExpiresAt.Time)
+	}
+	err = h.redisDB.RevokeToken(r.Context(), claims.ID, ttl)
+	if err != nil {
+		http.Error(w, "吊销令牌失败", http.StatusInternalServerError)
+		return
+	}
+	
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"chat_app/server/services"
+)
+
+// ConversationHandler 处理会话列表相关的API请求
+type ConversationHandler struct {
+	conversationService *services.ConversationService
+}
+
+// NewConversationHandler 创建新的会话处理器
+func NewConversationHandler(conversationService *services.ConversationService) *ConversationHandler {
+	return &ConversationHandler{conversationService: conversationService}
+}
+
+// RegisterRoutes 注册会话相关的路由
+func (h *ConversationHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/conversations", h.ListConversations).Methods("GET")
+	r.HandleFunc("/conversations/{id}/settings", h.UpdateSettings).Methods("PUT")
+	r.HandleFunc("/mentions", h.ListMentions).Methods("GET")
+}
+
+// ListConversations 获取当前用户的会话列表，按最后活跃时间倒序，使用cursor参数翻页
+func (h *ConversationHandler) ListConversations(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	page, err := h.conversationService.ListConversations(strconv.Itoa(userID), query.Get("cursor"), limit)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// UpdateSettingsRequest 更新会话设置请求，未携带的字段保持不变
+type UpdateSettingsRequest struct {
+	Muted  *bool `json:"muted,omitempty"`
+	Pinned *bool `json:"pinned,omitempty"`
+}
+
+// UpdateSettings 更新当前用户对会话的免打扰和置顶设置
+func (h *ConversationHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	var req UpdateSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := h.conversationService.UpdateSettings(strconv.Itoa(userID), mux.Vars(r)["id"], req.Muted, req.Pinned)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// ListMentions 获取群组中@当前用户的消息，按时间倒序，使用before参数翻页
+func (h *ConversationHandler) ListMentions(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	page, err := h.conversationService.ListMentions(strconv.Itoa(userID), query.Get("before"), limit)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
@@ -37,6 +37,11 @@ func (h *GroupHandler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/groups/{id}/members/{userId}/admin", h.SetGroupAdmin).Methods("PUT")
 	r.HandleFunc("/groups/{id}/leave", h.LeaveGroup).Methods("DELETE")
 	r.HandleFunc("/groups/avatar", h.UploadGroupAvatar).Methods("POST")
+	r.HandleFunc("/groups/announcements/unread", h.GetUnreadAnnouncements).Methods("GET")
+	r.HandleFunc("/groups/{id}/announcement", h.GetAnnouncement).Methods("GET")
+	r.HandleFunc("/groups/{id}/announcement", h.PublishAnnouncement).Methods("PUT")
+	r.HandleFunc("/groups/{id}/announcement", h.DeleteAnnouncement).Methods("DELETE")
+	r.HandleFunc("/groups/{id}/announcement/read", h.MarkAnnouncementRead).Methods("POST")
 }
 
 // CreateGroup 创建新群组
@@ -172,6 +177,9 @@ func (h *GroupHandler) GetGroupInfo(w http.ResponseWriter, r *http.Request) {
 		"updated_at":   group.UpdatedAt,
 		"member_count": memberCount,
 	}
+	if group.Announcement != nil {
+		response["announcement"] = group.Announcement
+	}
 
 	// 返回群组信息
 	w.Header().Set("Content-Type", "application/json")
@@ -624,3 +632,150 @@ func (h *GroupHandler) UploadGroupAvatar(w http.ResponseWriter, r *http.Request)
 		"avatar_url": avatarURL,
 	})
 }
+
+// GetAnnouncement 获取群组公告及当前用户的已读状态
+func (h *GroupHandler) GetAnnouncement(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户ID
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	// 获取群组ID
+	vars := mux.Vars(r)
+	groupID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "无效的群组ID", http.StatusBadRequest)
+		return
+	}
+
+	status, err := h.groupService.GetAnnouncement(groupID, userID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// PublishAnnouncement 发布群组公告，替换原有的公告
+func (h *GroupHandler) PublishAnnouncement(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户ID
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	// 获取群组ID
+	vars := mux.Vars(r)
+	groupID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "无效的群组ID", http.StatusBadRequest)
+		return
+	}
+
+	// 解析请求体
+	var req struct {
+		Title string `json:"title"`
+		Body  string `json:"body"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	announcement, err := h.groupService.PublishAnnouncement(groupID, userID, req.Title, req.Body)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(announcement)
+}
+
+// DeleteAnnouncement 删除群组公告
+func (h *GroupHandler) DeleteAnnouncement(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户ID
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	// 获取群组ID
+	vars := mux.Vars(r)
+	groupID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "无效的群组ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.groupService.DeleteAnnouncement(groupID, userID); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	// 返回成功
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MarkAnnouncementRead 确认已读群组公告
+func (h *GroupHandler) MarkAnnouncementRead(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户ID
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	// 获取群组ID
+	vars := mux.Vars(r)
+	groupID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "无效的群组ID", http.StatusBadRequest)
+		return
+	}
+
+	// 解析请求体，公告ID用于避免确认已被替换的旧公告
+	var req struct {
+		AnnouncementID int `json:"announcement_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.groupService.MarkAnnouncementRead(groupID, userID, req.AnnouncementID); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	// 返回成功
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetUnreadAnnouncements 获取当前用户尚未确认已读的群组公告
+func (h *GroupHandler) GetUnreadAnnouncements(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户ID
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	announcements, err := h.groupService.GetUnreadAnnouncements(userID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(announcements)
+}
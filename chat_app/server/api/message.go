@@ -3,12 +3,16 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"chat_app/server/models"
 	"chat_app/server/services"
 	"chat_app/server/utils"
+
+	"github.com/gorilla/mux"
 )
 
 // MessageHandler 处理消息相关的API请求
@@ -28,6 +32,33 @@ type SendMessageRequest struct {
 	Type       models.MessageType `json:"type"`
 	Content    string             `json:"content"`
 	MediaURL   string             `json:"media_url,omitempty"`
+
+	// 客户端生成的消息ID，重试时携带相同的ID可避免重复发送
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+
+	// 被引用的消息ID
+	ReplyToID string `json:"reply_to_id,omitempty"`
+
+	// 群组话题的根消息ID，指定时消息作为话题中的回复
+	ThreadID string `json:"thread_id,omitempty"`
+
+	// 群组消息中@的成员ID，mention_all为true时@所有人
+	Mentions   []string `json:"mentions,omitempty"`
+	MentionAll bool     `json:"mention_all,omitempty"`
+}
+
+// ScheduleMessageRequest 定时消息请求，在发送消息请求的基础上指定发送时间
+type ScheduleMessageRequest struct {
+	SendMessageRequest
+
+	// 计划发送的时间，RFC3339格式
+	SendAt time.Time `json:"send_at"`
+}
+
+// EditScheduledMessageRequest 修改定时消息请求，未提供的字段保持不变
+type EditScheduledMessageRequest struct {
+	Content *string    `json:"content,omitempty"`
+	SendAt  *time.Time `json:"send_at,omitempty"`
 }
 
 // SendMessage 处理发送消息请求
@@ -74,20 +105,7 @@ func (h *MessageHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 	requestBody, _ := json.Marshal(req)
 	println("SendMessage请求体:", string(requestBody))
 
-	// 验证请求
-	if req.ReceiverID == "" && req.GroupID == "" {
-		http.Error(w, "接收者ID或群组ID不能为空", http.StatusBadRequest)
-		return
-	}
-	if req.Type == "" {
-		req.Type = models.TextMessage
-	}
-	if req.Content == "" && req.MediaURL == "" {
-		http.Error(w, "消息内容不能为空", http.StatusBadRequest)
-		return
-	}
-
-	// 创建消息
+	// 创建消息，请求的校验由消息服务完成，与WebSocket发送保持一致
 	message := &models.Message{
 		SenderID:   strconv.Itoa(claims.UserID),
 		ReceiverID: req.ReceiverID,
@@ -95,12 +113,20 @@ func (h *MessageHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 		Type:       req.Type,
 		Content:    req.Content,
 		MediaURL:   req.MediaURL,
+
+		ClientMsgID: req.ClientMsgID,
+		ThreadID:    req.ThreadID,
+		Mentions:    req.Mentions,
+		MentionAll:  req.MentionAll,
+	}
+	if req.ReplyToID != "" {
+		message.ReplyTo = &models.MessagePreview{MessageID: req.ReplyToID}
 	}
 
 	// 发送消息
 	err = h.messageService.SendMessage(message)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
@@ -180,6 +206,12 @@ func (h *MessageHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
 
 	println("分页参数 - 限制:", limit, "偏移:", offset)
 
+	// 携带游标参数时使用游标分页，返回带翻页信息的对象；否则保持原有的偏移分页
+	if query.Get("before") != "" || query.Get("after") != "" || query.Get("around") != "" {
+		h.getMessagesByCursor(w, strconv.Itoa(claims.UserID), query, limit)
+		return
+	}
+
 	var messages []*models.Message
 
 	// 根据对话类型获取消息
@@ -214,8 +246,9 @@ func (h *MessageHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
 			println("没有找到消息记录")
 		}
 
-		// 标记所有消息为已读
-		err = h.messageService.MarkAllMessagesAsRead(receiverID, strconv.Itoa(claims.UserID))
+		// 将会话标记为已读，更新已读位置并通知对方
+		userID := strconv.Itoa(claims.UserID)
+		_, err = h.messageService.MarkConversationRead(userID, models.PrivateConversationID(userID, receiverID), 0)
 		if err != nil {
 			// 不中断流程，只记录错误
 			println("标记消息为已读失败:", err.Error())
@@ -232,10 +265,10 @@ func (h *MessageHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
 		println("获取群组消息, 群组ID:", groupID)
 
 		// 获取群组消息
-		messages, err = h.messageService.GetGroupMessageHistory(groupID, limit, offset)
+		messages, err = h.messageService.GetGroupMessageHistory(strconv.Itoa(claims.UserID), groupID, limit, offset)
 		if err != nil {
 			println("获取群组消息失败:", err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeServiceError(w, err)
 			return
 		}
 
@@ -271,3 +304,563 @@ func (h *MessageHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
 	// 发送响应
 	json.NewEncoder(w).Encode(messages)
 }
+
+// getMessagesByCursor 使用before、after或around游标获取会话消息
+// 会话由conversation_id指定，也可以像偏移分页一样通过type和receiver_id或group_id指定
+func (h *MessageHandler) getMessagesByCursor(w http.ResponseWriter, userID string, query url.Values, limit int) {
+	conversationID := query.Get("conversation_id")
+	if conversationID == "" {
+		switch query.Get("type") {
+		case "private":
+			if receiverID := query.Get("receiver_id"); receiverID != "" {
+				conversationID = models.PrivateConversationID(userID, receiverID)
+			}
+		case "group":
+			if groupID := query.Get("group_id"); groupID != "" {
+				conversationID = models.GroupConversationID(groupID)
+			}
+		}
+	}
+	if conversationID == "" {
+		http.Error(w, "会话ID不能为空", http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.messageService.GetConversationHistory(userID, conversationID, services.HistoryQuery{
+		Before: query.Get("before"),
+		After:  query.Get("after"),
+		Around: query.Get("around"),
+		Limit:  limit,
+	})
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// SyncMessages 处理增量同步请求，返回会话中序列号大于since_seq的消息
+func (h *MessageHandler) SyncMessages(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+
+	conversationID := query.Get("conversation_id")
+	if conversationID == "" {
+		http.Error(w, "会话ID不能为空", http.StatusBadRequest)
+		return
+	}
+
+	var sinceSeq int64
+	if sinceSeqStr := query.Get("since_seq"); sinceSeqStr != "" {
+		sinceSeq, err = strconv.ParseInt(sinceSeqStr, 10, 64)
+		if err != nil || sinceSeq < 0 {
+			http.Error(w, "无效的since_seq参数", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	result, err := h.messageService.SyncMessages(strconv.Itoa(userID), conversationID, sinceSeq, limit)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// MarkAsReadRequest 标记已读请求，会话由conversation_id或客户端的chat_id指定
+type MarkAsReadRequest struct {
+	ConversationID string `json:"conversation_id,omitempty"`
+	ChatID         string `json:"chat_id,omitempty"`
+
+	// 已读到的序列号，不携带时标记会话中的所有消息为已读
+	Seq int64 `json:"seq,omitempty"`
+}
+
+// MarkAsRead 处理标记已读请求，前移当前用户在会话中的已读位置
+func (h *MessageHandler) MarkAsRead(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	var req MarkAsReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	conversationID := req.ConversationID
+	if conversationID == "" {
+		if req.ChatID == "" {
+			http.Error(w, "会话ID不能为空", http.StatusBadRequest)
+			return
+		}
+		conversationID, err = models.ConversationIDFromChatID(strconv.Itoa(userID), req.ChatID)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+	}
+
+	receipt, err := h.messageService.MarkConversationRead(strconv.Itoa(userID), conversationID, req.Seq)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(receipt)
+}
+
+// GetReadState 处理获取已读位置请求
+func (h *MessageHandler) GetReadState(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := r.URL.Query().Get("conversation_id")
+	if conversationID == "" {
+		http.Error(w, "会话ID不能为空", http.StatusBadRequest)
+		return
+	}
+
+	state, err := h.messageService.GetReadState(strconv.Itoa(userID), conversationID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// GetMessageReaders 处理获取消息已读成员请求，返回已读和未读的成员列表
+func (h *MessageHandler) GetMessageReaders(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	readers, err := h.messageService.GetMessageReaders(strconv.Itoa(userID), mux.Vars(r)["id"])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(readers)
+}
+
+// ForwardMessagesResponse 转发消息响应，Messages为所有发送成功的消息，Results为每个目标会话的结果
+type ForwardMessagesResponse struct {
+	Messages []*models.Message         `json:"messages"`
+	Results  []*services.ForwardResult `json:"results"`
+}
+
+// ForwardMessages 处理转发消息请求，支持逐条转发和合并转发到多个会话
+func (h *MessageHandler) ForwardMessages(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	var req services.ForwardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	results, err := h.messageService.ForwardMessages(strconv.Itoa(userID), &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	// 只要有消息发送成功就返回201，客户端根据每个目标会话的结果重试失败的部分
+	messages := []*models.Message{}
+	var failure error
+	for _, result := range results {
+		messages = append(messages, result.Messages...)
+		if failure == nil {
+			failure = result.Err
+		}
+	}
+	if len(messages) == 0 && failure != nil {
+		writeServiceError(w, failure)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&ForwardMessagesResponse{Messages: messages, Results: results})
+}
+
+// GetThread 处理获取群组话题请求，返回根消息和晚于after的回复
+func (h *MessageHandler) GetThread(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	page, err := h.messageService.GetThread(strconv.Itoa(userID), mux.Vars(r)["id"], query.Get("after"), limit)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// AddReaction 处理添加表情回应请求
+func (h *MessageHandler) AddReaction(w http.ResponseWriter, r *http.Request) {
+	h.updateReaction(w, r, true)
+}
+
+// RemoveReaction 处理移除表情回应请求
+func (h *MessageHandler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	h.updateReaction(w, r, false)
+}
+
+// updateReaction 添加或移除路径中指定的表情回应
+func (h *MessageHandler) updateReaction(w http.ResponseWriter, r *http.Request, add bool) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	var event *services.ReactionUpdatedEvent
+	if add {
+		event, err = h.messageService.AddReaction(strconv.Itoa(userID), vars["id"], vars["emoji"])
+	} else {
+		event, err = h.messageService.RemoveReaction(strconv.Itoa(userID), vars["id"], vars["emoji"])
+	}
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(event)
+}
+
+// EditMessageRequest 编辑消息请求
+type EditMessageRequest struct {
+	Content string `json:"content"`
+}
+
+// EditMessage 处理编辑消息请求，只有发送者可以在允许的时间内编辑
+func (h *MessageHandler) EditMessage(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	var req EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	message, err := h.messageService.EditMessage(strconv.Itoa(userID), mux.Vars(r)["id"], req.Content)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+// RecallMessage 处理撤回消息请求
+func (h *MessageHandler) RecallMessage(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	message, err := h.messageService.RecallMessage(strconv.Itoa(userID), mux.Vars(r)["id"])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+// DeleteMessage 处理删除消息请求，scope=everyone时为所有人撤回消息，默认只删除自己的副本
+func (h *MessageHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	var forEveryone bool
+	switch r.URL.Query().Get("scope") {
+	case "", "me":
+	case "everyone":
+		forEveryone = true
+	default:
+		http.Error(w, "scope只能为me或everyone", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.messageService.DeleteMessage(strconv.Itoa(userID), mux.Vars(r)["id"], forEveryone); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteChat 处理删除聊天请求，只清空当前用户的会话记录
+// 路径中的ID可以是客户端的聊天ID，也可以是会话ID
+func (h *MessageHandler) DeleteChat(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID, err := resolveChatID(strconv.Itoa(userID), mux.Vars(r)["id"])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	event, err := h.messageService.ClearConversation(strconv.Itoa(userID), conversationID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(event)
+}
+
+// PinMessage 处理置顶消息请求
+func (h *MessageHandler) PinMessage(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	message, err := h.messageService.PinMessage(strconv.Itoa(userID), mux.Vars(r)["id"])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+// UnpinMessage 处理取消置顶消息请求
+func (h *MessageHandler) UnpinMessage(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	message, err := h.messageService.UnpinMessage(strconv.Itoa(userID), mux.Vars(r)["id"])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+// GetPinnedMessages 获取会话中的置顶消息，路径中的ID可以是客户端的聊天ID或会话ID
+func (h *MessageHandler) GetPinnedMessages(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID, err := resolveChatID(strconv.Itoa(userID), mux.Vars(r)["id"])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	messages, err := h.messageService.GetPinnedMessages(strconv.Itoa(userID), conversationID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"conversation_id": conversationID,
+		"messages":        messages,
+	})
+}
+
+// ScheduleMessage 处理创建定时消息请求
+func (h *MessageHandler) ScheduleMessage(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	var req ScheduleMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	message := &models.ScheduledMessage{
+		SenderID:   strconv.Itoa(userID),
+		ReceiverID: req.ReceiverID,
+		GroupID:    req.GroupID,
+		Type:       req.Type,
+		Content:    req.Content,
+		MediaURL:   req.MediaURL,
+		ReplyToID:  req.ReplyToID,
+		ThreadID:   req.ThreadID,
+		Mentions:   req.Mentions,
+		MentionAll: req.MentionAll,
+		SendAt:     req.SendAt,
+	}
+	if err := h.messageService.ScheduleMessage(message); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(message)
+}
+
+// ListScheduledMessages 处理获取尚未发送的定时消息请求
+func (h *MessageHandler) ListScheduledMessages(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	messages, err := h.messageService.ListScheduledMessages(strconv.Itoa(userID))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"messages": messages,
+	})
+}
+
+// EditScheduledMessage 处理修改定时消息请求
+func (h *MessageHandler) EditScheduledMessage(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	var req EditScheduledMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	message, err := h.messageService.EditScheduledMessage(strconv.Itoa(userID), mux.Vars(r)["id"], req.Content, req.SendAt)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+// CancelScheduledMessage 处理取消定时消息请求
+func (h *MessageHandler) CancelScheduledMessage(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	message, err := h.messageService.CancelScheduledMessage(strconv.Itoa(userID), mux.Vars(r)["id"])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+// resolveChatID 将客户端的聊天ID转换为会话ID，id已经是会话ID时直接返回
+func resolveChatID(userID, id string) (string, error) {
+	conversationID, err := models.ConversationIDFromChatID(userID, id)
+	if err != nil {
+		if _, parseErr := models.ParseConversationID(id); parseErr != nil {
+			return "", err
+		}
+		return id, nil
+	}
+	return conversationID, nil
+}
+
+// writeServiceError 将消息和会话服务返回的错误映射为HTTP状态码
+func writeServiceError(w http.ResponseWriter, err error) {
+	switch err {
+	case services.ErrNotParticipant, services.ErrNotGroupMember, services.ErrNotMessageSender,
+		services.ErrRecallForbidden, services.ErrMentionAllForbidden, services.ErrPinForbidden,
+		services.ErrAnnouncementForbidden:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case services.ErrMessageNotFound, services.ErrAnnouncementNotFound, services.ErrScheduledMessageNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case services.ErrEditConflict, services.ErrMessageRecalled, services.ErrTooManyPins, services.ErrTooManyReactions,
+		services.ErrScheduledMessageNotPending, services.ErrTooManyScheduled:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case services.ErrMessageNotEditable, services.ErrEditWindowExpired, services.ErrRecallWindowExpired:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case services.ErrSearchUnavailable, services.ErrSchedulingUnavailable, models.ErrSequenceConflict:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case models.ErrInvalidConversationID, services.ErrInvalidClientMsgID,
+		services.ErrMissingRecipient, services.ErrEmptyMessage, services.ErrInvalidCursor, services.ErrInvalidMediaURL,
+		services.ErrInvalidMessageType, services.ErrInvalidReply, services.ErrThreadNotSupported,
+		services.ErrInvalidReaction, services.ErrMentionNotSupported, services.ErrInvalidForward,
+		services.ErrInvalidSearchQuery, services.ErrInvalidAnnouncement, services.ErrInvalidSchedule:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"chat_app/server/models"
+	"chat_app/server/services"
+)
+
+// PresenceHandler 处理在线状态和隐私设置相关的API请求
+type PresenceHandler struct {
+	presenceService *services.PresenceService
+}
+
+// NewPresenceHandler 创建新的在线状态处理器
+func NewPresenceHandler(presenceService *services.PresenceService) *PresenceHandler {
+	return &PresenceHandler{presenceService: presenceService}
+}
+
+// RegisterRoutes 注册在线状态相关的路由
+func (h *PresenceHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/users/{id}/presence", h.GetPresence).Methods("GET")
+	r.HandleFunc("/settings/privacy", h.GetPrivacySettings).Methods("GET")
+	r.HandleFunc("/settings/privacy", h.UpdatePrivacySettings).Methods("PUT")
+}
+
+// GetPresence 获取用户的在线状态
+func (h *PresenceHandler) GetPresence(w http.ResponseWriter, r *http.Request) {
+	// 获取当前用户ID
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	// 获取目标用户ID
+	targetID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "无效的用户ID", http.StatusBadRequest)
+		return
+	}
+
+	presence, err := h.presenceService.GetPresence(userID, targetID)
+	if err != nil {
+		http.Error(w, "获取在线状态失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(presence)
+}
+
+// GetPrivacySettings 获取当前用户的隐私设置
+func (h *PresenceHandler) GetPrivacySettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := h.presenceService.GetPrivacySettings(userID)
+	if err != nil {
+		http.Error(w, "获取隐私设置失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// UpdatePrivacySettings 更新当前用户的隐私设置
+func (h *PresenceHandler) UpdatePrivacySettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		PresenceVisibility models.PresenceVisibility `json:"presence_visibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := h.presenceService.UpdatePresenceVisibility(userID, req.PresenceVisibility)
+	if err != nil {
+		http.Error(w, "更新隐私设置失败: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
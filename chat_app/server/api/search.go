@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chat_app/server/models"
+	"chat_app/server/services"
+)
+
+// SearchHandler 处理消息搜索相关的API请求
+type SearchHandler struct {
+	searchService *services.SearchService
+}
+
+// NewSearchHandler 创建新的消息搜索处理器
+func NewSearchHandler(searchService *services.SearchService) *SearchHandler {
+	return &SearchHandler{searchService: searchService}
+}
+
+// SearchMessages 在当前用户参与的会话中搜索消息
+// 查询参数：q为搜索词，conversation_id、sender_id、type、since、until为可选的过滤条件，
+// since和until为RFC3339格式的时间，使用before参数翻页
+func (h *SearchHandler) SearchMessages(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	searchQuery := &services.SearchQuery{
+		Text:           query.Get("q"),
+		ConversationID: query.Get("conversation_id"),
+		SenderID:       query.Get("sender_id"),
+		Type:           models.MessageType(query.Get("type")),
+		Before:         query.Get("before"),
+		Limit:          limit,
+	}
+
+	if value := query.Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "无效的since参数", http.StatusBadRequest)
+			return
+		}
+		searchQuery.Since = &since
+	}
+	if value := query.Get("until"); value != "" {
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "无效的until参数", http.StatusBadRequest)
+			return
+		}
+		searchQuery.Until = &until
+	}
+
+	result, err := h.searchService.Search(strconv.Itoa(userID), searchQuery)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -7,16 +7,21 @@ import (
 
 // Config 存储应用配置
 type Config struct {
-	Server   ServerConfig   `json:"server"`
-	Postgres PostgresConfig `json:"postgres"`
-	MongoDB  MongoDBConfig  `json:"mongodb"`
-	Redis    RedisConfig    `json:"redis"`
-	NATS     NATSConfig     `json:"nats"`
+	Server    ServerConfig    `json:"server"`
+	Postgres  PostgresConfig  `json:"postgres"`
+	MongoDB   MongoDBConfig   `json:"mongodb"`
+	Redis     RedisConfig     `json:"redis"`
+	NATS      NATSConfig      `json:"nats"`
+	WebSocket WebSocketConfig `json:"websocket"`
+	Message   MessageConfig   `json:"message"`
 }
 
 // ServerConfig 服务器配置
 type ServerConfig struct {
 	Port int `json:"port"`
+
+	// 内部监听地址，提供运行统计等只供运维访问的接口，为空时不启动
+	InternalAddr string `json:"internal_addr"`
 }
 
 // PostgresConfig PostgreSQL配置
@@ -47,6 +52,18 @@ type NATSConfig struct {
 	URL string `json:"url"`
 }
 
+// WebSocketConfig WebSocket配置
+type WebSocketConfig struct {
+	// 发送缓冲区已满时的处理策略：drop_oldest、disconnect或spill
+	SlowConsumerPolicy string `json:"slow_consumer_policy"`
+}
+
+// MessageConfig 消息功能配置
+type MessageConfig struct {
+	// 消息发送后允许编辑的分钟数
+	EditWindowMinutes int `json:"edit_window_minutes"`
+}
+
 // LoadConfig 从文件加载配置
 func LoadConfig(path string) (*Config, error) {
 	file, err := os.Open(path)
@@ -69,7 +86,8 @@ func LoadConfig(path string) (*Config, error) {
 func GetDefaultConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Port: 8080,
+			Port:         8080,
+			InternalAddr: "127.0.0.1:9090",
 		},
 		Postgres: PostgresConfig{
 			Host:     "localhost",
@@ -91,5 +109,11 @@ func GetDefaultConfig() *Config {
 		NATS: NATSConfig{
 			URL: "nats://localhost:4222",
 		},
+		WebSocket: WebSocketConfig{
+			SlowConsumerPolicy: "disconnect",
+		},
+		Message: MessageConfig{
+			EditWindowMinutes: 15,
+		},
 	}
-} 
\ No newline at end of file
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"chat_app/server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoConversationRepository MongoDB实现的会话仓库
+type MongoConversationRepository struct {
+	conversations *mongo.Collection
+	settings      *mongo.Collection
+}
+
+// NewConversationRepository 创建新的MongoDB会话仓库
+func NewConversationRepository(mongodb *MongoDB) models.ConversationRepository {
+	if mongodb == nil || mongodb.Client == nil {
+		return nil
+	}
+
+	return &MongoConversationRepository{
+		conversations: mongodb.Database.Collection("conversations"),
+		settings:      mongodb.Database.Collection("conversation_settings"),
+	}
+}
+
+// UpdateLastMessage 更新会话的最后一条消息
+func (r *MongoConversationRepository) UpdateLastMessage(conversation *models.Conversation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// 并发发送时后保存的消息可能先到达，只接受序列号更大的消息；
+	// 会话已存在且序列号不小于当前消息时，upsert会因_id冲突而失败
+	filter := bson.M{
+		"_id":      conversation.ID,
+		"last_seq": bson.M{"$lt": conversation.LastSeq},
+	}
+	set := bson.M{
+		"type":          conversation.Type,
+		"last_message":  conversation.LastMessage,
+		"last_seq":      conversation.LastSeq,
+		"last_activity": conversation.LastActivity,
+	}
+	if len(conversation.Participants) > 0 {
+		set["participants"] = conversation.Participants
+	}
+	if conversation.GroupID != "" {
+		set["group_id"] = conversation.GroupID
+	}
+
+	_, err := r.conversations.UpdateOne(ctx, filter, bson.M{"$set": set}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// UpdateLastMessagePreview 更新会话中最后一条消息的摘要
+func (r *MongoConversationRepository) UpdateLastMessagePreview(conversationID string, preview *models.MessagePreview) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.conversations.UpdateOne(
+		ctx,
+		bson.M{"_id": conversationID, "last_message.message_id": preview.MessageID},
+		bson.M{"$set": bson.M{"last_message": preview}},
+	)
+	return err
+}
+
+// ListConversations 按最后活跃时间倒序列出用户参与的会话
+func (r *MongoConversationRepository) ListConversations(userID string, groupConversationIDs []string, cursor *models.ConversationCursor, exclude []string, limit int) ([]*models.Conversation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conditions := []bson.M{
+		{"$or": []bson.M{
+			{"participants": userID},
+			{"_id": bson.M{"$in": groupConversationIDs}},
+		}},
+	}
+	if len(exclude) > 0 {
+		conditions = append(conditions, bson.M{"_id": bson.M{"$nin": exclude}})
+	}
+	if cursor != nil {
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{"last_activity": bson.M{"$lt": cursor.LastActivity}},
+			{"last_activity": cursor.LastActivity, "_id": bson.M{"$lt": cursor.ConversationID}},
+		}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "last_activity", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit))
+
+	result, err := r.conversations.Find(ctx, bson.M{"$and": conditions}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close(ctx)
+
+	var conversations []*models.Conversation
+	if err = result.All(ctx, &conversations); err != nil {
+		return nil, err
+	}
+
+	return conversations, nil
+}
+
+// GetConversations 根据ID获取会话
+func (r *MongoConversationRepository) GetConversations(ids []string) ([]*models.Conversation, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "last_activity", Value: -1}, {Key: "_id", Value: -1}})
+
+	result, err := r.conversations.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close(ctx)
+
+	var conversations []*models.Conversation
+	if err = result.All(ctx, &conversations); err != nil {
+		return nil, err
+	}
+
+	return conversations, nil
+}
+
+// GetSettingsForUser 获取用户的所有会话设置
+func (r *MongoConversationRepository) GetSettingsForUser(userID string) ([]*models.ConversationSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := r.settings.Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close(ctx)
+
+	var settings []*models.ConversationSettings
+	if err = result.All(ctx, &settings); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// GetSettings 获取用户对会话的设置，不存在时返回nil
+func (r *MongoConversationRepository) GetSettings(userID, conversationID string) (*models.ConversationSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var settings models.ConversationSettings
+	err := r.settings.FindOne(ctx, bson.M{
+		"user_id":         userID,
+		"conversation_id": conversationID,
+	}).Decode(&settings)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+// SaveSettings 保存用户对会话的设置
+func (r *MongoConversationRepository) SaveSettings(settings *models.ConversationSettings) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.settings.ReplaceOne(
+		ctx,
+		bson.M{"user_id": settings.UserID, "conversation_id": settings.ConversationID},
+		settings,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// ClearHistory 记录用户清空会话记录的时间
+func (r *MongoConversationRepository) ClearHistory(userID, conversationID string, clearedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.settings.UpdateOne(
+		ctx,
+		bson.M{"user_id": userID, "conversation_id": conversationID},
+		bson.M{
+			"$max": bson.M{"cleared_at": clearedAt},
+			"$set": bson.M{"updated_at": clearedAt},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
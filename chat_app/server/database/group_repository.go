@@ -40,21 +40,31 @@ func (r *SQLGroupRepository) CreateGroup(group *models.Group) error {
 	return err
 }
 
-// GetGroupByID 获取群组信息
+// GetGroupByID 获取群组信息，包括群组当前的公告
 func (r *SQLGroupRepository) GetGroupByID(id int) (*models.Group, error) {
 	query := `
-		SELECT id, name, created_by, created_at, updated_at
-		FROM groups
-		WHERE id = $1
+		SELECT g.id, g.name, g.created_by, g.created_at, g.updated_at,
+			a.id, a.title, a.body, a.author_id, a.created_at
+		FROM groups g
+		LEFT JOIN group_announcements a ON a.group_id = g.id
+		WHERE g.id = $1
 	`
 
 	group := &models.Group{}
+	var announcementID, authorID sql.NullInt64
+	var title, body sql.NullString
+	var announcedAt sql.NullTime
 	err := r.db.QueryRow(query, id).Scan(
 		&group.ID,
 		&group.Name,
 		&group.CreatedBy,
 		&group.CreatedAt,
 		&group.UpdatedAt,
+		&announcementID,
+		&title,
+		&body,
+		&authorID,
+		&announcedAt,
 	)
 
 	if err != nil {
@@ -64,6 +74,17 @@ func (r *SQLGroupRepository) GetGroupByID(id int) (*models.Group, error) {
 		return nil, err
 	}
 
+	if announcementID.Valid {
+		group.Announcement = &models.GroupAnnouncement{
+			ID:        int(announcementID.Int64),
+			GroupID:   group.ID,
+			Title:     title.String,
+			Body:      body.String,
+			AuthorID:  int(authorID.Int64),
+			CreatedAt: announcedAt.Time,
+		}
+	}
+
 	return group, nil
 }
 
@@ -102,6 +123,13 @@ func (r *SQLGroupRepository) DeleteGroup(id int) error {
 		return err
 	}
 
+	// 删除群组公告，已读记录随公告一并删除
+	_, err = tx.Exec("DELETE FROM group_announcements WHERE group_id = $1", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// 删除群组
 	_, err = tx.Exec("DELETE FROM groups WHERE id = $1", id)
 	if err != nil {
@@ -169,6 +197,161 @@ func (r *SQLGroupRepository) SearchGroups(query string, offset, limit int) ([]*m
 	return r.scanGroups(rows)
 }
 
+// SetAnnouncement 发布群组公告，旧公告及其已读记录被删除
+func (r *SQLGroupRepository) SetAnnouncement(announcement *models.GroupAnnouncement) error {
+	// 开启事务
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// 删除旧公告
+	_, err = tx.Exec("DELETE FROM group_announcements WHERE group_id = $1", announcement.GroupID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// 插入新公告
+	query := `
+		INSERT INTO group_announcements (group_id, title, body, author_id, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	announcement.CreatedAt = time.Now()
+
+	err = tx.QueryRow(
+		query,
+		announcement.GroupID,
+		announcement.Title,
+		announcement.Body,
+		announcement.AuthorID,
+		announcement.CreatedAt,
+	).Scan(&announcement.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// 提交事务
+	return tx.Commit()
+}
+
+// GetAnnouncement 获取群组当前的公告
+func (r *SQLGroupRepository) GetAnnouncement(groupID int) (*models.GroupAnnouncement, error) {
+	query := `
+		SELECT id, group_id, title, body, author_id, created_at
+		FROM group_announcements
+		WHERE group_id = $1
+	`
+
+	announcement := &models.GroupAnnouncement{}
+	err := r.db.QueryRow(query, groupID).Scan(
+		&announcement.ID,
+		&announcement.GroupID,
+		&announcement.Title,
+		&announcement.Body,
+		&announcement.AuthorID,
+		&announcement.CreatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // 群组没有公告
+		}
+		return nil, err
+	}
+
+	return announcement, nil
+}
+
+// DeleteAnnouncement 删除群组公告
+func (r *SQLGroupRepository) DeleteAnnouncement(groupID int) error {
+	_, err := r.db.Exec("DELETE FROM group_announcements WHERE group_id = $1", groupID)
+	return err
+}
+
+// MarkAnnouncementRead 记录成员已读公告
+func (r *SQLGroupRepository) MarkAnnouncementRead(announcementID, userID int) error {
+	query := `
+		INSERT INTO group_announcement_reads (announcement_id, user_id, read_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (announcement_id, user_id) DO NOTHING
+	`
+
+	_, err := r.db.Exec(query, announcementID, userID, time.Now())
+	return err
+}
+
+// GetAnnouncementReads 获取公告的已读成员及其已读时间
+func (r *SQLGroupRepository) GetAnnouncementReads(announcementID int) (map[int]time.Time, error) {
+	query := `SELECT user_id, read_at FROM group_announcement_reads WHERE announcement_id = $1`
+
+	rows, err := r.db.Query(query, announcementID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reads := make(map[int]time.Time)
+	for rows.Next() {
+		var userID int
+		var readAt time.Time
+		if err := rows.Scan(&userID, &readAt); err != nil {
+			return nil, err
+		}
+		reads[userID] = readAt
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reads, nil
+}
+
+// GetUnreadAnnouncements 获取用户所在群组中用户尚未确认已读的公告
+func (r *SQLGroupRepository) GetUnreadAnnouncements(userID int) ([]*models.GroupAnnouncement, error) {
+	query := `
+		SELECT a.id, a.group_id, a.title, a.body, a.author_id, a.created_at
+		FROM group_announcements a
+		JOIN group_members gm ON gm.group_id = a.group_id AND gm.user_id = $1
+		LEFT JOIN group_announcement_reads ar ON ar.announcement_id = a.id AND ar.user_id = $1
+		WHERE ar.announcement_id IS NULL
+		ORDER BY a.created_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var announcements []*models.GroupAnnouncement
+	for rows.Next() {
+		announcement := &models.GroupAnnouncement{}
+		err := rows.Scan(
+			&announcement.ID,
+			&announcement.GroupID,
+			&announcement.Title,
+			&announcement.Body,
+			&announcement.AuthorID,
+			&announcement.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		announcements = append(announcements, announcement)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return announcements, nil
+}
+
 // scanGroups 扫描查询结果并返回群组列表
 func (r *SQLGroupRepository) scanGroups(rows *sql.Rows) ([]*models.Group, error) {
 	var groups []*models.Group
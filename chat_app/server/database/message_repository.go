@@ -2,6 +2,8 @@ package database
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"time"
 
 	"chat_app/server/models"
@@ -30,13 +32,84 @@ func NewMessageRepository(mongodb *MongoDB) models.MessageRepository {
 	}
 }
 
-// SaveMessage 保存消息到MongoDB
+const (
+	// 会话序列号唯一索引的名称
+	conversationSeqIndex = "conversation_id_1_seq_1"
+
+	// 会话置顶位置的唯一索引，每条置顶消息占用会话的一个置顶位置
+	conversationPinSlotIndex = "conversation_id_1_pin_slot_1"
+
+	// 保存消息时序列号冲突的最大重试次数
+	maxSequenceRetries = 20
+)
+
+// SaveMessage 分配会话内的序列号并保存消息到MongoDB
+// 序列号取会话中已保存的最大序列号加一，由会话序列号唯一索引保证不重复，
+// 序列号为N+1的消息只能在N保存之后保存，插入失败也不会消耗序列号
 func (r *MongoMessageRepository) SaveMessage(message *models.Message) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err := r.collection.InsertOne(ctx, message)
-	return err
+	for attempt := 0; attempt < maxSequenceRetries; attempt++ {
+		lastSeq, err := lastSequence(ctx, r.collection, message.ConversationID)
+		if err != nil {
+			return err
+		}
+		message.Seq = lastSeq + 1
+
+		_, err = r.collection.InsertOne(ctx, message)
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		if !isDuplicateKeyOn(err, conversationSeqIndex) {
+			return models.ErrDuplicateMessage
+		}
+		// 并发保存的消息占用了这个序列号，重新读取最大序列号
+	}
+
+	message.Seq = 0
+	return models.ErrSequenceConflict
+}
+
+// lastSequence 获取会话中已保存的最大序列号，会话没有消息时返回0
+func lastSequence(ctx context.Context, collection *mongo.Collection, conversationID string) (int64, error) {
+	opts := options.FindOne().
+		SetSort(bson.M{"seq": -1}).
+		SetProjection(bson.M{"seq": 1})
+
+	var last struct {
+		Seq int64 `bson:"seq"`
+	}
+	// 带上seq的条件才能使用会话序列号的部分索引
+	err := collection.FindOne(ctx, bson.M{
+		"conversation_id": conversationID,
+		"seq":             bson.M{"$exists": true},
+	}, opts).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return last.Seq, nil
+}
+
+// isDuplicateKeyOn 检查错误是否为指定唯一索引上的重复键错误
+func isDuplicateKeyOn(err error, index string) bool {
+	var writeErr mongo.WriteException
+	if !errors.As(err, &writeErr) {
+		return false
+	}
+	for _, e := range writeErr.WriteErrors {
+		if e.Code == 11000 && strings.Contains(e.Message, "index: "+index+" ") {
+			return true
+		}
+	}
+	return false
 }
 
 // GetMessageByID 根据ID获取消息
@@ -51,6 +124,9 @@ func (r *MongoMessageRepository) GetMessageByID(id string) (*models.Message, err
 
 	var message models.Message
 	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -59,7 +135,7 @@ func (r *MongoMessageRepository) GetMessageByID(id string) (*models.Message, err
 }
 
 // GetMessagesBetweenUsers 获取两个用户之间的消息历史
-func (r *MongoMessageRepository) GetMessagesBetweenUsers(userID1, userID2 string, limit, offset int) ([]*models.Message, error) {
+func (r *MongoMessageRepository) GetMessagesBetweenUsers(userID1, userID2 string, viewer *models.MessageViewer, limit, offset int) ([]*models.Message, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -76,6 +152,7 @@ func (r *MongoMessageRepository) GetMessagesBetweenUsers(userID1, userID2 string
 			},
 		},
 	}
+	applyViewer(filter, viewer)
 
 	// 设置排序、分页
 	opts := options.Find().
@@ -98,11 +175,12 @@ func (r *MongoMessageRepository) GetMessagesBetweenUsers(userID1, userID2 string
 }
 
 // GetGroupMessages 获取群组消息历史
-func (r *MongoMessageRepository) GetGroupMessages(groupID string, limit, offset int) ([]*models.Message, error) {
+func (r *MongoMessageRepository) GetGroupMessages(groupID string, viewer *models.MessageViewer, limit, offset int) ([]*models.Message, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	filter := bson.M{"group_id": groupID}
+	applyViewer(filter, viewer)
 
 	opts := options.Find().
 		SetSort(bson.M{"timestamp": -1}).
@@ -222,3 +300,636 @@ func (r *MongoMessageRepository) DeleteGroupMessages(groupID string) error {
 	_, err := r.collection.DeleteMany(ctx, bson.M{"group_id": groupID})
 	return err
 }
+
+// HideMessage 对用户隐藏消息
+func (r *MongoMessageRepository) HideMessage(id, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID},
+		bson.M{"$addToSet": bson.M{"hidden_for": userID}},
+	)
+	return err
+}
+
+// GetHiddenMessageIDs 获取ids中对用户隐藏的消息ID，无效的ID被忽略
+func (r *MongoMessageRepository) GetHiddenMessageIDs(userID string, ids []string) ([]string, error) {
+	objectIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+			objectIDs = append(objectIDs, objectID)
+		}
+	}
+	if len(objectIDs) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := r.collection.Find(
+		ctx,
+		bson.M{"_id": bson.M{"$in": objectIDs}, "hidden_for": userID},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var hidden []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err = cursor.All(ctx, &hidden); err != nil {
+		return nil, err
+	}
+
+	hiddenIDs := make([]string, len(hidden))
+	for i, message := range hidden {
+		hiddenIDs[i] = message.ID.Hex()
+	}
+	return hiddenIDs, nil
+}
+
+// applyViewer 在查询条件中排除用户删除的消息和清空时间之前的消息
+// 查询条件中不能已经包含hidden_for或timestamp的顶层条件
+func applyViewer(filter bson.M, viewer *models.MessageViewer) {
+	if viewer == nil {
+		return
+	}
+
+	filter["hidden_for"] = bson.M{"$ne": viewer.UserID}
+	if viewer.ClearedAt != nil {
+		filter["timestamp"] = bson.M{"$gt": *viewer.ClearedAt}
+	}
+}
+
+// GetMessagesSince 获取会话中序列号大于sinceSeq的消息
+func (r *MongoMessageRepository) GetMessagesSince(conversationID string, sinceSeq int64, viewer *models.MessageViewer, limit int) ([]*models.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"conversation_id": conversationID,
+		"seq":             bson.M{"$gt": sinceSeq},
+	}
+	applyViewer(filter, viewer)
+
+	opts := options.Find().
+		SetSort(bson.M{"seq": 1}).
+		SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []*models.Message
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// GetMessageByClientMsgID 根据客户端消息ID获取消息，不存在时返回nil
+func (r *MongoMessageRepository) GetMessageByClientMsgID(senderID, clientMsgID string) (*models.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var message models.Message
+	err := r.collection.FindOne(ctx, bson.M{
+		"sender_id":     senderID,
+		"client_msg_id": clientMsgID,
+	}).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// MarkMessageDelivered 将消息标记为已送达
+func (r *MongoMessageRepository) MarkMessageDelivered(id string, deliveredAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID, "status": models.MessageStatusServerAck},
+		bson.M{"$set": bson.M{
+			"status":       models.MessageStatusDelivered,
+			"delivered_at": deliveredAt,
+		}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// GetLastMessage 获取会话中序列号最大的消息，会话没有消息时返回nil
+func (r *MongoMessageRepository) GetLastMessage(conversationID string) (*models.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.FindOne().SetSort(bson.M{"seq": -1})
+
+	var message models.Message
+	err := r.collection.FindOne(ctx, bson.M{"conversation_id": conversationID}, opts).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// SummarizeUnread 统计用户在多个会话中的未读消息数和未读的@
+// 每个会话的统计是一个独立的子管道，通过$unionWith合并为一次查询，
+// 子管道可以使用(conversation_id, seq)索引，并在limit条消息处停止
+func (r *MongoMessageRepository) SummarizeUnread(userID string, queries []models.UnreadQuery, limit int) (map[string]models.UnreadSummary, error) {
+	summaries := make(map[string]models.UnreadSummary, len(queries))
+	if len(queries) == 0 {
+		return summaries, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var branches []mongo.Pipeline
+	for _, query := range queries {
+		unread := bson.M{
+			"conversation_id": query.ConversationID,
+			"seq":             bson.M{"$gt": query.AfterSeq},
+			"sender_id":       bson.M{"$ne": userID},
+			"hidden_for":      bson.M{"$ne": userID},
+		}
+		branches = append(branches, mongo.Pipeline{
+			{{Key: "$match", Value: unread}},
+			{{Key: "$limit", Value: limit}},
+			{{Key: "$group", Value: bson.M{"_id": "$conversation_id", "count": bson.M{"$sum": 1}}}},
+		})
+
+		if query.CheckMentions {
+			mention := mentionFilter(userID)
+			mention["conversation_id"] = query.ConversationID
+			mention["seq"] = bson.M{"$gt": query.AfterSeq}
+			branches = append(branches, mongo.Pipeline{
+				{{Key: "$match", Value: mention}},
+				{{Key: "$limit", Value: 1}},
+				{{Key: "$project", Value: bson.M{"_id": "$conversation_id", "mentioned": bson.M{"$literal": true}}}},
+			})
+		}
+	}
+
+	pipeline := branches[0]
+	for _, branch := range branches[1:] {
+		pipeline = append(pipeline, bson.D{{Key: "$unionWith", Value: bson.M{
+			"coll":     r.collection.Name(),
+			"pipeline": branch,
+		}}})
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		ConversationID string `bson:"_id"`
+		Count          int    `bson:"count"`
+		Mentioned      bool   `bson:"mentioned"`
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		summary := summaries[row.ConversationID]
+		summary.Count += row.Count
+		summary.MentionedMe = summary.MentionedMe || row.Mentioned
+		summaries[row.ConversationID] = summary
+	}
+	return summaries, nil
+}
+
+// GetMessagesBefore 获取会话中早于cursor的最近limit条消息，按时间升序排列
+func (r *MongoMessageRepository) GetMessagesBefore(conversationID string, cursor *models.MessageCursor, viewer *models.MessageViewer, limit int) ([]*models.Message, error) {
+	filter := bson.M{"conversation_id": conversationID}
+	if cursor != nil {
+		filter["$or"] = []bson.M{
+			{"timestamp": bson.M{"$lt": cursor.Timestamp}},
+			{"timestamp": cursor.Timestamp, "_id": bson.M{"$lt": cursor.ID}},
+		}
+	}
+	applyViewer(filter, viewer)
+
+	messages, err := r.findConversationMessages(filter, -1, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按时间倒序查询，返回前反转为升序
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// GetMessagesAfter 获取会话中晚于cursor的limit条消息，按时间升序排列
+func (r *MongoMessageRepository) GetMessagesAfter(conversationID string, cursor *models.MessageCursor, viewer *models.MessageViewer, limit int) ([]*models.Message, error) {
+	filter := bson.M{
+		"conversation_id": conversationID,
+		"$or": []bson.M{
+			{"timestamp": bson.M{"$gt": cursor.Timestamp}},
+			{"timestamp": cursor.Timestamp, "_id": bson.M{"$gt": cursor.ID}},
+		},
+	}
+	applyViewer(filter, viewer)
+
+	return r.findConversationMessages(filter, 1, limit)
+}
+
+// findConversationMessages 按(timestamp, _id)排序查询会话、话题或@消息
+// 使用(conversation_id, timestamp, _id)、(thread_id, timestamp, _id)或@相关的索引
+func (r *MongoMessageRepository) findConversationMessages(filter bson.M, order, limit int) ([]*models.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: order}, {Key: "_id", Value: order}}).
+		SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []*models.Message
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// EditMessage 修改消息内容并保存旧版本
+func (r *MongoMessageRepository) EditMessage(id, oldContent, newContent string, editedAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	// 以旧内容作为条件，避免并发编辑时丢失中间版本
+	result, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID, "content": oldContent},
+		bson.M{
+			"$set": bson.M{
+				"content":   newContent,
+				"edited_at": editedAt,
+			},
+			"$push": bson.M{
+				"edits": models.MessageEdit{Content: oldContent, ReplacedAt: editedAt},
+			},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// RecallMessage 撤回消息，将消息替换为墓碑
+func (r *MongoMessageRepository) RecallMessage(id, recalledBy string, recalledAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID, "recalled": bson.M{"$ne": true}},
+		bson.M{
+			"$set": bson.M{
+				"content":     "",
+				"recalled":    true,
+				"recalled_by": recalledBy,
+				"recalled_at": recalledAt,
+			},
+			"$unset": bson.M{
+				"media_url":   "",
+				"metadata":    "",
+				"edits":       "",
+				"edited_at":   "",
+				"reactions":   "",
+				"chat_record": "",
+				"media_refs":  "",
+				"pinned_by":   "",
+				"pinned_at":   "",
+				"pin_slot":    "",
+			},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// PinMessage 置顶消息，已撤回和已置顶的消息不会被修改
+// 置顶的消息占用会话中空闲的置顶位置，由会话置顶位置唯一索引保证并发置顶时也不会超过maxPinned条
+func (r *MongoMessageRepository) PinMessage(id, conversationID, pinnedBy string, pinnedAt time.Time, maxPinned int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	for attempt := 0; attempt < maxPinned; attempt++ {
+		slot, err := freeSlot(ctx, r.collection, bson.M{"conversation_id": conversationID}, "pin_slot", maxPinned)
+		if err != nil {
+			return false, err
+		}
+		if slot < 0 {
+			return false, nil
+		}
+
+		result, err := r.collection.UpdateOne(
+			ctx,
+			bson.M{
+				"_id":             objectID,
+				"conversation_id": conversationID,
+				"recalled":        bson.M{"$ne": true},
+				"pinned_at":       bson.M{"$exists": false},
+			},
+			bson.M{"$set": bson.M{"pinned_by": pinnedBy, "pinned_at": pinnedAt, "pin_slot": slot}},
+		)
+		if err == nil {
+			return result.ModifiedCount > 0, nil
+		}
+		if !isDuplicateKeyOn(err, conversationPinSlotIndex) {
+			return false, err
+		}
+		// 并发置顶的消息占用了这个位置，重新查找空闲位置
+	}
+
+	return false, nil
+}
+
+// UnpinMessage 取消置顶消息
+func (r *MongoMessageRepository) UnpinMessage(id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID, "pinned_at": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"pinned_by": "", "pinned_at": "", "pin_slot": ""}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// GetPinnedMessages 获取会话中的置顶消息，最近置顶的排在前面
+func (r *MongoMessageRepository) GetPinnedMessages(conversationID string, viewer *models.MessageViewer, limit int) ([]*models.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"conversation_id": conversationID,
+		"pinned_at":       bson.M{"$exists": true},
+	}
+	applyViewer(filter, viewer)
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "pinned_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []*models.Message
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// RecallReplySnippets 清空引用了该消息的回复中的摘要
+func (r *MongoMessageRepository) RecallReplySnippets(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.collection.UpdateMany(
+		ctx,
+		bson.M{"reply_to.message_id": id},
+		bson.M{"$set": bson.M{
+			"reply_to.content":  "",
+			"reply_to.recalled": true,
+		}},
+	)
+	return err
+}
+
+// IsMediaReferenced 检查是否有消息引用了该媒体地址，撤回的消息不再保存媒体引用
+func (r *MongoMessageRepository) IsMediaReferenced(mediaURL string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := r.collection.FindOne(
+		ctx,
+		bson.M{"media_refs": mediaURL},
+		options.FindOne().SetProjection(bson.M{"_id": 1}),
+	).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// AddThreadReply 增加话题的回复数并将回复者加入话题参与者
+func (r *MongoMessageRepository) AddThreadReply(rootID, userID string, repliedAt time.Time) (*models.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(rootID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var root models.Message
+	err = r.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": objectID},
+		bson.M{
+			"$inc":      bson.M{"thread_reply_count": 1},
+			"$max":      bson.M{"thread_last_reply_at": repliedAt},
+			"$addToSet": bson.M{"thread_participants": userID},
+		},
+		opts,
+	).Decode(&root)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &root, nil
+}
+
+// GetThreadReplies 获取话题中晚于cursor的回复，按时间升序排列
+func (r *MongoMessageRepository) GetThreadReplies(rootID string, cursor *models.MessageCursor, viewer *models.MessageViewer, limit int) ([]*models.Message, error) {
+	filter := bson.M{"thread_id": rootID}
+	if cursor != nil {
+		filter["$or"] = []bson.M{
+			{"timestamp": bson.M{"$gt": cursor.Timestamp}},
+			{"timestamp": cursor.Timestamp, "_id": bson.M{"$gt": cursor.ID}},
+		}
+	}
+	applyViewer(filter, viewer)
+
+	return r.findConversationMessages(filter, 1, limit)
+}
+
+// AddReaction 添加用户的表情回应，重复回应同一表情不会重复记录
+// 表情种类数在同一次原子更新中检查，并发添加不同表情时也不会超过maxKinds
+func (r *MongoMessageRepository) AddReaction(id, emoji, userID string, maxKinds int) (*models.Message, error) {
+	return r.updateReaction(id, bson.M{"$or": []bson.M{
+		{"reactions." + emoji: bson.M{"$exists": true}},
+		{"$expr": bson.M{"$lt": bson.A{
+			bson.M{"$size": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$reactions", bson.M{}}}}},
+			maxKinds,
+		}}},
+	}}, bson.M{"$addToSet": bson.M{"reactions." + emoji: userID}})
+}
+
+// RemoveReaction 移除用户的表情回应，没有人回应的表情会被删除
+func (r *MongoMessageRepository) RemoveReaction(id, emoji, userID string) (*models.Message, error) {
+	message, err := r.updateReaction(id, nil, bson.M{"$pull": bson.M{"reactions." + emoji: userID}})
+	if err != nil || message == nil || len(message.Reactions[emoji]) > 0 {
+		return message, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// 并发添加了同一表情时条件不满足，不会删除
+	_, err = r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": message.ID, "reactions." + emoji: bson.M{"$size": 0}},
+		bson.M{"$unset": bson.M{"reactions." + emoji: ""}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	delete(message.Reactions, emoji)
+	return message, nil
+}
+
+// updateReaction 更新消息的表情回应并返回更新后的消息，消息不存在、已撤回或不满足condition时返回nil
+func (r *MongoMessageRepository) updateReaction(id string, condition, update bson.M) (*models.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	filter := bson.M{"_id": objectID, "recalled": bson.M{"$ne": true}}
+	for key, value := range condition {
+		filter[key] = value
+	}
+
+	var message models.Message
+	err = r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// mentionFilter 返回@了用户的消息的查询条件，不包括用户自己发送、已撤回和用户删除的消息
+func mentionFilter(userID string) bson.M {
+	return bson.M{
+		"sender_id":  bson.M{"$ne": userID},
+		"hidden_for": bson.M{"$ne": userID},
+		"recalled":   bson.M{"$ne": true},
+		"$or": []bson.M{
+			{"mentions": userID},
+			{"mention_all": true},
+		},
+	}
+}
+
+// GetMentions 获取群组中@了用户的消息，按时间倒序排列
+func (r *MongoMessageRepository) GetMentions(userID string, groupIDs []string, cursor *models.MessageCursor, limit int) ([]*models.Message, error) {
+	filter := mentionFilter(userID)
+	filter["group_id"] = bson.M{"$in": groupIDs}
+	if cursor != nil {
+		filter["$and"] = []bson.M{{"$or": []bson.M{
+			{"timestamp": bson.M{"$lt": cursor.Timestamp}},
+			{"timestamp": cursor.Timestamp, "_id": bson.M{"$lt": cursor.ID}},
+		}}}
+	}
+
+	return r.findConversationMessages(filter, -1, limit)
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"chat_app/server/models"
+	"chat_app/server/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoMessageSearchIndex 基于n-gram词元的MongoDB消息全文索引
+// 词元保存在消息文档的search_tokens字段中，MongoDB自带的文本索引不支持中文分词
+type MongoMessageSearchIndex struct {
+	collection *mongo.Collection
+}
+
+// NewMessageSearchIndex 创建新的MongoDB消息全文索引
+func NewMessageSearchIndex(mongodb *MongoDB) models.MessageSearchIndex {
+	if mongodb == nil || mongodb.Client == nil {
+		return nil
+	}
+
+	return &MongoMessageSearchIndex{
+		collection: mongodb.Database.Collection("messages"),
+	}
+}
+
+// IndexMessage 保存消息内容的词元
+func (i *MongoMessageSearchIndex) IndexMessage(message *models.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := i.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": message.ID},
+		bson.M{"$set": bson.M{"search_tokens": utils.IndexTokens(message.Content)}},
+	)
+	return err
+}
+
+// RemoveMessage 删除消息的词元
+func (i *MongoMessageSearchIndex) RemoveMessage(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = i.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID},
+		bson.M{"$unset": bson.M{"search_tokens": ""}},
+	)
+	return err
+}
+
+// Search 先用词元索引缩小范围，再按关键词匹配消息内容，避免n-gram组合造成的误匹配
+func (i *MongoMessageSearchIndex) Search(query *models.MessageSearchQuery) ([]*models.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conditions := []bson.M{
+		{"search_tokens": bson.M{"$all": utils.QueryTokens(query.Text)}},
+		{"type": bson.M{"$ne": models.SystemMessage}},
+		{"recalled": bson.M{"$ne": true}},
+		{"hidden_for": bson.M{"$ne": query.UserID}},
+	}
+	for _, term := range utils.SearchTerms(query.Text) {
+		conditions = append(conditions, bson.M{"content": primitive.Regex{
+			Pattern: regexp.QuoteMeta(term),
+			Options: "i",
+		}})
+	}
+
+	// 只搜索用户参与的私聊和所在群组的消息
+	if query.ConversationID != "" {
+		conditions = append(conditions, bson.M{"conversation_id": query.ConversationID})
+	} else {
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{"group_id": bson.M{"$in": query.GroupIDs}},
+			{"group_id": bson.M{"$in": []interface{}{"", nil}}, "sender_id": query.UserID},
+			{"group_id": bson.M{"$in": []interface{}{"", nil}}, "receiver_id": query.UserID},
+		}})
+	}
+	if len(query.ClearedAt) > 0 {
+		cleared := make([]bson.M, 0, len(query.ClearedAt))
+		for conversationID, clearedAt := range query.ClearedAt {
+			cleared = append(cleared, bson.M{
+				"conversation_id": conversationID,
+				"timestamp":       bson.M{"$lte": clearedAt},
+			})
+		}
+		conditions = append(conditions, bson.M{"$nor": cleared})
+	}
+
+	if query.SenderID != "" {
+		conditions = append(conditions, bson.M{"sender_id": query.SenderID})
+	}
+	if query.Type != "" {
+		conditions = append(conditions, bson.M{"type": query.Type})
+	}
+	if query.Since != nil {
+		conditions = append(conditions, bson.M{"timestamp": bson.M{"$gte": *query.Since}})
+	}
+	if query.Until != nil {
+		conditions = append(conditions, bson.M{"timestamp": bson.M{"$lt": *query.Until}})
+	}
+	if query.Cursor != nil {
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{"timestamp": bson.M{"$lt": query.Cursor.Timestamp}},
+			{"timestamp": query.Cursor.Timestamp, "_id": bson.M{"$lt": query.Cursor.ID}},
+		}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(query.Limit)).
+		SetProjection(bson.M{"search_tokens": 0})
+
+	cursor, err := i.collection.Find(ctx, bson.M{"$and": conditions}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []*models.Message
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
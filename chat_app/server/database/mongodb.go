@@ -6,8 +6,11 @@ import (
 	"time"
 
 	"chat_app/server/config"
+	"chat_app/server/models"
+	"chat_app/server/utils"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -89,6 +92,8 @@ func (m *MongoDB) InitCollections() error {
 	hasSenderReceiverIndex := false
 	hasGroupIndex := false
 	hasTimestampIndex := false
+	hasConversationSeqIndex := false
+	hasClientMsgIDIndex := false
 
 	for _, idx := range existingIndexes {
 		if idx["name"] == "sender_id_1_receiver_id_1" {
@@ -100,6 +105,12 @@ func (m *MongoDB) InitCollections() error {
 		if idx["name"] == "timestamp_1" {
 			hasTimestampIndex = true
 		}
+		if idx["name"] == "conversation_id_1_seq_1" {
+			hasConversationSeqIndex = true
+		}
+		if idx["name"] == "sender_id_1_client_msg_id_1" {
+			hasClientMsgIDIndex = true
+		}
 	}
 
 	// 创建缺失的索引
@@ -140,9 +151,771 @@ func (m *MongoDB) InitCollections() error {
 		fmt.Println("创建时间戳索引成功")
 	}
 
+	// 会话序列号唯一索引，旧消息没有序列号，不参与唯一约束
+	if !hasConversationSeqIndex {
+		_, err = messagesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "conversation_id", Value: 1},
+				{Key: "seq", Value: 1},
+			},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"seq": bson.M{"$exists": true}}),
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println("创建会话序列号索引成功")
+	}
+
+	// 客户端消息ID在同一发送者内唯一，用于重试去重
+	if !hasClientMsgIDIndex {
+		_, err = messagesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "sender_id", Value: 1},
+				{Key: "client_msg_id", Value: 1},
+			},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"client_msg_id": bson.M{"$type": "string"}}),
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println("创建客户端消息ID索引成功")
+	}
+
+	// 游标分页按会话过滤，按时间戳和ID排序
+	err = ensureIndex(ctx, messagesCollection, "conversation_id_1_timestamp_1__id_1", mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "conversation_id", Value: 1},
+			{Key: "timestamp", Value: 1},
+			{Key: "_id", Value: 1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// 话题回复按话题分页查询
+	err = ensureIndex(ctx, messagesCollection, "thread_id_1_timestamp_1__id_1", mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "thread_id", Value: 1},
+			{Key: "timestamp", Value: 1},
+			{Key: "_id", Value: 1},
+		},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"thread_id": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// 撤回消息时查找引用了该消息的回复
+	err = ensureIndex(ctx, messagesCollection, "reply_to.message_id_1", mongo.IndexModel{
+		Keys:    bson.D{{Key: "reply_to.message_id", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"reply_to": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// 查询会话中的置顶消息
+	err = ensureIndex(ctx, messagesCollection, "conversation_id_1_pinned_at_-1", mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "conversation_id", Value: 1},
+			{Key: "pinned_at", Value: -1},
+		},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"pinned_at": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// 查询@了用户的消息
+	err = ensureIndex(ctx, messagesCollection, "mentions_1_timestamp_-1", mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "mentions", Value: 1},
+			{Key: "timestamp", Value: -1},
+		},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"mentions": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// 查询群组中@所有人的消息
+	err = ensureIndex(ctx, messagesCollection, "group_id_1_timestamp_-1", mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "group_id", Value: 1},
+			{Key: "timestamp", Value: -1},
+		},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"mention_all": true}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// 为早期没有会话ID的消息补充会话ID，游标分页依赖该字段
+	err = runMigration(ctx, m.Database, "backfill_conversation_ids", func(ctx context.Context) error {
+		return backfillConversationIDs(ctx, messagesCollection)
+	})
+	if err != nil {
+		return err
+	}
+
+	// 消息搜索按词元过滤，按时间倒序排序
+	err = ensureIndex(ctx, messagesCollection, "search_tokens_1_timestamp_-1", mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "search_tokens", Value: 1},
+			{Key: "timestamp", Value: -1},
+		},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"search_tokens": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// 为建立搜索索引之前的消息生成词元，并为单词补充前缀词元
+	err = runMigration(ctx, m.Database, "backfill_search_tokens_with_prefixes", func(ctx context.Context) error {
+		return backfillSearchTokens(ctx, messagesCollection)
+	})
+	if err != nil {
+		return err
+	}
+
+	// 撤回消息时按媒体地址检查文件是否仍被其他消息引用
+	err = ensureIndex(ctx, messagesCollection, "media_refs_1", mongo.IndexModel{
+		Keys:    bson.D{{Key: "media_refs", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"media_refs": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+	err = runMigration(ctx, m.Database, "backfill_media_refs", func(ctx context.Context) error {
+		return backfillMediaRefs(ctx, messagesCollection)
+	})
+	if err != nil {
+		return err
+	}
+
+	// 置顶消息各占用会话的一个置顶位置，限制会话中的置顶消息数
+	err = ensureIndex(ctx, messagesCollection, conversationPinSlotIndex, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "conversation_id", Value: 1},
+			{Key: "pin_slot", Value: 1},
+		},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"pin_slot": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+	err = runMigration(ctx, m.Database, "backfill_pin_slots", func(ctx context.Context) error {
+		return backfillPinSlots(ctx, messagesCollection)
+	})
+	if err != nil {
+		return err
+	}
+
+	// 为没有序列号的旧会话分配序列号，之后由消息补全会话列表，未读数依赖这两项
+	err = runMigration(ctx, m.Database, "backfill_message_seq", func(ctx context.Context) error {
+		return backfillSequences(ctx, messagesCollection)
+	})
+	if err != nil {
+		return err
+	}
+	err = runMigration(ctx, m.Database, "backfill_conversations", func(ctx context.Context) error {
+		return backfillConversations(ctx, messagesCollection, m.Database.Collection("conversations"))
+	})
+	if err != nil {
+		return err
+	}
+
+	// 每个用户在每个会话中只有一个已读位置
+	err = ensureIndex(ctx, m.Database.Collection("read_states"), "conversation_id_1_user_id_1", mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "conversation_id", Value: 1},
+			{Key: "user_id", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// 会话列表按参与者过滤，按最后活跃时间排序
+	err = ensureIndex(ctx, m.Database.Collection("conversations"), "participants_1_last_activity_-1__id_-1", mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "participants", Value: 1},
+			{Key: "last_activity", Value: -1},
+			{Key: "_id", Value: -1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// 每个用户对每个会话只有一份设置
+	err = ensureIndex(ctx, m.Database.Collection("conversation_settings"), "user_id_1_conversation_id_1", mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "user_id", Value: 1},
+			{Key: "conversation_id", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// 发送节点按状态和计划发送时间领取到期的定时消息
+	scheduledCollection := m.Database.Collection("scheduled_messages")
+	err = ensureIndex(ctx, scheduledCollection, "status_1_send_at_1", mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "send_at", Value: 1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// 按发送者列出定时消息
+	err = ensureIndex(ctx, scheduledCollection, "sender_id_1_status_1_send_at_1", mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "sender_id", Value: 1},
+			{Key: "status", Value: 1},
+			{Key: "send_at", Value: 1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// 等待发送的定时消息各占用发送者的一个等待位置，限制每个用户同时等待发送的消息数
+	err = ensureIndex(ctx, scheduledCollection, scheduledPendingSlotIndex, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "sender_id", Value: 1},
+			{Key: "pending_slot", Value: 1},
+		},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"pending_slot": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// backfillConversationIDs 为没有conversation_id的消息补充会话ID
+func backfillConversationIDs(ctx context.Context, collection *mongo.Collection) error {
+	const batchSize = 500
+
+	filter := bson.M{"conversation_id": bson.M{"$exists": false}}
+	opts := options.Find().
+		SetProjection(bson.M{"sender_id": 1, "receiver_id": 1, "group_id": 1}).
+		SetBatchSize(batchSize)
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	var batch []mongo.WriteModel
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := collection.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+		updated += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var message models.Message
+		if err := cursor.Decode(&message); err != nil {
+			return err
+		}
+		if message.ReceiverID == "" && message.GroupID == "" {
+			continue
+		}
+
+		batch = append(batch, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": message.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"conversation_id": message.ConversationKey()}}))
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if updated > 0 {
+		fmt.Printf("为 %d 条消息补充会话ID\n", updated)
+	}
+	return nil
+}
+
+// backfillSearchTokens 为所有可搜索的消息重新生成搜索词元，包括早期只索引了完整单词的消息
+func backfillSearchTokens(ctx context.Context, collection *mongo.Collection) error {
+	const batchSize = 500
+
+	filter := bson.M{
+		"type":     bson.M{"$ne": models.SystemMessage},
+		"recalled": bson.M{"$ne": true},
+	}
+	opts := options.Find().
+		SetProjection(bson.M{"content": 1}).
+		SetBatchSize(batchSize)
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	var batch []mongo.WriteModel
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := collection.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+		updated += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var message models.Message
+		if err := cursor.Decode(&message); err != nil {
+			return err
+		}
+
+		batch = append(batch, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": message.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"search_tokens": utils.IndexTokens(message.Content)}}))
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if updated > 0 {
+		fmt.Printf("为 %d 条消息生成搜索词元\n", updated)
+	}
+	return nil
+}
+
+// backfillMediaRefs 为带有媒体或聊天记录的未撤回消息生成媒体引用
+func backfillMediaRefs(ctx context.Context, collection *mongo.Collection) error {
+	const batchSize = 500
+
+	filter := bson.M{
+		"media_refs": bson.M{"$exists": false},
+		"recalled":   bson.M{"$ne": true},
+		"$or": []bson.M{
+			{"media_url": bson.M{"$exists": true}},
+			{"chat_record": bson.M{"$exists": true}},
+		},
+	}
+	opts := options.Find().
+		SetProjection(bson.M{"media_url": 1, "chat_record": 1}).
+		SetBatchSize(batchSize)
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	var batch []mongo.WriteModel
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := collection.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+		updated += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var message models.Message
+		if err := cursor.Decode(&message); err != nil {
+			return err
+		}
+
+		refs := message.MediaReferences()
+		if len(refs) == 0 {
+			continue
+		}
+		batch = append(batch, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": message.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"media_refs": refs}}))
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if updated > 0 {
+		fmt.Printf("为 %d 条消息生成媒体引用\n", updated)
+	}
+	return nil
+}
+
+// backfillPinSlots 按置顶时间为建立置顶位置之前置顶的消息分配会话中的置顶位置
+func backfillPinSlots(ctx context.Context, collection *mongo.Collection) error {
+	filter := bson.M{
+		"conversation_id": bson.M{"$exists": true},
+		"pinned_at":       bson.M{"$exists": true},
+		"pin_slot":        bson.M{"$exists": false},
+	}
+	opts := options.Find().
+		SetSort(bson.D{
+			{Key: "conversation_id", Value: 1},
+			{Key: "pinned_at", Value: 1},
+		}).
+		SetProjection(bson.M{"conversation_id": 1})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var batch []mongo.WriteModel
+	var current string
+	slot := 0
+	for cursor.Next(ctx) {
+		var message struct {
+			ID             primitive.ObjectID `bson:"_id"`
+			ConversationID string             `bson:"conversation_id"`
+		}
+		if err := cursor.Decode(&message); err != nil {
+			return err
+		}
+
+		if message.ConversationID != current {
+			current = message.ConversationID
+			slot = 0
+		}
+		batch = append(batch, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": message.ID, "pin_slot": bson.M{"$exists": false}}).
+			SetUpdate(bson.M{"$set": bson.M{"pin_slot": slot}}))
+		slot++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	// 迁移期间新置顶的消息可能占用了同一位置，这些旧置顶消息保持没有位置
+	result, err := collection.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	if result != nil && result.ModifiedCount > 0 {
+		fmt.Printf("为 %d 条置顶消息分配置顶位置\n", result.ModifiedCount)
+	}
+	return nil
+}
+
+// backfillSequences 为从未分配过序列号的会话中的消息按时间顺序分配序列号
+// 已有序列号的会话中的旧消息早于所有带序列号的消息，重新编号会打乱客户端的同步位置，保持不变
+func backfillSequences(ctx context.Context, collection *mongo.Collection) error {
+	const batchSize = 500
+
+	filter := bson.M{
+		"conversation_id": bson.M{"$exists": true},
+		"seq":             bson.M{"$exists": false},
+	}
+	opts := options.Find().
+		SetSort(bson.D{
+			{Key: "conversation_id", Value: 1},
+			{Key: "timestamp", Value: 1},
+			{Key: "_id", Value: 1},
+		}).
+		SetProjection(bson.M{"conversation_id": 1}).
+		SetBatchSize(batchSize)
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var current string
+	var seq int64
+	skip := false
+	updated := 0
+	var batch []mongo.WriteModel
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		result, err := collection.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(true))
+		if result != nil {
+			updated += int(result.ModifiedCount)
+		}
+		batch = batch[:0]
+		if mongo.IsDuplicateKeyError(err) {
+			// 迁移期间会话收到了新消息并占用了序列号，剩余的旧消息保持没有序列号
+			skip = true
+			return nil
+		}
+		return err
+	}
+
+	for cursor.Next(ctx) {
+		var message struct {
+			ID             primitive.ObjectID `bson:"_id"`
+			ConversationID string             `bson:"conversation_id"`
+		}
+		if err := cursor.Decode(&message); err != nil {
+			return err
+		}
+
+		if message.ConversationID != current {
+			if err := flush(); err != nil {
+				return err
+			}
+			lastSeq, err := lastSequence(ctx, collection, message.ConversationID)
+			if err != nil {
+				return err
+			}
+			current = message.ConversationID
+			seq = 0
+			skip = lastSeq > 0
+		}
+		if skip {
+			continue
+		}
+
+		seq++
+		batch = append(batch, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": message.ID, "seq": bson.M{"$exists": false}}).
+			SetUpdate(bson.M{"$set": bson.M{"seq": seq}}))
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if updated > 0 {
+		fmt.Printf("为 %d 条旧消息分配序列号\n", updated)
+	}
+	return nil
+}
+
+// backfillConversations 按会话聚合消息，为还没有会话记录的会话补充最后一条消息和最后活跃时间
+// 已有的会话记录由新消息维护，不会被覆盖
+func backfillConversations(ctx context.Context, messages, conversations *mongo.Collection) error {
+	const batchSize = 500
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"conversation_id": bson.M{"$exists": true}}}},
+		{{Key: "$sort", Value: bson.D{
+			{Key: "conversation_id", Value: 1},
+			{Key: "timestamp", Value: 1},
+			{Key: "_id", Value: 1},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":  "$conversation_id",
+			"last": bson.M{"$last": "$$ROOT"},
+		}}},
+	}
+	cursor, err := messages.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	inserted := 0
+	var batch []mongo.WriteModel
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		result, err := conversations.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false))
+		if result != nil {
+			inserted += int(result.UpsertedCount)
+		}
+		batch = batch[:0]
+		return err
+	}
+
+	for cursor.Next(ctx) {
+		var row struct {
+			Last models.Message `bson:"last"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return err
+		}
+
+		conversation := models.ConversationFromMessage(&row.Last)
+		fields := bson.M{
+			"type":          conversation.Type,
+			"last_message":  conversation.LastMessage,
+			"last_seq":      conversation.LastSeq,
+			"last_activity": conversation.LastActivity,
+		}
+		if len(conversation.Participants) > 0 {
+			fields["participants"] = conversation.Participants
+		}
+		if conversation.GroupID != "" {
+			fields["group_id"] = conversation.GroupID
+		}
+
+		batch = append(batch, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": conversation.ID}).
+			SetUpdate(bson.M{"$setOnInsert": fields}).
+			SetUpsert(true))
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if inserted > 0 {
+		fmt.Printf("补充 %d 个会话记录\n", inserted)
+	}
+	return nil
+}
+
+// runMigration 执行一次性的数据迁移，完成后记录在migrations集合中，之后启动时跳过
+// 多个节点同时启动时迁移可能重复执行，迁移必须是幂等的
+func runMigration(ctx context.Context, db *mongo.Database, name string, migrate func(context.Context) error) error {
+	migrations := db.Collection("migrations")
+
+	err := migrations.FindOne(ctx, bson.M{"_id": name}).Err()
+	if err == nil {
+		return nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	if err := migrate(ctx); err != nil {
+		return err
+	}
+
+	_, err = migrations.UpdateOne(
+		ctx,
+		bson.M{"_id": name},
+		bson.M{"$set": bson.M{"completed_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// ensureIndex 索引不存在时创建索引，name必须与MongoDB按键生成的索引名一致
+func ensureIndex(ctx context.Context, collection *mongo.Collection, name string, model mongo.IndexModel) error {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+
+	var existingIndexes []bson.M
+	if err = cursor.All(ctx, &existingIndexes); err != nil {
+		return err
+	}
+
+	for _, idx := range existingIndexes {
+		if idx["name"] == name {
+			return nil
+		}
+	}
+
+	if _, err = collection.Indexes().CreateOne(ctx, model); err != nil {
+		return err
+	}
+	fmt.Printf("创建 %s 集合的索引 %s 成功\n", collection.Name(), name)
 	return nil
 }
 
+// freeSlot 查找filter匹配的文档中field字段未被占用的最小位置，[0, size)的位置都被占用时返回-1
+// 调用方在field上建立唯一索引，并在占用位置冲突时重新查找
+func freeSlot(ctx context.Context, collection *mongo.Collection, filter bson.M, field string, size int) (int, error) {
+	query := bson.M{field: bson.M{"$exists": true}}
+	for key, value := range filter {
+		query[key] = value
+	}
+
+	cursor, err := collection.Find(ctx, query, options.Find().SetProjection(bson.M{field: 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	used := make([]bool, size)
+	for cursor.Next(ctx) {
+		slot, ok := cursor.Current.Lookup(field).AsInt64OK()
+		if ok && slot >= 0 && slot < int64(size) {
+			used[slot] = true
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return 0, err
+	}
+
+	for slot, taken := range used {
+		if !taken {
+			return slot, nil
+		}
+	}
+	return -1, nil
+}
+
 // 检查错误是否为"集合已存在"错误
 func isCollectionExistsError(err error) bool {
 	// MongoDB的错误处理比较复杂，这里简化处理
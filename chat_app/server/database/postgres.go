@@ -90,6 +90,43 @@ func (p *PostgresDB) InitSchema() error {
 		joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		UNIQUE(group_id, user_id)
 	)`)
+	if err != nil {
+		return err
+	}
+
+	// 创建群组公告表，每个群组只保留当前的一条公告
+	_, err = p.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS group_announcements (
+		id SERIAL PRIMARY KEY,
+		group_id INTEGER UNIQUE REFERENCES groups(id),
+		title VARCHAR(100) NOT NULL,
+		body TEXT NOT NULL,
+		author_id INTEGER REFERENCES users(id),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+
+	// 创建群组公告已读表，公告被替换或删除时已读记录一并删除
+	_, err = p.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS group_announcement_reads (
+		announcement_id INTEGER REFERENCES group_announcements(id) ON DELETE CASCADE,
+		user_id INTEGER REFERENCES users(id),
+		read_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(announcement_id, user_id)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	// 创建用户隐私设置表
+	_, err = p.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS user_privacy_settings (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id),
+		presence_visibility VARCHAR(20) NOT NULL DEFAULT 'contacts',
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
 
 	return err
 }
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"chat_app/server/models"
+)
+
+// PrivacyRepository 实现models.PrivacyRepository接口
+type PrivacyRepository struct {
+	db *PostgresDB
+}
+
+// NewPrivacyRepository 创建一个新的PrivacyRepository
+func NewPrivacyRepository(db *PostgresDB) models.PrivacyRepository {
+	return &PrivacyRepository{db: db}
+}
+
+// GetPrivacySettings 获取用户的隐私设置，没有记录时返回默认设置
+func (r *PrivacyRepository) GetPrivacySettings(userID int) (*models.PrivacySettings, error) {
+	query := `
+		SELECT user_id, presence_visibility, updated_at
+		FROM user_privacy_settings
+		WHERE user_id = $1
+	`
+	settings := &models.PrivacySettings{}
+	err := r.db.DB.QueryRow(query, userID).Scan(
+		&settings.UserID,
+		&settings.PresenceVisibility,
+		&settings.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return &models.PrivacySettings{
+			UserID:             userID,
+			PresenceVisibility: models.PresenceVisibleContacts,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// SavePrivacySettings 保存用户的隐私设置
+func (r *PrivacyRepository) SavePrivacySettings(settings *models.PrivacySettings) error {
+	query := `
+		INSERT INTO user_privacy_settings (user_id, presence_visibility, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET presence_visibility = $2, updated_at = $3
+	`
+	settings.UpdatedAt = time.Now()
+	_, err := r.db.DB.Exec(query, settings.UserID, settings.PresenceVisibility, settings.UpdatedAt)
+	return err
+}
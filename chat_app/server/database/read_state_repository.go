@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"chat_app/server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoReadStateRepository MongoDB实现的已读位置仓库
+type MongoReadStateRepository struct {
+	collection *mongo.Collection
+}
+
+// NewReadStateRepository 创建新的MongoDB已读位置仓库
+func NewReadStateRepository(mongodb *MongoDB) models.ReadStateRepository {
+	if mongodb == nil || mongodb.Client == nil {
+		return nil
+	}
+
+	return &MongoReadStateRepository{
+		collection: mongodb.Database.Collection("read_states"),
+	}
+}
+
+// AdvanceReadState 将用户的已读位置前移到seq，返回更新前的位置
+func (r *MongoReadStateRepository) AdvanceReadState(conversationID, userID string, seq int64, at time.Time) (int64, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// 只匹配已读位置更小的文档；文档已存在且位置不小于seq时，
+	// upsert会因唯一索引冲突而失败，说明无需更新
+	filter := bson.M{
+		"conversation_id": conversationID,
+		"user_id":         userID,
+		"last_read_seq":   bson.M{"$lt": seq},
+	}
+	update := bson.M{"$set": bson.M{
+		"last_read_seq": seq,
+		"updated_at":    at,
+	}}
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.Before)
+
+	var previous models.ReadState
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&previous)
+	if mongo.IsDuplicateKeyError(err) {
+		return 0, false, nil
+	}
+	if err == mongo.ErrNoDocuments {
+		// 新插入的文档，之前没有已读位置
+		return 0, true, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return previous.LastReadSeq, true, nil
+}
+
+// GetReadState 获取用户在会话中的已读位置，不存在时返回nil
+func (r *MongoReadStateRepository) GetReadState(conversationID, userID string) (*models.ReadState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var state models.ReadState
+	err := r.collection.FindOne(ctx, bson.M{
+		"conversation_id": conversationID,
+		"user_id":         userID,
+	}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// GetReadStates 获取会话中所有用户的已读位置
+func (r *MongoReadStateRepository) GetReadStates(conversationID string) ([]*models.ReadState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, bson.M{"conversation_id": conversationID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var states []*models.ReadState
+	if err = cursor.All(ctx, &states); err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
+
+// GetUserReadStates 获取用户在多个会话中的已读位置
+func (r *MongoReadStateRepository) GetUserReadStates(userID string, conversationIDs []string) ([]*models.ReadState, error) {
+	if len(conversationIDs) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, bson.M{
+		"conversation_id": bson.M{"$in": conversationIDs},
+		"user_id":         userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var states []*models.ReadState
+	if err = cursor.All(ctx, &states); err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
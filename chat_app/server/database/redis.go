@@ -2,6 +2,8 @@ package database
 
 import (
 	"context"
+	"encoding/json"
+	"strconv"
 	"time"
 	
 	"chat_app/server/config"
@@ -89,4 +91,232 @@ func (r *RedisDB) GetUserIDFromSession(ctx context.Context, sessionID string) (s
 		return "", nil
 	}
 	return userID, err
-} 
\ No newline at end of file
+} 
+
+// WSTicket 一次性WebSocket连接票据
+type WSTicket struct {
+	UserID    int       `json:"user_id"`
+	TokenID   string    `json:"token_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// StoreWSTicket 存储一次性WebSocket连接票据
+func (r *RedisDB) StoreWSTicket(ctx context.Context, ticket string, data *WSTicket, duration time.Duration) error {
+	key := "ws_ticket:" + ticket
+	value, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return r.Client.Set(ctx, key, value, duration).Err()
+}
+
+// ConsumeWSTicket 取出并删除WebSocket连接票据，票据只能使用一次
+func (r *RedisDB) ConsumeWSTicket(ctx context.Context, ticket string) (*WSTicket, error) {
+	key := "ws_ticket:" + ticket
+	value, err := r.Client.GetDel(ctx, key).Bytes()
+	if err == redis.Nil {
+		// 票据不存在、已过期或已被使用
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var data WSTicket
+	if err := json.Unmarshal(value, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// TokenRevokedChannel 令牌被吊销时发布令牌ID的频道，各节点据此关闭使用该令牌的WebSocket连接
+const TokenRevokedChannel = "token:revoked"
+
+// RevokeToken 吊销令牌，记录保留到令牌自然过期为止，并通知所有节点
+func (r *RedisDB) RevokeToken(ctx context.Context, tokenID string, duration time.Duration) error {
+	if duration <= 0 {
+		// 令牌已过期，无需记录
+		return nil
+	}
+	key := "token:revoked:" + tokenID
+
+	pipe := r.Client.TxPipeline()
+	pipe.Set(ctx, key, "1", duration)
+	pipe.Publish(ctx, TokenRevokedChannel, tokenID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SubscribeRevokedTokens 订阅被吊销的令牌ID
+func (r *RedisDB) SubscribeRevokedTokens(ctx context.Context) *redis.PubSub {
+	return r.Client.Subscribe(ctx, TokenRevokedChannel)
+}
+
+// IsTokenRevoked 检查令牌是否已被吊销
+func (r *RedisDB) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	key := "token:revoked:" + tokenID
+	n, err := r.Client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// AddUserConnection 记录用户的一个WebSocket连接，返回该用户当前的有效连接数
+// 连接记录在duration后过期，需要通过心跳续期，节点崩溃时残留的记录会自动失效
+func (r *RedisDB) AddUserConnection(ctx context.Context, userID, connID string, duration time.Duration) (int64, error) {
+	key := "user:connections:" + userID
+	now := time.Now()
+
+	pipe := r.Client.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Unix(), 10))
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.Add(duration).Unix()), Member: connID})
+	pipe.Expire(ctx, key, duration)
+	count := pipe.ZCard(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// RemoveUserConnection 移除用户的一个WebSocket连接，返回该用户剩余的有效连接数
+func (r *RedisDB) RemoveUserConnection(ctx context.Context, userID, connID string) (int64, error) {
+	key := "user:connections:" + userID
+
+	pipe := r.Client.TxPipeline()
+	pipe.ZRem(ctx, key, connID)
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(time.Now().Unix(), 10))
+	count := pipe.ZCard(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// HasUserConnections 检查用户是否还有未过期的WebSocket连接
+func (r *RedisDB) HasUserConnections(ctx context.Context, userID string) (bool, error) {
+	key := "user:connections:" + userID
+	n, err := r.Client.ZCount(ctx, key, "("+strconv.FormatInt(time.Now().Unix(), 10), "+inf").Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// SetUserLastSeen 记录用户最后在线时间
+func (r *RedisDB) SetUserLastSeen(ctx context.Context, userID string, t time.Time) error {
+	key := "user:last_seen:" + userID
+	return r.Client.Set(ctx, key, t.Unix(), 0).Err()
+}
+
+// GetUserLastSeen 获取用户最后在线时间，没有记录时返回零值
+func (r *RedisDB) GetUserLastSeen(ctx context.Context, userID string) (time.Time, error) {
+	key := "user:last_seen:" + userID
+	val, err := r.Client.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(val, 0), nil
+}
+
+// GroupMembersVersion 获取群组成员缓存的版本号，每次清除缓存时加一
+// 从数据库加载成员之前读取版本号，回填缓存时版本号已变化说明加载的成员可能已过期
+func (r *RedisDB) GroupMembersVersion(ctx context.Context, groupID string) (int64, error) {
+	version, err := r.Client.Get(ctx, "group:members:version:"+groupID).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return version, err
+}
+
+// CacheGroupMembers 缓存群组成员ID列表，缓存版本号不再是version时不写入
+func (r *RedisDB) CacheGroupMembers(ctx context.Context, groupID string, memberIDs []string, version int64, duration time.Duration) error {
+	key := "group:members:" + groupID
+	versionKey := "group:members:version:" + groupID
+	if len(memberIDs) == 0 {
+		return nil
+	}
+
+	members := make([]interface{}, len(memberIDs))
+	for i, id := range memberIDs {
+		members[i] = id
+	}
+
+	err := r.Client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, versionKey).Int64()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if current != version {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.SAdd(ctx, key, members...)
+			pipe.Expire(ctx, key, duration)
+			return nil
+		})
+		return err
+	}, versionKey)
+	// 写入期间缓存被清除，放弃回填
+	if err == redis.TxFailedErr {
+		return nil
+	}
+	return err
+}
+
+// GetCachedGroupMembers 获取缓存的群组成员ID列表，缓存不存在时返回nil
+func (r *RedisDB) GetCachedGroupMembers(ctx context.Context, groupID string) ([]string, error) {
+	key := "group:members:" + groupID
+	members, err := r.Client.SMembers(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+	return members, nil
+}
+
+// InvalidateGroupMembers 删除群组成员缓存并增加缓存版本号，清除之前开始加载的成员不会再被写回缓存
+func (r *RedisDB) InvalidateGroupMembers(ctx context.Context, groupID string) error {
+	key := "group:members:" + groupID
+	pipe := r.Client.TxPipeline()
+	pipe.Incr(ctx, "group:members:version:"+groupID)
+	pipe.Del(ctx, key)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// PushOfflineFrame 将一帧追加到设备的离线队列，队列最多保留maxLen帧
+func (r *RedisDB) PushOfflineFrame(ctx context.Context, userID, deviceID string, frame []byte, maxLen int64, duration time.Duration) error {
+	key := "offline:frames:" + userID + ":" + deviceID
+
+	pipe := r.Client.TxPipeline()
+	pipe.RPush(ctx, key, frame)
+	pipe.LTrim(ctx, key, -maxLen, -1)
+	pipe.Expire(ctx, key, duration)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// PopOfflineFrames 取出并删除设备离线队列中的所有帧
+func (r *RedisDB) PopOfflineFrames(ctx context.Context, userID, deviceID string) ([][]byte, error) {
+	key := "offline:frames:" + userID + ":" + deviceID
+
+	pipe := r.Client.TxPipeline()
+	lrange := pipe.LRange(ctx, key, 0, -1)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	values := lrange.Val()
+	frames := make([][]byte, len(values))
+	for i, value := range values {
+		frames[i] = []byte(value)
+	}
+	return frames, nil
+}
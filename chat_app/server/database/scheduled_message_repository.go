@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"chat_app/server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 发送者等待位置的唯一索引，保证每个位置只被一条等待发送的定时消息占用
+const scheduledPendingSlotIndex = "sender_id_1_pending_slot_1"
+
+// MongoScheduledMessageRepository MongoDB实现的定时消息仓库
+type MongoScheduledMessageRepository struct {
+	collection *mongo.Collection
+}
+
+// NewScheduledMessageRepository 创建新的MongoDB定时消息仓库
+func NewScheduledMessageRepository(mongodb *MongoDB) models.ScheduledMessageRepository {
+	if mongodb == nil || mongodb.Client == nil {
+		return nil
+	}
+
+	return &MongoScheduledMessageRepository{
+		collection: mongodb.Database.Collection("scheduled_messages"),
+	}
+}
+
+// CreateScheduledMessage 为定时消息分配发送者空闲的等待位置并保存
+// 位置由发送者等待位置唯一索引保证不重复，并发创建时等待发送的消息数也不会超过maxPending
+func (r *MongoScheduledMessageRepository) CreateScheduledMessage(message *models.ScheduledMessage, maxPending int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if message.ID.IsZero() {
+		message.ID = primitive.NewObjectID()
+	}
+
+	for attempt := 0; attempt < maxPending; attempt++ {
+		slot, err := freeSlot(ctx, r.collection, bson.M{"sender_id": message.SenderID}, "pending_slot", maxPending)
+		if err != nil {
+			return false, err
+		}
+		if slot < 0 {
+			break
+		}
+		message.PendingSlot = &slot
+
+		_, err = r.collection.InsertOne(ctx, message)
+		if err == nil {
+			return true, nil
+		}
+		if !isDuplicateKeyOn(err, scheduledPendingSlotIndex) {
+			message.PendingSlot = nil
+			return false, err
+		}
+		// 并发创建的定时消息占用了这个位置，重新查找空闲位置
+	}
+
+	message.PendingSlot = nil
+	return false, nil
+}
+
+// GetScheduledMessage 获取定时消息，不存在时返回nil
+func (r *MongoScheduledMessageRepository) GetScheduledMessage(id string) (*models.ScheduledMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil
+	}
+
+	var message models.ScheduledMessage
+	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// ListScheduledMessages 获取发送者指定状态的定时消息
+func (r *MongoScheduledMessageRepository) ListScheduledMessages(senderID string, statuses []models.ScheduledMessageStatus) ([]*models.ScheduledMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"sender_id": senderID,
+		"status":    bson.M{"$in": statuses},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "send_at", Value: 1}, {Key: "_id", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []*models.ScheduledMessage
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// UpdateScheduledMessage 修改等待发送的定时消息
+func (r *MongoScheduledMessageRepository) UpdateScheduledMessage(id, senderID, content string, sendAt, updatedAt time.Time) (bool, error) {
+	return r.updatePending(id, senderID, bson.M{"$set": bson.M{
+		"content":    content,
+		"send_at":    sendAt,
+		"updated_at": updatedAt,
+	}})
+}
+
+// CancelScheduledMessage 取消等待发送的定时消息并释放等待位置
+func (r *MongoScheduledMessageRepository) CancelScheduledMessage(id, senderID string, cancelledAt time.Time) (bool, error) {
+	return r.updatePending(id, senderID, bson.M{
+		"$set": bson.M{
+			"status":     models.ScheduledCancelled,
+			"updated_at": cancelledAt,
+		},
+		"$unset": bson.M{"pending_slot": ""},
+	})
+}
+
+// updatePending 更新发送者等待发送的定时消息，已被领取的消息不会被修改
+func (r *MongoScheduledMessageRepository) updatePending(id, senderID string, update bson.M) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, nil
+	}
+
+	result, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID, "sender_id": senderID, "status": models.ScheduledPending},
+		update,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// ClaimDueScheduledMessage 领取最早到期的一条定时消息，领取后释放等待位置
+// 领取通过单个文档的原子更新完成，多个节点同时领取时只有一个节点能匹配到同一条消息
+func (r *MongoScheduledMessageRepository) ClaimDueScheduledMessage(now, staleBefore time.Time) (*models.ScheduledMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"$or": []bson.M{
+			{"status": models.ScheduledPending, "send_at": bson.M{"$lte": now}},
+			{"status": models.ScheduledSending, "claimed_at": bson.M{"$lte": staleBefore}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":     models.ScheduledSending,
+			"claimed_at": now,
+			"updated_at": now,
+		},
+		"$inc":   bson.M{"attempts": 1},
+		"$unset": bson.M{"pending_slot": ""},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "send_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var message models.ScheduledMessage
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// CompleteScheduledMessage 将定时消息标记为已发送
+func (r *MongoScheduledMessageRepository) CompleteScheduledMessage(id, messageID string, sentAt time.Time) error {
+	return r.finishSending(id, bson.M{
+		"status":     models.ScheduledSent,
+		"message_id": messageID,
+		"sent_at":    sentAt,
+		"updated_at": sentAt,
+	})
+}
+
+// FailScheduledMessage 将定时消息标记为发送失败
+func (r *MongoScheduledMessageRepository) FailScheduledMessage(id, reason string, failedAt time.Time) error {
+	return r.finishSending(id, bson.M{
+		"status":     models.ScheduledFailed,
+		"error":      reason,
+		"updated_at": failedAt,
+	})
+}
+
+// finishSending 结束发送中的定时消息并清除领取信息
+func (r *MongoScheduledMessageRepository) finishSending(id string, set bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID, "status": models.ScheduledSending},
+		bson.M{"$set": set, "$unset": bson.M{"claimed_at": ""}},
+	)
+	return err
+}
+
+// IsMediaReferenced 检查是否有尚未发送的定时消息引用了该媒体地址
+func (r *MongoScheduledMessageRepository) IsMediaReferenced(mediaURL string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := r.collection.FindOne(ctx, bson.M{
+		"media_url": mediaURL,
+		"status":    bson.M{"$in": []models.ScheduledMessageStatus{models.ScheduledPending, models.ScheduledSending}},
+	}, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
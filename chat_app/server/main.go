@@ -14,6 +14,7 @@ import (
 	"chat_app/server/config"
 	"chat_app/server/database"
 	"chat_app/server/services"
+	"chat_app/server/utils"
 	"chat_app/server/websocket"
 
 	"github.com/gorilla/mux"
@@ -74,6 +75,9 @@ func main() {
 		fmt.Println("继续启动服务器，但某些功能可能不可用...")
 	} else {
 		defer redisDB.Close()
+
+		// 启用令牌吊销检查
+		utils.SetTokenRevocationStore(redisDB)
 	}
 
 	// NATS
@@ -87,10 +91,25 @@ func main() {
 
 	// 初始化WebSocket Hub
 	hub := websocket.NewHub()
-	go hub.Run()
+	policy, err := websocket.ParseSlowConsumerPolicy(cfg.WebSocket.SlowConsumerPolicy)
+	if err != nil {
+		fmt.Println("慢连接策略配置无效，使用默认策略:", err)
+		policy = websocket.DefaultSlowConsumerPolicy
+	}
+	hub.SetSlowConsumerPolicy(policy)
+	if redisDB != nil {
+		hub.SetOfflineQueue(websocket.NewRedisOfflineQueue(redisDB))
+	}
 
 	// 初始化WebSocket处理器
-	wsHandler := websocket.NewHandler(hub)
+	wsHandler := websocket.NewHandler(hub, redisDB)
+
+	// 令牌被吊销后关闭使用它的WebSocket连接
+	revocationListener := websocket.NewRevocationListener(hub, redisDB)
+	if err := revocationListener.Start(); err != nil {
+		fmt.Println("订阅令牌吊销通知失败:", err)
+	}
+	defer revocationListener.Stop()
 
 	// 初始化服务
 	userRepo := database.NewUserRepository(postgresDB)
@@ -98,28 +117,75 @@ func main() {
 	userService := services.NewUserService(userRepo, contactRepo)
 	contactService := services.NewContactService(userRepo, contactRepo)
 
+	// 初始化群组存储库
+	groupRepo := database.NewSQLGroupRepository(postgresDB.DB)
+	groupMemberRepo := database.NewSQLGroupMemberRepository(postgresDB.DB)
+
+	// 初始化跨节点推送服务
+	pushService := services.NewPushService(natsDB, hub)
+	if err := pushService.Start(); err != nil {
+		fmt.Println("订阅用户推送主题失败:", err)
+	}
+	defer pushService.Stop()
+
 	// 初始化消息服务和处理器
 	messageRepo := database.NewMessageRepository(mongodb)
-	messageService := services.NewMessageService(messageRepo, redisDB, natsDB, hub)
+	readStateRepo := database.NewReadStateRepository(mongodb)
+	conversationRepo := database.NewConversationRepository(mongodb)
+	messageService := services.NewMessageService(messageRepo, readStateRepo, conversationRepo, groupMemberRepo, userRepo, redisDB, natsDB, hub, pushService)
+	if cfg.Message.EditWindowMinutes > 0 {
+		messageService.SetEditWindow(time.Duration(cfg.Message.EditWindowMinutes) * time.Minute)
+	}
+	searchIndex := database.NewMessageSearchIndex(mongodb)
+	messageService.SetSearchIndex(searchIndex)
+	messageService.SetScheduledMessageRepository(database.NewScheduledMessageRepository(mongodb))
+	messageService.SetMediaDir("uploads")
+	messageService.RegisterFrameHandlers()
+	if err := messageService.StartDelivery(); err != nil {
+		fmt.Println("订阅消息投递主题失败:", err)
+	}
+	defer messageService.StopDelivery()
+	messageService.StartScheduler()
+	defer messageService.StopScheduler()
 	messageHandler := api.NewMessageHandler(messageService)
 
+	// 初始化消息搜索服务和处理器
+	searchService := services.NewSearchService(searchIndex, conversationRepo, groupRepo)
+	searchHandler := api.NewSearchHandler(searchService)
+
+	// 初始化会话服务和处理器
+	conversationService := services.NewConversationService(conversationRepo, messageRepo, readStateRepo, groupRepo, groupMemberRepo)
+	conversationHandler := api.NewConversationHandler(conversationService)
+
+	// 初始化在线状态服务和处理器
+	privacyRepo := database.NewPrivacyRepository(postgresDB)
+	presenceService := services.NewPresenceService(redisDB, contactRepo, privacyRepo, pushService)
+	presenceHandler := api.NewPresenceHandler(presenceService)
+	if redisDB != nil {
+		hub.SetPresenceTracker(presenceService)
+		go presenceService.Run()
+	}
+
+	// 启动WebSocket Hub
+	go hub.Run()
+
 	// 初始化通知服务
 	var notificationService *services.NotificationService
 	if redisDB != nil {
 		notificationService = services.NewNotificationService(redisDB.Client)
+		messageService.SetNotificationService(notificationService)
 	}
 
 	// 初始化群组服务和处理器
-	groupRepo := database.NewSQLGroupRepository(postgresDB.DB)
-	groupMemberRepo := database.NewSQLGroupMemberRepository(postgresDB.DB)
-	groupService := services.NewGroupService(groupRepo, groupMemberRepo, "uploads", fmt.Sprintf("http://localhost:%d", cfg.Server.Port))
+	groupService := services.NewGroupService(groupRepo, groupMemberRepo, redisDB, "uploads", fmt.Sprintf("http://localhost:%d", cfg.Server.Port))
+	groupService.SetPushService(pushService)
 	groupHandler := api.NewGroupHandler(groupService)
 
 	// 初始化API
 	apiHandler := api.NewAPI(userService, contactService, notificationService)
 
 	// 初始化认证处理器
-	authHandler := api.NewAuthHandler(userService)
+	authHandler := api.NewAuthHandler(userService, redisDB)
 
 	// 创建联系人处理器
 	contactHandler := api.NewContactHandler(contactService)
@@ -135,6 +201,7 @@ func main() {
 	// 认证路由
 	router.HandleFunc("/auth/register", authHandler.Register).Methods("POST")
 	router.HandleFunc("/auth/login", authHandler.Login).Methods("POST")
+	router.Handle("/auth/logout", api.AuthMiddleware(http.HandlerFunc(authHandler.Logout))).Methods("POST")
 
 	// 联系人路由（带认证）
 	router.Handle("/contacts", api.AuthMiddleware(http.HandlerFunc(contactHandler.GetContacts))).Methods("GET")
@@ -145,6 +212,24 @@ func main() {
 	// 消息路由（带认证）
 	router.Handle("/messages", api.AuthMiddleware(http.HandlerFunc(messageHandler.SendMessage))).Methods("POST")
 	router.Handle("/messages", api.AuthMiddleware(http.HandlerFunc(messageHandler.GetMessages))).Methods("GET")
+	router.Handle("/messages/sync", api.AuthMiddleware(http.HandlerFunc(messageHandler.SyncMessages))).Methods("GET")
+	router.Handle("/messages/search", api.AuthMiddleware(http.HandlerFunc(searchHandler.SearchMessages))).Methods("GET")
+	router.Handle("/messages/scheduled", api.AuthMiddleware(http.HandlerFunc(messageHandler.ScheduleMessage))).Methods("POST")
+	router.Handle("/messages/scheduled", api.AuthMiddleware(http.HandlerFunc(messageHandler.ListScheduledMessages))).Methods("GET")
+	router.Handle("/messages/scheduled/{id}", api.AuthMiddleware(http.HandlerFunc(messageHandler.EditScheduledMessage))).Methods("PATCH")
+	router.Handle("/messages/scheduled/{id}", api.AuthMiddleware(http.HandlerFunc(messageHandler.CancelScheduledMessage))).Methods("DELETE")
+	router.Handle("/messages/forward", api.AuthMiddleware(http.HandlerFunc(messageHandler.ForwardMessages))).Methods("POST")
+	router.Handle("/messages/read", api.AuthMiddleware(http.HandlerFunc(messageHandler.MarkAsRead))).Methods("POST")
+	router.Handle("/messages/read", api.AuthMiddleware(http.HandlerFunc(messageHandler.GetReadState))).Methods("GET")
+	router.Handle("/messages/{id}/readers", api.AuthMiddleware(http.HandlerFunc(messageHandler.GetMessageReaders))).Methods("GET")
+	router.Handle("/messages/{id}/thread", api.AuthMiddleware(http.HandlerFunc(messageHandler.GetThread))).Methods("GET")
+	router.Handle("/messages/{id}/reactions/{emoji}", api.AuthMiddleware(http.HandlerFunc(messageHandler.AddReaction))).Methods("PUT")
+	router.Handle("/messages/{id}/reactions/{emoji}", api.AuthMiddleware(http.HandlerFunc(messageHandler.RemoveReaction))).Methods("DELETE")
+	router.Handle("/messages/{id}", api.AuthMiddleware(http.HandlerFunc(messageHandler.EditMessage))).Methods("PATCH")
+	router.Handle("/messages/{id}/pin", api.AuthMiddleware(http.HandlerFunc(messageHandler.PinMessage))).Methods("PUT")
+	router.Handle("/messages/{id}/pin", api.AuthMiddleware(http.HandlerFunc(messageHandler.UnpinMessage))).Methods("DELETE")
+	router.Handle("/messages/{id}/recall", api.AuthMiddleware(http.HandlerFunc(messageHandler.RecallMessage))).Methods("POST")
+	router.Handle("/messages/{id}", api.AuthMiddleware(http.HandlerFunc(messageHandler.DeleteMessage))).Methods("DELETE")
 
 	// 添加/chats路由，重定向到/messages端点，以兼容客户端代码
 	router.Handle("/chats", api.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -166,6 +251,8 @@ func main() {
 		// 调用消息处理器
 		messageHandler.GetMessages(w, r)
 	}))).Methods("GET")
+	router.Handle("/chats/{id}", api.AuthMiddleware(http.HandlerFunc(messageHandler.DeleteChat))).Methods("DELETE")
+	router.Handle("/chats/{id}/pins", api.AuthMiddleware(http.HandlerFunc(messageHandler.GetPinnedMessages))).Methods("GET")
 
 	// 通知路由（带认证）
 	router.Handle("/notifications/token", api.AuthMiddleware(http.HandlerFunc(apiHandler.SaveFCMToken))).Methods("POST")
@@ -177,12 +264,27 @@ func main() {
 	groupRouter.Use(api.AuthMiddleware)
 	groupHandler.RegisterRoutes(groupRouter)
 
+	// 会话路由（带认证）
+	conversationRouter := router.PathPrefix("").Subrouter()
+	conversationRouter.Use(api.AuthMiddleware)
+	conversationHandler.RegisterRoutes(conversationRouter)
+
+	// 在线状态路由（带认证）
+	presenceRouter := router.PathPrefix("").Subrouter()
+	presenceRouter.Use(api.AuthMiddleware)
+	presenceHandler.RegisterRoutes(presenceRouter)
+
 	// 媒体路由
 	router.Handle("/media/upload", api.AuthMiddleware(http.HandlerFunc(apiHandler.UploadMedia))).Methods("POST")
 	router.HandleFunc("/media/{type}/{filename}", apiHandler.GetMedia).Methods("GET")
 
 	// WebSocket路由
 	router.HandleFunc("/ws", wsHandler.HandleWebSocket)
+	router.Handle("/ws/ticket", api.AuthMiddleware(http.HandlerFunc(wsHandler.IssueTicket))).Methods("POST")
+
+	// 设备会话路由（带认证）
+	router.Handle("/sessions", api.AuthMiddleware(http.HandlerFunc(wsHandler.ListSessions))).Methods("GET")
+	router.Handle("/sessions/{device_id}", api.AuthMiddleware(http.HandlerFunc(wsHandler.KickSession))).Methods("DELETE")
 
 	// 创建上传目录
 	os.MkdirAll("uploads", 0755)
@@ -197,6 +299,24 @@ func main() {
 		Handler: handler,
 	}
 
+	// 内部HTTP服务器，只监听内部地址，不对外暴露
+	var internalServer *http.Server
+	if cfg.Server.InternalAddr != "" {
+		internalRouter := mux.NewRouter()
+		internalRouter.HandleFunc("/ws/stats", wsHandler.Stats).Methods("GET")
+
+		internalServer = &http.Server{
+			Addr:    cfg.Server.InternalAddr,
+			Handler: internalRouter,
+		}
+		go func() {
+			fmt.Printf("内部接口运行在 http://%s\n", cfg.Server.InternalAddr)
+			if err := internalServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Println("内部HTTP服务器启动失败:", err)
+			}
+		}()
+	}
+
 	// 优雅关闭
 	go func() {
 		// 监听中断信号
@@ -211,6 +331,11 @@ func main() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
+		if internalServer != nil {
+			if err := internalServer.Shutdown(ctx); err != nil {
+				log.Println("内部服务器关闭错误:", err)
+			}
+		}
 		if err := server.Shutdown(ctx); err != nil {
 			log.Fatal("服务器关闭错误:", err)
 		}
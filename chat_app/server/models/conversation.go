@@ -0,0 +1,237 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 会话ID前缀
+const (
+	privateConversationPrefix = "p_"
+	groupConversationPrefix   = "g_"
+)
+
+// ErrInvalidConversationID 会话ID格式无效
+var ErrInvalidConversationID = errors.New("无效的会话ID")
+
+// ConversationType 会话类型
+type ConversationType string
+
+const (
+	// PrivateConversation 私聊会话
+	PrivateConversation ConversationType = "private"
+
+	// GroupConversation 群组会话
+	GroupConversation ConversationType = "group"
+)
+
+// PrivateConversationID 返回两个用户之间私聊会话的ID，与参数顺序无关
+func PrivateConversationID(userID1, userID2 string) string {
+	if lessUserID(userID2, userID1) {
+		userID1, userID2 = userID2, userID1
+	}
+	return privateConversationPrefix + userID1 + "_" + userID2
+}
+
+// GroupConversationID 返回群组会话的ID
+func GroupConversationID(groupID string) string {
+	return groupConversationPrefix + groupID
+}
+
+// 客户端聊天列表使用的聊天ID前缀
+const (
+	privateChatPrefix = "private_"
+	groupChatPrefix   = "group_"
+)
+
+// ConversationIDFromChatID 将客户端的聊天ID转换为会话ID
+// 私聊的聊天ID为 private_<对方ID>，群聊为 group_<群组ID>
+func ConversationIDFromChatID(userID, chatID string) (string, error) {
+	switch {
+	case strings.HasPrefix(chatID, privateChatPrefix):
+		peerID := strings.TrimPrefix(chatID, privateChatPrefix)
+		if peerID == "" {
+			return "", ErrInvalidConversationID
+		}
+		return PrivateConversationID(userID, peerID), nil
+	case strings.HasPrefix(chatID, groupChatPrefix):
+		groupID := strings.TrimPrefix(chatID, groupChatPrefix)
+		if groupID == "" {
+			return "", ErrInvalidConversationID
+		}
+		return GroupConversationID(groupID), nil
+	default:
+		return "", ErrInvalidConversationID
+	}
+}
+
+// ConversationRef 解析后的会话ID
+type ConversationRef struct {
+	Type ConversationType
+
+	// 私聊会话的两个参与者
+	UserIDs [2]string
+
+	// 群组会话的群组ID
+	GroupID string
+}
+
+// ParseConversationID 解析会话ID
+func ParseConversationID(id string) (*ConversationRef, error) {
+	switch {
+	case strings.HasPrefix(id, privateConversationPrefix):
+		parts := strings.Split(strings.TrimPrefix(id, privateConversationPrefix), "_")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, ErrInvalidConversationID
+		}
+		return &ConversationRef{Type: PrivateConversation, UserIDs: [2]string{parts[0], parts[1]}}, nil
+	case strings.HasPrefix(id, groupConversationPrefix):
+		groupID := strings.TrimPrefix(id, groupConversationPrefix)
+		if groupID == "" {
+			return nil, ErrInvalidConversationID
+		}
+		return &ConversationRef{Type: GroupConversation, GroupID: groupID}, nil
+	default:
+		return nil, ErrInvalidConversationID
+	}
+}
+
+// HasParticipant 检查用户是否为私聊会话的参与者，群组会话需要另外检查成员关系
+func (c *ConversationRef) HasParticipant(userID string) bool {
+	return c.Type == PrivateConversation && (c.UserIDs[0] == userID || c.UserIDs[1] == userID)
+}
+
+// Peer 返回私聊会话中另一方的用户ID
+func (c *ConversationRef) Peer(userID string) string {
+	if c.UserIDs[0] == userID {
+		return c.UserIDs[1]
+	}
+	return c.UserIDs[0]
+}
+
+// lessUserID 比较用户ID，数字ID按数值比较
+func lessUserID(a, b string) bool {
+	ai, errA := strconv.Atoi(a)
+	bi, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return ai < bi
+	}
+	return a < b
+}
+
+// PrivateChatID 返回客户端聊天列表中私聊的聊天ID
+func PrivateChatID(peerID string) string {
+	return privateChatPrefix + peerID
+}
+
+// GroupChatID 返回客户端聊天列表中群聊的聊天ID
+func GroupChatID(groupID string) string {
+	return groupChatPrefix + groupID
+}
+
+// MessagePreview 消息摘要，用于会话列表中的最后一条消息和回复中引用的消息
+type MessagePreview struct {
+	MessageID string      `bson:"message_id" json:"message_id"`
+	SenderID  string      `bson:"sender_id" json:"sender_id"`
+	Type      MessageType `bson:"type" json:"type"`
+	Content   string      `bson:"content" json:"content"`
+	Timestamp time.Time   `bson:"timestamp" json:"timestamp"`
+	Recalled  bool        `bson:"recalled,omitempty" json:"recalled,omitempty"`
+}
+
+// Conversation 会话的最后一条消息和最后活跃时间，每个会话一条记录
+type Conversation struct {
+	ID   string           `bson:"_id" json:"conversation_id"`
+	Type ConversationType `bson:"type" json:"type"`
+
+	// 私聊会话的两个参与者，群组会话的成员由群组成员表维护
+	Participants []string `bson:"participants,omitempty" json:"participants,omitempty"`
+	GroupID      string   `bson:"group_id,omitempty" json:"group_id,omitempty"`
+
+	LastMessage  *MessagePreview `bson:"last_message,omitempty" json:"last_message,omitempty"`
+	LastSeq      int64           `bson:"last_seq" json:"last_seq"`
+	LastActivity time.Time       `bson:"last_activity" json:"last_activity"`
+}
+
+// ConversationFromMessage 返回以message为最后一条消息的会话记录
+func ConversationFromMessage(message *Message) *Conversation {
+	conversation := &Conversation{
+		ID:           message.ConversationID,
+		GroupID:      message.GroupID,
+		LastMessage:  message.Preview(),
+		LastSeq:      message.Seq,
+		LastActivity: message.Timestamp,
+	}
+	if message.GroupID != "" {
+		conversation.Type = GroupConversation
+	} else {
+		conversation.Type = PrivateConversation
+		conversation.Participants = []string{message.SenderID, message.ReceiverID}
+	}
+	return conversation
+}
+
+// ConversationCursor 会话列表的分页位置，按最后活跃时间和会话ID倒序
+type ConversationCursor struct {
+	LastActivity   time.Time
+	ConversationID string
+}
+
+// ConversationSettings 用户对会话的个人设置
+type ConversationSettings struct {
+	UserID         string    `bson:"user_id" json:"user_id"`
+	ConversationID string    `bson:"conversation_id" json:"conversation_id"`
+	Muted          bool      `bson:"muted" json:"muted"`
+	Pinned         bool      `bson:"pinned" json:"pinned"`
+	UpdatedAt      time.Time `bson:"updated_at" json:"updated_at"`
+
+	// 用户清空会话记录的时间，之前的消息只对该用户隐藏，会话在有新消息前不出现在会话列表中
+	ClearedAt *time.Time `bson:"cleared_at,omitempty" json:"cleared_at,omitempty"`
+}
+
+// UnreadQuery 统计用户在一个会话中未读消息的条件
+type UnreadQuery struct {
+	ConversationID string
+
+	// 用户在会话中的已读位置
+	AfterSeq int64
+
+	// 是否同时检查未读消息中有没有@用户的消息，只用于群组会话
+	CheckMentions bool
+}
+
+// UnreadSummary 用户在一个会话中的未读消息数，以及未读消息中是否有@用户的消息
+type UnreadSummary struct {
+	Count       int
+	MentionedMe bool
+}
+
+// ConversationRepository 定义会话相关的数据库操作接口
+type ConversationRepository interface {
+	// 更新会话的最后一条消息，只有序列号更大的消息才会覆盖
+	UpdateLastMessage(conversation *Conversation) error
+
+	// 最后一条消息被修改时更新会话中的消息摘要，最后一条消息已经不是该消息时不做修改
+	UpdateLastMessagePreview(conversationID string, preview *MessagePreview) error
+
+	// 按最后活跃时间倒序列出用户参与的会话
+	// groupConversationIDs为用户所在群组的会话ID，exclude中的会话不会返回
+	ListConversations(userID string, groupConversationIDs []string, cursor *ConversationCursor, exclude []string, limit int) ([]*Conversation, error)
+
+	// 根据ID获取会话，不存在的会话不会返回
+	GetConversations(ids []string) ([]*Conversation, error)
+
+	// 获取用户的所有会话设置
+	GetSettingsForUser(userID string) ([]*ConversationSettings, error)
+
+	// 获取用户对会话的设置，不存在时返回nil
+	GetSettings(userID, conversationID string) (*ConversationSettings, error)
+
+	// 保存用户对会话的设置
+	SaveSettings(settings *ConversationSettings) error
+
+	// 记录用户清空会话记录的时间，只会向后移动
+	ClearHistory(userID, conversationID string, clearedAt time.Time) error
+}
@@ -11,6 +11,19 @@ type Group struct {
 	CreatedBy int       `json:"created_by"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// 群组当前的公告，没有公告时为nil
+	Announcement *GroupAnnouncement `json:"announcement,omitempty"`
+}
+
+// GroupAnnouncement 表示群组公告，每个群组同时只有一条公告，重新发布时替换旧公告
+type GroupAnnouncement struct {
+	ID        int       `json:"id"`
+	GroupID   int       `json:"group_id"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	AuthorID  int       `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // GroupMember 表示群组成员
@@ -44,6 +57,24 @@ type GroupRepository interface {
 	
 	// 搜索群组
 	SearchGroups(query string, offset, limit int) ([]*Group, error)
+	
+	// 发布群组公告，替换群组原有的公告及其已读记录
+	SetAnnouncement(announcement *GroupAnnouncement) error
+	
+	// 获取群组当前的公告，没有公告时返回nil
+	GetAnnouncement(groupID int) (*GroupAnnouncement, error)
+	
+	// 删除群组公告
+	DeleteAnnouncement(groupID int) error
+	
+	// 记录成员已读公告，重复记录时保留第一次的已读时间
+	MarkAnnouncementRead(announcementID, userID int) error
+	
+	// 获取公告的已读成员及其已读时间
+	GetAnnouncementReads(announcementID int) (map[int]time.Time, error)
+	
+	// 获取用户所在群组中用户尚未确认已读的公告
+	GetUnreadAnnouncements(userID int) ([]*GroupAnnouncement, error)
 }
 
 // GroupMemberRepository 定义群组成员相关的数据库操作接口
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"errors"
+	"sort"
 	"time"
 	
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -30,8 +32,28 @@ const (
 	
 	// SystemMessage 系统消息
 	SystemMessage MessageType = "system"
+	
+	// ChatRecordMessage 合并转发的聊天记录
+	ChatRecordMessage MessageType = "chat_record"
+)
+
+// MessageStatus 消息投递状态
+type MessageStatus string
+
+const (
+	// MessageStatusServerAck 服务器已持久化消息
+	MessageStatusServerAck MessageStatus = "server_ack"
+	
+	// MessageStatusDelivered 接收者的设备已确认收到推送
+	MessageStatusDelivered MessageStatus = "delivered"
 )
 
+// ErrDuplicateMessage 同一发送者重复使用了客户端消息ID
+var ErrDuplicateMessage = errors.New("重复的客户端消息ID")
+
+// ErrSequenceConflict 会话中并发保存的消息过多，多次重试后仍未能分配序列号
+var ErrSequenceConflict = errors.New("会话繁忙，请稍后重试")
+
 // Message 表示聊天消息
 type Message struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
@@ -44,21 +66,237 @@ type Message struct {
 	Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
 	Read      bool               `bson:"read" json:"read"`
 	Metadata  map[string]interface{} `bson:"metadata,omitempty" json:"metadata,omitempty"`
+
+	// 会话ID，私聊为 p_<小ID>_<大ID>，群聊为 g_<群组ID>
+	ConversationID string `bson:"conversation_id,omitempty" json:"conversation_id,omitempty"`
+
+	// 会话内单调递增的序列号，用于断线重连后的增量同步
+	Seq int64 `bson:"seq,omitempty" json:"seq,omitempty"`
+
+	// 客户端生成的消息ID，同一发送者内唯一，用于重试去重
+	ClientMsgID string `bson:"client_msg_id,omitempty" json:"client_msg_id,omitempty"`
+
+	// 投递状态
+	Status      MessageStatus `bson:"status,omitempty" json:"status,omitempty"`
+	DeliveredAt *time.Time    `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+
+	// 编辑历史，按编辑时间先后保存被替换的旧版本
+	Edits    []MessageEdit `bson:"edits,omitempty" json:"edits,omitempty"`
+	EditedAt *time.Time    `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
+
+	// 撤回信息，撤回后消息内容、媒体和编辑历史被清空
+	Recalled   bool       `bson:"recalled,omitempty" json:"recalled,omitempty"`
+	RecalledBy string     `bson:"recalled_by,omitempty" json:"recalled_by,omitempty"`
+	RecalledAt *time.Time `bson:"recalled_at,omitempty" json:"recalled_at,omitempty"`
+
+	// 删除了该消息的用户，消息只对这些用户隐藏，不影响其他参与者
+	HiddenFor []string `bson:"hidden_for,omitempty" json:"-"`
+
+	// 被引用消息的摘要，发送时从被引用的消息复制，之后被引用消息的编辑不会同步
+	ReplyTo *MessagePreview `bson:"reply_to,omitempty" json:"reply_to,omitempty"`
+
+	// 群组话题的根消息ID，话题中的回复仍属于群组会话，客户端可按该字段折叠显示
+	ThreadID string `bson:"thread_id,omitempty" json:"thread_id,omitempty"`
+
+	// 话题根消息上维护的回复数、最后回复时间和参与者，参与者包括根消息的发送者和所有回复者
+	ThreadReplyCount   int        `bson:"thread_reply_count,omitempty" json:"thread_reply_count,omitempty"`
+	ThreadLastReplyAt  *time.Time `bson:"thread_last_reply_at,omitempty" json:"thread_last_reply_at,omitempty"`
+	ThreadParticipants []string   `bson:"thread_participants,omitempty" json:"thread_participants,omitempty"`
+
+	// 群组消息中被@的成员，MentionAll为true时@所有人，只有群组管理员可以@所有人
+	Mentions   []string `bson:"mentions,omitempty" json:"mentions,omitempty"`
+	MentionAll bool     `bson:"mention_all,omitempty" json:"mention_all,omitempty"`
+
+	// 逐条转发时原消息的信息
+	ForwardedFrom *ForwardInfo `bson:"forwarded_from,omitempty" json:"forwarded_from,omitempty"`
+
+	// 合并转发的聊天记录，只有chat_record类型的消息携带
+	ChatRecord *ChatRecord `bson:"chat_record,omitempty" json:"chat_record,omitempty"`
+
+	// 消息和其中的聊天记录引用的所有媒体地址，保存时生成，撤回后清空
+	// 用于判断媒体文件是否仍被其他消息引用
+	MediaRefs []string `bson:"media_refs,omitempty" json:"-"`
+
+	// 置顶信息，消息被取消置顶或撤回后清空
+	PinnedBy string     `bson:"pinned_by,omitempty" json:"pinned_by,omitempty"`
+	PinnedAt *time.Time `bson:"pinned_at,omitempty" json:"pinned_at,omitempty"`
+
+	// 表情回应，键为表情，值为回应了该表情的用户ID，按回应先后排列
+	Reactions map[string][]string `bson:"reactions,omitempty" json:"-"`
+
+	// 按查询用户汇总的表情回应，只在返回给客户端时填充
+	ReactionCounts []*ReactionCount `bson:"-" json:"reactions,omitempty"`
+}
+
+// ReactionCount 一种表情的回应人数
+type ReactionCount struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+// ForwardInfo 被转发的原消息
+type ForwardInfo struct {
+	MessageID string `bson:"message_id" json:"message_id"`
+	SenderID  string `bson:"sender_id" json:"sender_id"`
+}
+
+// ChatRecord 合并转发的一组消息，保存转发时消息的副本，原消息之后的修改和撤回不会同步
+type ChatRecord struct {
+	Title string            `bson:"title" json:"title"`
+	Items []*ChatRecordItem `bson:"items" json:"items"`
+}
+
+// ChatRecordItem 聊天记录中的一条消息
+type ChatRecordItem struct {
+	MessageID  string                 `bson:"message_id" json:"message_id"`
+	SenderID   string                 `bson:"sender_id" json:"sender_id"`
+	SenderName string                 `bson:"sender_name" json:"sender_name"`
+	Type       MessageType            `bson:"type" json:"type"`
+	Content    string                 `bson:"content" json:"content"`
+	MediaURL   string                 `bson:"media_url,omitempty" json:"media_url,omitempty"`
+	Metadata   map[string]interface{} `bson:"metadata,omitempty" json:"metadata,omitempty"`
+	Timestamp  time.Time              `bson:"timestamp" json:"timestamp"`
+
+	// 被合并转发的消息本身是聊天记录时，保存嵌套的聊天记录
+	ChatRecord *ChatRecord `bson:"chat_record,omitempty" json:"chat_record,omitempty"`
+}
+
+// MessageEdit 消息被编辑前的一个版本
+type MessageEdit struct {
+	Content string `bson:"content" json:"content"`
+
+	// 该版本被替换的时间
+	ReplacedAt time.Time `bson:"replaced_at" json:"replaced_at"`
+}
+
+// ConversationKey 返回消息所属会话的ID
+func (m *Message) ConversationKey() string {
+	if m.GroupID != "" {
+		return GroupConversationID(m.GroupID)
+	}
+	return PrivateConversationID(m.SenderID, m.ReceiverID)
+}
+
+// MediaReferences 返回消息和其中嵌套的聊天记录引用的所有媒体地址，去除重复
+func (m *Message) MediaReferences() []string {
+	var refs []string
+	seen := make(map[string]bool)
+	add := func(url string) {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			refs = append(refs, url)
+		}
+	}
+
+	add(m.MediaURL)
+	records := []*ChatRecord{m.ChatRecord}
+	for len(records) > 0 {
+		record := records[len(records)-1]
+		records = records[:len(records)-1]
+		if record == nil {
+			continue
+		}
+		for _, item := range record.Items {
+			add(item.MediaURL)
+			records = append(records, item.ChatRecord)
+		}
+	}
+	return refs
+}
+
+// SummarizeReactions 按回应人数从多到少汇总表情回应，并标记userID是否回应过
+func (m *Message) SummarizeReactions(userID string) {
+	m.ReactionCounts = nil
+	for emoji, userIDs := range m.Reactions {
+		if len(userIDs) == 0 {
+			continue
+		}
+		count := &ReactionCount{Emoji: emoji, Count: len(userIDs)}
+		for _, id := range userIDs {
+			if id == userID {
+				count.ReactedByMe = true
+				break
+			}
+		}
+		m.ReactionCounts = append(m.ReactionCounts, count)
+	}
+
+	sort.Slice(m.ReactionCounts, func(i, j int) bool {
+		if m.ReactionCounts[i].Count != m.ReactionCounts[j].Count {
+			return m.ReactionCounts[i].Count > m.ReactionCounts[j].Count
+		}
+		return m.ReactionCounts[i].Emoji < m.ReactionCounts[j].Emoji
+	})
+}
+
+// MessageViewer 查询消息历史的用户，用于过滤该用户自己删除或清空的消息
+type MessageViewer struct {
+	UserID string
+
+	// 用户清空会话记录的时间，不晚于该时间的消息对用户不可见
+	ClearedAt *time.Time
+}
+
+// CanSee 检查消息是否对用户可见
+func (v *MessageViewer) CanSee(m *Message) bool {
+	if v.ClearedAt != nil && !m.Timestamp.After(*v.ClearedAt) {
+		return false
+	}
+	for _, userID := range m.HiddenFor {
+		if userID == v.UserID {
+			return false
+		}
+	}
+	return true
+}
+
+// MessageCursor 消息历史的分页位置，按时间戳和消息ID排序
+type MessageCursor struct {
+	Timestamp time.Time
+	ID        primitive.ObjectID
+}
+
+// Cursor 返回指向该消息的分页位置
+func (m *Message) Cursor() *MessageCursor {
+	return &MessageCursor{Timestamp: m.Timestamp, ID: m.ID}
+}
+
+// 消息摘要的最大字符数
+const previewMaxRunes = 100
+
+// Preview 返回消息在会话列表中显示的摘要
+func (m *Message) Preview() *MessagePreview {
+	content := []rune(m.Content)
+	if len(content) > previewMaxRunes {
+		content = content[:previewMaxRunes]
+	}
+
+	return &MessagePreview{
+		MessageID: m.ID.Hex(),
+		SenderID:  m.SenderID,
+		Type:      m.Type,
+		Content:   string(content),
+		Timestamp: m.Timestamp,
+		Recalled:  m.Recalled,
+	}
 }
 
 // MessageRepository 定义消息相关的数据库操作接口
 type MessageRepository interface {
-	// 保存消息
+	// 保存消息，并将message.Seq设置为会话中最大的序列号加一
+	// 与并发保存的消息冲突时重新分配，因此序列号按保存顺序连续递增，不会留下空洞
+	// 客户端消息ID重复时返回ErrDuplicateMessage，多次冲突后返回ErrSequenceConflict
 	SaveMessage(message *Message) error
 	
-	// 获取单个消息
+	// 获取单个消息，不存在时返回nil
 	GetMessageByID(id string) (*Message, error)
 	
-	// 获取两个用户之间的消息历史
-	GetMessagesBetweenUsers(userID1, userID2 string, limit, offset int) ([]*Message, error)
+	// 获取两个用户之间的消息历史，viewer不为nil时过滤该用户删除或清空的消息
+	GetMessagesBetweenUsers(userID1, userID2 string, viewer *MessageViewer, limit, offset int) ([]*Message, error)
 	
-	// 获取群组消息历史
-	GetGroupMessages(groupID string, limit, offset int) ([]*Message, error)
+	// 获取群组消息历史，viewer不为nil时过滤该用户删除或清空的消息
+	GetGroupMessages(groupID string, viewer *MessageViewer, limit, offset int) ([]*Message, error)
 	
 	// 标记消息为已读
 	MarkMessageAsRead(id string) error
@@ -69,12 +307,80 @@ type MessageRepository interface {
 	// 获取用户的未读消息数
 	GetUnreadMessageCount(userID string) (int, error)
 	
-	// 删除消息
+	// 从数据库中删除消息，只用于撤回和管理操作，用户删除消息使用HideMessage
 	DeleteMessage(id string) error
 	
-	// 删除两个用户之间的所有消息
+	// 从数据库中删除两个用户之间的所有消息，只用于管理操作，用户清空会话使用会话设置中的清空标记
 	DeleteMessagesBetweenUsers(userID1, userID2 string) error
 	
-	// 删除群组的所有消息
+	// 从数据库中删除群组的所有消息，只用于管理操作
 	DeleteGroupMessages(groupID string) error
+	
+	// 对用户隐藏消息，不影响其他参与者
+	HideMessage(id, userID string) error
+	
+	// 获取ids中对用户隐藏的消息ID
+	GetHiddenMessageIDs(userID string, ids []string) ([]string, error)
+	
+	// 根据客户端消息ID获取消息，不存在时返回nil
+	GetMessageByClientMsgID(senderID, clientMsgID string) (*Message, error)
+	
+	// 将消息标记为已送达，只更新尚未送达的消息，返回是否发生了更新
+	MarkMessageDelivered(id string, deliveredAt time.Time) (bool, error)
+	
+	// 获取会话中序列号大于sinceSeq的消息，按序列号升序排列，viewer不为nil时过滤该用户删除或清空的消息
+	GetMessagesSince(conversationID string, sinceSeq int64, viewer *MessageViewer, limit int) ([]*Message, error)
+	
+	// 获取会话中序列号最大的消息，会话没有消息时返回nil
+	GetLastMessage(conversationID string) (*Message, error)
+	
+	// 修改消息内容并将旧内容追加到编辑历史，消息内容已被其他请求修改时返回false
+	EditMessage(id, oldContent, newContent string, editedAt time.Time) (bool, error)
+	
+	// 撤回消息，清空内容、媒体、编辑历史和置顶信息，只保留墓碑，消息已撤回时返回false
+	RecallMessage(id, recalledBy string, recalledAt time.Time) (bool, error)
+	
+	// 置顶消息，消息已撤回、已被置顶或会话中已有maxPinned条置顶消息时返回false
+	PinMessage(id, conversationID, pinnedBy string, pinnedAt time.Time, maxPinned int) (bool, error)
+	
+	// 取消置顶消息，消息没有被置顶时返回false
+	UnpinMessage(id string) (bool, error)
+	
+	// 获取会话中最近置顶的limit条消息，按置顶时间倒序排列，viewer不为nil时过滤该用户删除或清空的消息
+	GetPinnedMessages(conversationID string, viewer *MessageViewer, limit int) ([]*Message, error)
+	
+	// 为消息添加用户的表情回应，返回更新后的消息
+	// 消息不存在、已撤回或已有maxKinds种表情且不包括该表情时返回nil
+	AddReaction(id, emoji, userID string, maxKinds int) (*Message, error)
+	
+	// 移除用户的表情回应，返回更新后的消息，消息不存在或已撤回时返回nil
+	RemoveReaction(id, emoji, userID string) (*Message, error)
+	
+	// 清空引用了该消息的回复中保存的摘要，用于消息被撤回后
+	RecallReplySnippets(id string) error
+	
+	// 检查是否有未撤回的消息或其中的聊天记录引用了该媒体地址
+	IsMediaReferenced(mediaURL string) (bool, error)
+	
+	// 在话题根消息上记录一条新回复，返回更新后的根消息
+	AddThreadReply(rootID, userID string, repliedAt time.Time) (*Message, error)
+	
+	// 获取话题中晚于cursor的limit条回复，cursor为nil时从第一条回复开始，按时间升序排列
+	// viewer不为nil时过滤该用户删除或清空的消息
+	GetThreadReplies(rootID string, cursor *MessageCursor, viewer *MessageViewer, limit int) ([]*Message, error)
+	
+	// 获取会话中早于cursor的最近limit条消息，cursor为nil时获取最新的消息，按时间升序排列
+	// viewer不为nil时过滤该用户删除或清空的消息
+	GetMessagesBefore(conversationID string, cursor *MessageCursor, viewer *MessageViewer, limit int) ([]*Message, error)
+	
+	// 获取会话中晚于cursor的limit条消息，按时间升序排列，viewer不为nil时过滤该用户删除或清空的消息
+	GetMessagesAfter(conversationID string, cursor *MessageCursor, viewer *MessageViewer, limit int) ([]*Message, error)
+	
+	// 获取groupIDs中@了userID的消息，包括@所有人，不包括已撤回和userID删除的消息
+	// 返回早于cursor的最近limit条消息，cursor为nil时从最新的消息开始，按时间倒序排列
+	GetMentions(userID string, groupIDs []string, cursor *MessageCursor, limit int) ([]*Message, error)
+	
+	// 一次查询统计用户在多个会话中序列号大于AfterSeq且不是userID发送的消息数，不包括userID删除的消息，每个会话最多统计到limit
+	// CheckMentions为true的会话同时检查其中是否有@了userID的消息，包括@所有人；没有未读消息的会话不在结果中
+	SummarizeUnread(userID string, queries []UnreadQuery, limit int) (map[string]UnreadSummary, error)
 } 
\ No newline at end of file
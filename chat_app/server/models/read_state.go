@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// ReadState 用户在会话中的已读位置，序列号不大于LastReadSeq的消息视为已读
+type ReadState struct {
+	ConversationID string    `bson:"conversation_id" json:"conversation_id"`
+	UserID         string    `bson:"user_id" json:"user_id"`
+	LastReadSeq    int64     `bson:"last_read_seq" json:"last_read_seq"`
+	UpdatedAt      time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// ReadStateRepository 定义已读位置相关的数据库操作接口
+type ReadStateRepository interface {
+	// 将用户的已读位置前移到seq，已读位置只会增大，返回更新前的位置和是否发生了更新
+	AdvanceReadState(conversationID, userID string, seq int64, at time.Time) (int64, bool, error)
+
+	// 获取用户在会话中的已读位置，不存在时返回nil
+	GetReadState(conversationID, userID string) (*ReadState, error)
+
+	// 获取会话中所有用户的已读位置
+	GetReadStates(conversationID string) ([]*ReadState, error)
+
+	// 获取用户在多个会话中的已读位置，没有已读位置的会话不在结果中
+	GetUserReadStates(userID string, conversationIDs []string) ([]*ReadState, error)
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ScheduledMessageStatus 定时消息的状态
+type ScheduledMessageStatus string
+
+const (
+	// ScheduledPending 等待发送，只有该状态的定时消息可以修改和取消
+	ScheduledPending ScheduledMessageStatus = "pending"
+
+	// ScheduledSending 已被某个节点领取，正在发送
+	ScheduledSending ScheduledMessageStatus = "sending"
+
+	// ScheduledSent 已发送
+	ScheduledSent ScheduledMessageStatus = "sent"
+
+	// ScheduledFailed 发送失败，例如发送者已不在群组中
+	ScheduledFailed ScheduledMessageStatus = "failed"
+
+	// ScheduledCancelled 已被发送者取消
+	ScheduledCancelled ScheduledMessageStatus = "cancelled"
+)
+
+// ScheduledMessage 表示一条等待在指定时间发送的消息
+type ScheduledMessage struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SenderID   string             `bson:"sender_id" json:"sender_id"`
+	ReceiverID string             `bson:"receiver_id,omitempty" json:"receiver_id,omitempty"`
+	GroupID    string             `bson:"group_id,omitempty" json:"group_id,omitempty"`
+	Type       MessageType        `bson:"type" json:"type"`
+	Content    string             `bson:"content" json:"content"`
+	MediaURL   string             `bson:"media_url,omitempty" json:"media_url,omitempty"`
+
+	// 发送时使用的引用、话题和@信息，保存和发送时都会校验
+	ReplyToID  string   `bson:"reply_to_id,omitempty" json:"reply_to_id,omitempty"`
+	ThreadID   string   `bson:"thread_id,omitempty" json:"thread_id,omitempty"`
+	Mentions   []string `bson:"mentions,omitempty" json:"mentions,omitempty"`
+	MentionAll bool     `bson:"mention_all,omitempty" json:"mention_all,omitempty"`
+
+	// 计划发送的时间
+	SendAt time.Time              `bson:"send_at" json:"send_at"`
+	Status ScheduledMessageStatus `bson:"status" json:"status"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+
+	// 等待发送时占用的发送者等待位置，位置数即每个用户最多同时等待发送的定时消息数
+	// 消息被领取或取消后释放位置
+	PendingSlot *int `bson:"pending_slot,omitempty" json:"-"`
+
+	// 节点领取该消息的时间和领取次数，领取后长时间未完成的消息会被重新领取
+	ClaimedAt *time.Time `bson:"claimed_at,omitempty" json:"-"`
+	Attempts  int        `bson:"attempts,omitempty" json:"-"`
+
+	// 发送结果，发送成功时为生成的消息ID，失败时为错误原因
+	MessageID string     `bson:"message_id,omitempty" json:"message_id,omitempty"`
+	SentAt    *time.Time `bson:"sent_at,omitempty" json:"sent_at,omitempty"`
+	Error     string     `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// ClientMsgID 返回发送定时消息时使用的客户端消息ID
+// 领取后未完成的消息被重新发送时，消息去重保证只保存一次
+func (m *ScheduledMessage) ClientMsgID() string {
+	return "scheduled_" + m.ID.Hex()
+}
+
+// ScheduledMessageRepository 定义定时消息相关的数据库操作接口
+type ScheduledMessageRepository interface {
+	// 为新的定时消息分配空闲的等待位置并保存，发送者等待发送的消息已达到maxPending条时返回false
+	CreateScheduledMessage(message *ScheduledMessage, maxPending int) (bool, error)
+
+	// 获取定时消息，不存在时返回nil
+	GetScheduledMessage(id string) (*ScheduledMessage, error)
+
+	// 获取发送者处于statuses中的定时消息，按计划发送时间升序排列
+	ListScheduledMessages(senderID string, statuses []ScheduledMessageStatus) ([]*ScheduledMessage, error)
+
+	// 修改等待发送的定时消息的内容和发送时间，消息不是该发送者的或已不在等待状态时返回false
+	UpdateScheduledMessage(id, senderID, content string, sendAt, updatedAt time.Time) (bool, error)
+
+	// 取消等待发送的定时消息，消息不是该发送者的或已不在等待状态时返回false
+	CancelScheduledMessage(id, senderID string, cancelledAt time.Time) (bool, error)
+
+	// 领取一条到期的定时消息并标记为发送中，领取时间早于staleBefore的发送中消息可以被重新领取
+	// 多个节点并发领取时每条消息只会被一个节点领取，没有到期的消息时返回nil
+	ClaimDueScheduledMessage(now, staleBefore time.Time) (*ScheduledMessage, error)
+
+	// 将发送中的定时消息标记为已发送
+	CompleteScheduledMessage(id, messageID string, sentAt time.Time) error
+
+	// 将发送中的定时消息标记为发送失败
+	FailScheduledMessage(id, reason string, failedAt time.Time) error
+
+	// 检查是否有等待发送或发送中的定时消息引用了该媒体地址
+	IsMediaReferenced(mediaURL string) (bool, error)
+}
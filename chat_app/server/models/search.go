@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// MessageSearchQuery 消息搜索条件，搜索范围由UserID和GroupIDs限定
+type MessageSearchQuery struct {
+	// 搜索词，按空白分隔的关键词需要同时匹配
+	Text string
+
+	// 搜索者，只搜索其发送或接收的私聊消息和GroupIDs中的群组消息
+	UserID   string
+	GroupIDs []string
+
+	// 只搜索指定会话，为空时搜索所有可访问的会话
+	ConversationID string
+
+	// 用户清空会话记录的时间，键为会话ID，不晚于该时间的消息不参与搜索
+	ClearedAt map[string]time.Time
+
+	SenderID string
+	Type     MessageType
+	Since    *time.Time
+	Until    *time.Time
+
+	// 返回早于该位置的消息，为nil时从最新的消息开始
+	Cursor *MessageCursor
+	Limit  int
+}
+
+// MessageSearchIndex 消息全文索引，默认实现将词元保存在MongoDB的消息文档中，也可以替换为嵌入式索引
+type MessageSearchIndex interface {
+	// 为新消息或被编辑的消息建立索引
+	IndexMessage(message *Message) error
+
+	// 从索引中移除消息，用于消息被撤回后
+	RemoveMessage(id string) error
+
+	// 搜索消息，按时间倒序返回，不包括系统消息、已撤回的消息和搜索者删除的消息
+	Search(query *MessageSearchQuery) ([]*Message, error)
+}
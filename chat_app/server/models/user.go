@@ -65,3 +65,41 @@ type ContactRepository interface {
 	// 检查是否为联系人
 	IsContact(userID, contactID int) (bool, error)
 }
+
+// PresenceVisibility 在线状态的可见范围
+type PresenceVisibility string
+
+const (
+	// PresenceVisibleEveryone 所有人可见
+	PresenceVisibleEveryone PresenceVisibility = "everyone"
+
+	// PresenceVisibleContacts 仅联系人可见
+	PresenceVisibleContacts PresenceVisibility = "contacts"
+
+	// PresenceVisibleNobody 所有人不可见
+	PresenceVisibleNobody PresenceVisibility = "nobody"
+)
+
+// PrivacySettings 表示用户的隐私设置
+type PrivacySettings struct {
+	UserID             int                `json:"user_id"`
+	PresenceVisibility PresenceVisibility `json:"presence_visibility"`
+	UpdatedAt          time.Time          `json:"updated_at"`
+}
+
+// Presence 表示用户的在线状态
+type Presence struct {
+	UserID   int        `json:"user_id"`
+	Visible  bool       `json:"visible"`
+	IsOnline bool       `json:"is_online"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// PrivacyRepository 定义隐私设置相关的数据库操作接口
+type PrivacyRepository interface {
+	// 获取用户的隐私设置，没有记录时返回默认设置
+	GetPrivacySettings(userID int) (*PrivacySettings, error)
+
+	// 保存用户的隐私设置
+	SavePrivacySettings(settings *PrivacySettings) error
+}
@@ -0,0 +1,398 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat_app/server/models"
+)
+
+// ErrInvalidCursor 分页游标格式无效
+var ErrInvalidCursor = errors.New("无效的分页游标")
+
+const (
+	// 会话列表默认每页返回的会话数
+	defaultConversationLimit = 20
+
+	// 会话列表每页最多返回的会话数
+	maxConversationLimit = 100
+
+	// 单个会话最多统计的未读数，客户端超过该值时显示为 999+
+	maxUnreadCount = 999
+)
+
+// 会话列表中未读消息@了当前用户时显示的标记
+const mentionMarker = "[有人@我]"
+
+// ConversationSummary 会话列表中的一项
+type ConversationSummary struct {
+	ConversationID string                  `json:"conversation_id"`
+	ChatID         string                  `json:"chat_id"`
+	Type           models.ConversationType `json:"type"`
+	PeerID         string                  `json:"peer_id,omitempty"`
+	GroupID        string                  `json:"group_id,omitempty"`
+	LastMessage    *models.MessagePreview  `json:"last_message,omitempty"`
+	LastActivity   time.Time               `json:"last_activity"`
+	UnreadCount    int                     `json:"unread_count"`
+
+	// 未读消息中有@当前用户的消息时为true，客户端显示MentionMarker
+	MentionedMe   bool   `json:"mentioned_me"`
+	MentionMarker string `json:"mention_marker,omitempty"`
+	Muted         bool   `json:"muted"`
+	Pinned        bool   `json:"pinned"`
+}
+
+// ConversationPage 会话列表的一页
+type ConversationPage struct {
+	Conversations []*ConversationSummary `json:"conversations"`
+
+	// 下一页的游标，没有更多会话时为空
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ConversationService 处理会话列表相关的业务逻辑
+type ConversationService struct {
+	conversationRepo models.ConversationRepository
+	messageRepo      models.MessageRepository
+	readStateRepo    models.ReadStateRepository
+	groupRepo        models.GroupRepository
+	groupMemberRepo  models.GroupMemberRepository
+}
+
+// NewConversationService 创建新的会话服务
+func NewConversationService(
+	conversationRepo models.ConversationRepository,
+	messageRepo models.MessageRepository,
+	readStateRepo models.ReadStateRepository,
+	groupRepo models.GroupRepository,
+	groupMemberRepo models.GroupMemberRepository,
+) *ConversationService {
+	return &ConversationService{
+		conversationRepo: conversationRepo,
+		messageRepo:      messageRepo,
+		readStateRepo:    readStateRepo,
+		groupRepo:        groupRepo,
+		groupMemberRepo:  groupMemberRepo,
+	}
+}
+
+// ListConversations 按最后活跃时间倒序返回用户的会话
+// 置顶的会话只在第一页的开头返回，后续分页中不再出现
+func (s *ConversationService) ListConversations(userID, cursor string, limit int) (*ConversationPage, error) {
+	if limit <= 0 {
+		limit = defaultConversationLimit
+	}
+	if limit > maxConversationLimit {
+		limit = maxConversationLimit
+	}
+
+	after, err := decodeConversationCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, err := strconv.Atoi(userID)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.groupRepo.GetGroupsByMember(uid)
+	if err != nil {
+		return nil, err
+	}
+	groupConversationIDs := make([]string, 0, len(groups))
+	inGroup := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		id := models.GroupConversationID(strconv.Itoa(group.ID))
+		groupConversationIDs = append(groupConversationIDs, id)
+		inGroup[id] = true
+	}
+
+	settingsList, err := s.conversationRepo.GetSettingsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	settings := make(map[string]*models.ConversationSettings, len(settingsList))
+	var pinnedIDs []string
+	for _, item := range settingsList {
+		settings[item.ConversationID] = item
+		if item.Pinned && (inGroup[item.ConversationID] || isPrivateParticipant(item.ConversationID, userID)) {
+			pinnedIDs = append(pinnedIDs, item.ConversationID)
+		}
+	}
+
+	var conversations []*models.Conversation
+	if after == nil && len(pinnedIDs) > 0 {
+		pinned, err := s.conversationRepo.GetConversations(pinnedIDs)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, pinned...)
+	}
+
+	// 多取一条用于判断是否还有下一页
+	page, err := s.conversationRepo.ListConversations(userID, groupConversationIDs, after, pinnedIDs, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ConversationPage{}
+	if len(page) > limit {
+		page = page[:limit]
+		last := page[limit-1]
+		result.NextCursor = encodeConversationCursor(&models.ConversationCursor{
+			LastActivity:   last.LastActivity,
+			ConversationID: last.ID,
+		})
+	}
+	conversations = append(conversations, page...)
+
+	visible := make([]*models.Conversation, 0, len(conversations))
+	result.Conversations = make([]*ConversationSummary, 0, len(conversations))
+	for _, conversation := range conversations {
+		// 用户删除的会话在有新消息前不再出现
+		if item := settings[conversation.ID]; item != nil && item.ClearedAt != nil && !conversation.LastActivity.After(*item.ClearedAt) {
+			continue
+		}
+
+		summary, err := s.summarize(userID, conversation, settings[conversation.ID])
+		if err != nil {
+			return nil, err
+		}
+		visible = append(visible, conversation)
+		result.Conversations = append(result.Conversations, summary)
+	}
+
+	if err := s.countUnread(userID, visible, result.Conversations); err != nil {
+		return nil, err
+	}
+	if err := s.replaceHiddenPreviews(userID, result.Conversations, settings); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// UpdateSettings 更新用户对会话的免打扰和置顶设置，参数为nil时保持不变
+func (s *ConversationService) UpdateSettings(userID, conversationID string, muted, pinned *bool) (*models.ConversationSettings, error) {
+	if err := s.checkAccess(userID, conversationID); err != nil {
+		return nil, err
+	}
+
+	settings, err := s.conversationRepo.GetSettings(userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		settings = &models.ConversationSettings{UserID: userID, ConversationID: conversationID}
+	}
+	if muted != nil {
+		settings.Muted = *muted
+	}
+	if pinned != nil {
+		settings.Pinned = *pinned
+	}
+	settings.UpdatedAt = time.Now()
+
+	if err := s.conversationRepo.SaveSettings(settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// summarize 生成会话列表项，未读数由countUnread统一统计
+func (s *ConversationService) summarize(userID string, conversation *models.Conversation, settings *models.ConversationSettings) (*ConversationSummary, error) {
+	summary := &ConversationSummary{
+		ConversationID: conversation.ID,
+		Type:           conversation.Type,
+		GroupID:        conversation.GroupID,
+		LastMessage:    conversation.LastMessage,
+		LastActivity:   conversation.LastActivity,
+	}
+
+	if conversation.Type == models.GroupConversation {
+		summary.ChatID = models.GroupChatID(conversation.GroupID)
+	} else {
+		ref, err := models.ParseConversationID(conversation.ID)
+		if err != nil {
+			return nil, err
+		}
+		summary.PeerID = ref.Peer(userID)
+		summary.ChatID = models.PrivateChatID(summary.PeerID)
+	}
+
+	if settings != nil {
+		summary.Muted = settings.Muted
+		summary.Pinned = settings.Pinned
+	}
+
+	return summary, nil
+}
+
+// countUnread 统计一页会话的未读数和未读的@，summaries与conversations一一对应
+// 已读位置和未读数各只查询一次，不随会话数增加查询次数
+func (s *ConversationService) countUnread(userID string, conversations []*models.Conversation, summaries []*ConversationSummary) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(conversations))
+	for i, conversation := range conversations {
+		ids[i] = conversation.ID
+	}
+	states, err := s.readStateRepo.GetUserReadStates(userID, ids)
+	if err != nil {
+		return err
+	}
+	lastReadSeqs := make(map[string]int64, len(states))
+	for _, state := range states {
+		lastReadSeqs[state.ConversationID] = state.LastReadSeq
+	}
+
+	var queries []models.UnreadQuery
+	for _, conversation := range conversations {
+		lastReadSeq := lastReadSeqs[conversation.ID]
+		if conversation.LastSeq <= lastReadSeq {
+			continue
+		}
+		queries = append(queries, models.UnreadQuery{
+			ConversationID: conversation.ID,
+			AfterSeq:       lastReadSeq,
+			CheckMentions:  conversation.Type == models.GroupConversation,
+		})
+	}
+	if len(queries) == 0 {
+		return nil
+	}
+
+	unread, err := s.messageRepo.SummarizeUnread(userID, queries, maxUnreadCount)
+	if err != nil {
+		return err
+	}
+	for _, summary := range summaries {
+		counts := unread[summary.ConversationID]
+		summary.UnreadCount = counts.Count
+		if counts.Count > 0 && counts.MentionedMe {
+			summary.MentionedMe = true
+			summary.MentionMarker = mentionMarker
+		}
+	}
+	return nil
+}
+
+// replaceHiddenPreviews 将用户自己删除的最后一条消息替换为该用户可见的最近一条消息
+// 会话记录中的最后一条消息由所有参与者共享，只有被删除的会话需要单独查询
+func (s *ConversationService) replaceHiddenPreviews(userID string, summaries []*ConversationSummary, settings map[string]*models.ConversationSettings) error {
+	var ids []string
+	for _, summary := range summaries {
+		if summary.LastMessage != nil {
+			ids = append(ids, summary.LastMessage.MessageID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	hiddenIDs, err := s.messageRepo.GetHiddenMessageIDs(userID, ids)
+	if err != nil {
+		return err
+	}
+	if len(hiddenIDs) == 0 {
+		return nil
+	}
+	hidden := make(map[string]bool, len(hiddenIDs))
+	for _, id := range hiddenIDs {
+		hidden[id] = true
+	}
+
+	for _, summary := range summaries {
+		if summary.LastMessage == nil || !hidden[summary.LastMessage.MessageID] {
+			continue
+		}
+
+		viewer := &models.MessageViewer{UserID: userID}
+		if item := settings[summary.ConversationID]; item != nil {
+			viewer.ClearedAt = item.ClearedAt
+		}
+		messages, err := s.messageRepo.GetMessagesBefore(summary.ConversationID, nil, viewer, 1)
+		if err != nil {
+			return err
+		}
+
+		summary.LastMessage = nil
+		if len(messages) > 0 {
+			summary.LastMessage = messages[0].Preview()
+		}
+	}
+	return nil
+}
+
+// checkAccess 检查用户是否为会话的参与者
+func (s *ConversationService) checkAccess(userID, conversationID string) error {
+	ref, err := models.ParseConversationID(conversationID)
+	if err != nil {
+		return err
+	}
+
+	if ref.Type == models.PrivateConversation {
+		if !ref.HasParticipant(userID) {
+			return ErrNotParticipant
+		}
+		return nil
+	}
+
+	groupID, err := strconv.Atoi(ref.GroupID)
+	if err != nil {
+		return models.ErrInvalidConversationID
+	}
+	uid, err := strconv.Atoi(userID)
+	if err != nil {
+		return err
+	}
+	isMember, err := s.groupMemberRepo.IsMember(groupID, uid)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return ErrNotParticipant
+	}
+	return nil
+}
+
+// isPrivateParticipant 检查会话是否为用户参与的私聊会话
+func isPrivateParticipant(conversationID, userID string) bool {
+	ref, err := models.ParseConversationID(conversationID)
+	return err == nil && ref.HasParticipant(userID)
+}
+
+// encodeConversationCursor 将分页位置编码为不透明的游标
+func encodeConversationCursor(cursor *models.ConversationCursor) string {
+	raw := strconv.FormatInt(cursor.LastActivity.UnixNano(), 10) + "|" + cursor.ConversationID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeConversationCursor 解析游标，游标为空时返回nil
+func decodeConversationCursor(cursor string) (*models.ConversationCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &models.ConversationCursor{
+		LastActivity:   time.Unix(0, nanos),
+		ConversationID: parts[1],
+	}, nil
+}
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+)
+
+var (
+	// ErrAnnouncementForbidden 用户无权发布或删除群组公告
+	ErrAnnouncementForbidden = errors.New("只有群组管理员可以发布或删除公告")
+
+	// ErrInvalidAnnouncement 公告标题或内容为空或过长
+	ErrInvalidAnnouncement = errors.New("公告标题不能超过100个字符，内容不能为空且不能超过2000个字符")
+
+	// ErrAnnouncementNotFound 群组没有公告，或要确认的公告已被替换
+	ErrAnnouncementNotFound = errors.New("公告不存在或已被更新")
+)
+
+const (
+	// 公告标题和内容的最大字符数
+	maxAnnouncementTitleRunes = 100
+	maxAnnouncementBodyRunes  = 2000
+)
+
+// GroupAnnouncementEvent group_announcement帧的负载，公告被删除时Announcement为nil
+type GroupAnnouncementEvent struct {
+	GroupID      int                       `json:"group_id"`
+	ChatID       string                    `json:"chat_id"`
+	Announcement *models.GroupAnnouncement `json:"announcement"`
+
+	// 接收者是否需要确认已读，发布者自己不需要确认
+	MustRead bool `json:"must_read"`
+}
+
+// AnnouncementStatus 群组公告及查询用户的已读状态
+type AnnouncementStatus struct {
+	Announcement *models.GroupAnnouncement `json:"announcement"`
+
+	// 用户确认已读的时间，未确认时MustRead为true
+	ReadAt   *time.Time `json:"read_at,omitempty"`
+	MustRead bool       `json:"must_read"`
+
+	// 成员的已读情况，只返回给群组管理员
+	Receipts *AnnouncementReceipts `json:"receipts,omitempty"`
+}
+
+// AnnouncementReceipts 公告的已读和未读人数，以及尚未确认的成员
+type AnnouncementReceipts struct {
+	ReadCount       int   `json:"read_count"`
+	UnreadCount     int   `json:"unread_count"`
+	UnreadMemberIDs []int `json:"unread_member_ids"`
+}
+
+// SetPushService 设置推送group_announcement帧使用的推送服务，必须在开始处理请求之前调用
+func (s *GroupService) SetPushService(push *PushService) {
+	s.push = push
+}
+
+// PublishAnnouncement 发布群组公告并替换原有的公告，只有群组管理员可以发布
+// 所有成员收到group_announcement帧，除发布者外的成员都需要确认已读
+func (s *GroupService) PublishAnnouncement(groupID, userID int, title, body string) (*models.GroupAnnouncement, error) {
+	isAdmin, err := s.groupMemberRepo.IsAdmin(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin {
+		return nil, ErrAnnouncementForbidden
+	}
+
+	title = strings.TrimSpace(title)
+	body = strings.TrimSpace(body)
+	if body == "" || utf8.RuneCountInString(title) > maxAnnouncementTitleRunes ||
+		utf8.RuneCountInString(body) > maxAnnouncementBodyRunes {
+		return nil, ErrInvalidAnnouncement
+	}
+
+	announcement := &models.GroupAnnouncement{
+		GroupID:  groupID,
+		Title:    title,
+		Body:     body,
+		AuthorID: userID,
+	}
+	if err := s.groupRepo.SetAnnouncement(announcement); err != nil {
+		return nil, err
+	}
+
+	// 发布者无需确认自己发布的公告
+	if err := s.groupRepo.MarkAnnouncementRead(announcement.ID, userID); err != nil {
+		log.Printf("记录用户 %d 已读群组 %d 的公告失败: %v", userID, groupID, err)
+	}
+
+	s.pushAnnouncement(groupID, userID, announcement)
+	return announcement, nil
+}
+
+// DeleteAnnouncement 删除群组公告，只有群组管理员可以删除
+func (s *GroupService) DeleteAnnouncement(groupID, userID int) error {
+	isAdmin, err := s.groupMemberRepo.IsAdmin(groupID, userID)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return ErrAnnouncementForbidden
+	}
+
+	announcement, err := s.groupRepo.GetAnnouncement(groupID)
+	if err != nil {
+		return err
+	}
+	if announcement == nil {
+		return nil
+	}
+
+	if err := s.groupRepo.DeleteAnnouncement(groupID); err != nil {
+		return err
+	}
+
+	s.pushAnnouncement(groupID, userID, nil)
+	return nil
+}
+
+// GetAnnouncement 获取群组当前的公告及用户的已读状态，管理员同时获取成员的已读情况
+func (s *GroupService) GetAnnouncement(groupID, userID int) (*AnnouncementStatus, error) {
+	isMember, err := s.groupMemberRepo.IsMember(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, ErrNotGroupMember
+	}
+
+	announcement, err := s.groupRepo.GetAnnouncement(groupID)
+	if err != nil {
+		return nil, err
+	}
+	if announcement == nil {
+		return nil, ErrAnnouncementNotFound
+	}
+
+	reads, err := s.groupRepo.GetAnnouncementReads(announcement.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &AnnouncementStatus{Announcement: announcement, MustRead: true}
+	if readAt, ok := reads[userID]; ok {
+		status.ReadAt = &readAt
+		status.MustRead = false
+	}
+
+	isAdmin, err := s.groupMemberRepo.IsAdmin(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin {
+		return status, nil
+	}
+
+	members, err := s.groupMemberRepo.GetMembers(groupID)
+	if err != nil {
+		return nil, err
+	}
+	receipts := &AnnouncementReceipts{UnreadMemberIDs: []int{}}
+	for _, member := range members {
+		if _, ok := reads[member.ID]; ok {
+			receipts.ReadCount++
+		} else {
+			receipts.UnreadMemberIDs = append(receipts.UnreadMemberIDs, member.ID)
+		}
+	}
+	receipts.UnreadCount = len(receipts.UnreadMemberIDs)
+	status.Receipts = receipts
+
+	return status, nil
+}
+
+// MarkAnnouncementRead 确认已读群组公告，announcementID必须是群组当前的公告
+func (s *GroupService) MarkAnnouncementRead(groupID, userID, announcementID int) error {
+	isMember, err := s.groupMemberRepo.IsMember(groupID, userID)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return ErrNotGroupMember
+	}
+
+	announcement, err := s.groupRepo.GetAnnouncement(groupID)
+	if err != nil {
+		return err
+	}
+	// 确认的公告已被替换时，用户需要阅读新公告
+	if announcement == nil || announcement.ID != announcementID {
+		return ErrAnnouncementNotFound
+	}
+
+	return s.groupRepo.MarkAnnouncementRead(announcementID, userID)
+}
+
+// GetUnreadAnnouncements 获取用户尚未确认已读的群组公告，客户端登录后据此弹出公告
+func (s *GroupService) GetUnreadAnnouncements(userID int) ([]*models.GroupAnnouncement, error) {
+	announcements, err := s.groupRepo.GetUnreadAnnouncements(userID)
+	if err != nil {
+		return nil, err
+	}
+	if announcements == nil {
+		announcements = []*models.GroupAnnouncement{}
+	}
+	return announcements, nil
+}
+
+// pushAnnouncement 向群组的所有成员推送group_announcement帧
+func (s *GroupService) pushAnnouncement(groupID, operatorID int, announcement *models.GroupAnnouncement) {
+	if s.push == nil {
+		return
+	}
+
+	members, err := s.groupMemberRepo.GetMembers(groupID)
+	if err != nil {
+		log.Printf("获取群组 %d 的成员失败: %v", groupID, err)
+		return
+	}
+
+	for _, member := range members {
+		event := &GroupAnnouncementEvent{
+			GroupID:      groupID,
+			ChatID:       models.GroupChatID(strconv.Itoa(groupID)),
+			Announcement: announcement,
+			MustRead:     announcement != nil && member.ID != operatorID,
+		}
+		memberID := strconv.Itoa(member.ID)
+		if err := s.push.PushFrame(memberID, websocket.FrameGroupAnnouncement, event); err != nil {
+			log.Printf("向用户 %s 推送 %s 帧失败: %v", memberID, websocket.FrameGroupAnnouncement, err)
+		}
+	}
+}
@@ -1,12 +1,16 @@
 package services
 
 import (
+	"context"
 	"io"
+	"log"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
+	"chat_app/server/database"
 	"chat_app/server/models"
 )
 
@@ -14,20 +18,26 @@ import (
 type GroupService struct {
 	groupRepo       models.GroupRepository
 	groupMemberRepo models.GroupMemberRepository
+	redisDB         *database.RedisDB
 	uploadPath      string
 	serverBaseURL   string
+
+	// 推送服务，为nil时不推送群组公告
+	push *PushService
 }
 
 // NewGroupService 创建新的群组服务
 func NewGroupService(
 	groupRepo models.GroupRepository,
 	groupMemberRepo models.GroupMemberRepository,
+	redisDB *database.RedisDB,
 	uploadPath string,
 	serverBaseURL string,
 ) *GroupService {
 	return &GroupService{
 		groupRepo:       groupRepo,
 		groupMemberRepo: groupMemberRepo,
+		redisDB:         redisDB,
 		uploadPath:      uploadPath,
 		serverBaseURL:   serverBaseURL,
 	}
@@ -70,7 +80,13 @@ func (s *GroupService) UpdateGroup(group *models.Group) error {
 
 // DeleteGroup 删除群组
 func (s *GroupService) DeleteGroup(groupID int) error {
-	return s.groupRepo.DeleteGroup(groupID)
+	err := s.groupRepo.DeleteGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	s.invalidateMemberCache(groupID)
+	return nil
 }
 
 // GetUserGroups 获取用户加入的群组
@@ -90,6 +106,9 @@ func (s *GroupService) GetGroupAdmins(groupID int) ([]*models.User, error) {
 
 // AddGroupMembers 添加群组成员
 func (s *GroupService) AddGroupMembers(groupID int, userIDs []int) error {
+	// 即使部分成员添加失败，已添加的成员也需要使缓存失效
+	defer s.invalidateMemberCache(groupID)
+
 	for _, userID := range userIDs {
 		err := s.groupMemberRepo.AddMember(groupID, userID, false)
 		if err != nil {
@@ -101,7 +120,25 @@ func (s *GroupService) AddGroupMembers(groupID int, userIDs []int) error {
 
 // RemoveGroupMember 移除群组成员
 func (s *GroupService) RemoveGroupMember(groupID int, userID int) error {
-	return s.groupMemberRepo.RemoveMember(groupID, userID)
+	err := s.groupMemberRepo.RemoveMember(groupID, userID)
+	if err != nil {
+		return err
+	}
+
+	s.invalidateMemberCache(groupID)
+	return nil
+}
+
+// invalidateMemberCache 使群组成员缓存失效，消息服务下次投递时会重新加载
+func (s *GroupService) invalidateMemberCache(groupID int) {
+	if s.redisDB == nil {
+		return
+	}
+
+	err := s.redisDB.InvalidateGroupMembers(context.Background(), strconv.Itoa(groupID))
+	if err != nil {
+		log.Printf("清除群组 %d 的成员缓存失败: %v", groupID, err)
+	}
 }
 
 // IsGroupAdmin 检查用户是否为群组管理员
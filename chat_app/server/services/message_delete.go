@@ -0,0 +1,96 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+)
+
+// MessageDeletedEvent message_deleted帧的负载
+type MessageDeletedEvent struct {
+	MessageID      string `json:"message_id"`
+	ConversationID string `json:"conversation_id"`
+}
+
+// ConversationClearedEvent conversation_cleared帧的负载
+type ConversationClearedEvent struct {
+	ConversationID string    `json:"conversation_id"`
+	ChatID         string    `json:"chat_id"`
+	ClearedAt      time.Time `json:"cleared_at"`
+}
+
+// DeleteMessage 删除消息，forEveryone为false时只对用户自己隐藏，其他参与者的副本不受影响
+// forEveryone为true时按撤回处理，受撤回的权限和时间限制
+func (s *MessageService) DeleteMessage(userID, messageID string, forEveryone bool) error {
+	if forEveryone {
+		_, err := s.RecallMessage(userID, messageID)
+		return err
+	}
+
+	message, err := s.getMessage(messageID)
+	if err != nil {
+		return err
+	}
+	conversationID := message.ConversationKey()
+	if err := s.checkConversationAccess(userID, conversationID); err != nil {
+		return err
+	}
+
+	if err := s.messageRepo.HideMessage(messageID, userID); err != nil {
+		return err
+	}
+
+	s.pushFrame(userID, websocket.FrameMessageDeleted, &MessageDeletedEvent{
+		MessageID:      messageID,
+		ConversationID: conversationID,
+	})
+	return nil
+}
+
+// ClearConversation 清空用户的会话记录并将会话从会话列表中移除，其他参与者的记录不受影响
+// 会话中的消息同时被标记为已读，会话在收到新消息后重新出现
+func (s *MessageService) ClearConversation(userID, conversationID string) (*ConversationClearedEvent, error) {
+	if err := s.checkConversationAccess(userID, conversationID); err != nil {
+		return nil, err
+	}
+	ref, err := models.ParseConversationID(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &ConversationClearedEvent{
+		ConversationID: conversationID,
+		ClearedAt:      time.Now(),
+	}
+	if ref.Type == models.PrivateConversation {
+		event.ChatID = models.PrivateChatID(ref.Peer(userID))
+	} else {
+		event.ChatID = models.GroupChatID(ref.GroupID)
+	}
+
+	if err := s.conversationRepo.ClearHistory(userID, conversationID, event.ClearedAt); err != nil {
+		return nil, err
+	}
+	if _, err := s.MarkConversationRead(userID, conversationID, 0); err != nil {
+		log.Printf("清空会话 %s 时标记已读失败: %v", conversationID, err)
+	}
+
+	s.pushFrame(userID, websocket.FrameConversationCleared, event)
+	return event, nil
+}
+
+// messageViewer 返回用户查询会话消息时使用的过滤条件
+func (s *MessageService) messageViewer(userID, conversationID string) (*models.MessageViewer, error) {
+	settings, err := s.conversationRepo.GetSettings(userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	viewer := &models.MessageViewer{UserID: userID}
+	if settings != nil {
+		viewer.ClearedAt = settings.ClearedAt
+	}
+	return viewer, nil
+}
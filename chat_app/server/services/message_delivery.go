@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATS主题前缀
+const (
+	privateSubjectPrefix = "message.private."
+	groupSubjectPrefix   = "message.group."
+)
+
+// 群组成员缓存的有效期
+const groupMembersCacheTTL = 10 * time.Minute
+
+// StartDelivery 订阅NATS消息主题，将其他节点发布的消息投递给本节点的WebSocket连接
+// 每个节点都需要收到所有消息，因此使用普通订阅而不是队列订阅
+func (s *MessageService) StartDelivery() error {
+	if s.natsDB == nil {
+		return nil
+	}
+
+	sub, err := s.natsDB.SubscribeToMessages(privateSubjectPrefix+"*", s.handlePrivateDelivery)
+	if err != nil {
+		return err
+	}
+	s.subscriptions = append(s.subscriptions, sub)
+
+	sub, err = s.natsDB.SubscribeToMessages(groupSubjectPrefix+"*", s.handleGroupDelivery)
+	if err != nil {
+		return err
+	}
+	s.subscriptions = append(s.subscriptions, sub)
+
+	return nil
+}
+
+// StopDelivery 取消NATS订阅
+func (s *MessageService) StopDelivery() {
+	for _, sub := range s.subscriptions {
+		if err := sub.Unsubscribe(); err != nil {
+			log.Printf("取消订阅 %s 失败: %v", sub.Subject, err)
+		}
+	}
+	s.subscriptions = nil
+}
+
+// publish 通过NATS发布已保存的消息，由各节点投递给本地连接的接收者
+func (s *MessageService) publish(message *models.Message) error {
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	if message.ReceiverID != "" {
+		return s.publishMessage(privateSubjectPrefix+message.ReceiverID, messageJSON, func() {
+			s.deliverPrivate(message)
+		})
+	}
+
+	return s.publishMessage(groupSubjectPrefix+message.GroupID, messageJSON, func() {
+		s.deliverGroup(message)
+	})
+}
+
+// publishMessage 通过NATS发布消息；NATS不可用时直接投递给本节点的连接
+func (s *MessageService) publishMessage(subject string, messageJSON []byte, deliverLocally func()) error {
+	if s.natsDB == nil {
+		deliverLocally()
+		return nil
+	}
+	return s.natsDB.PublishMessage(subject, messageJSON)
+}
+
+// handlePrivateDelivery 处理NATS上的私聊消息
+func (s *MessageService) handlePrivateDelivery(msg *nats.Msg) {
+	var message models.Message
+	if err := json.Unmarshal(msg.Data, &message); err != nil {
+		log.Printf("解析NATS消息失败 (%s): %v", msg.Subject, err)
+		return
+	}
+	s.deliverPrivate(&message)
+}
+
+// deliverPrivate 将私聊消息推送给本节点上接收者的连接
+func (s *MessageService) deliverPrivate(message *models.Message) {
+	frame, err := websocket.NewFrame(websocket.FrameMessage, message)
+	if err != nil {
+		log.Printf("编码私聊消息帧失败: %v", err)
+		return
+	}
+	s.wsHub.SendToUser(message.ReceiverID, frame)
+}
+
+// handleGroupDelivery 处理NATS上的群组消息
+func (s *MessageService) handleGroupDelivery(msg *nats.Msg) {
+	var message models.Message
+	if err := json.Unmarshal(msg.Data, &message); err != nil {
+		log.Printf("解析NATS消息失败 (%s): %v", msg.Subject, err)
+		return
+	}
+	s.deliverGroup(&message)
+}
+
+// deliverGroup 将群组消息推送给本节点上除发送者外的所有在线成员
+func (s *MessageService) deliverGroup(message *models.Message) {
+	memberIDs, err := s.groupMemberIDs(message.GroupID)
+	if err != nil {
+		log.Printf("获取群组 %s 的成员失败: %v", message.GroupID, err)
+		return
+	}
+
+	frame, err := websocket.NewFrame(websocket.FrameMessage, message)
+	if err != nil {
+		log.Printf("编码群组消息帧失败: %v", err)
+		return
+	}
+
+	for _, memberID := range memberIDs {
+		if memberID == message.SenderID {
+			continue
+		}
+		s.wsHub.SendToUser(memberID, frame)
+	}
+}
+
+// groupMemberIDs 获取群组成员ID列表，用于投递和推送，优先读取Redis缓存，缓存未命中时查询数据库并回填
+// 查询期间成员发生变化时不回填，避免已移除的成员被写回缓存
+func (s *MessageService) groupMemberIDs(groupID string) ([]string, error) {
+	ctx := context.Background()
+
+	version := int64(-1)
+	if s.redisDB != nil {
+		memberIDs, err := s.redisDB.GetCachedGroupMembers(ctx, groupID)
+		if err != nil {
+			log.Printf("读取群组 %s 的成员缓存失败: %v", groupID, err)
+		} else if memberIDs != nil {
+			return memberIDs, nil
+		}
+
+		if version, err = s.redisDB.GroupMembersVersion(ctx, groupID); err != nil {
+			log.Printf("读取群组 %s 的成员缓存版本失败: %v", groupID, err)
+			version = -1
+		}
+	}
+
+	gid, err := strconv.Atoi(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := s.groupMemberRepo.GetMembers(gid)
+	if err != nil {
+		return nil, err
+	}
+
+	memberIDs := make([]string, 0, len(members))
+	for _, member := range members {
+		memberIDs = append(memberIDs, strconv.Itoa(member.ID))
+	}
+
+	if s.redisDB != nil && version >= 0 {
+		if err := s.redisDB.CacheGroupMembers(ctx, groupID, memberIDs, version, groupMembersCacheTTL); err != nil {
+			log.Printf("缓存群组 %s 的成员失败: %v", groupID, err)
+		}
+	}
+
+	return memberIDs, nil
+}
+
+// isGroupMember 检查用户是否为群组成员，用于发送和访问权限的检查，直接查询数据库而不使用成员缓存
+func (s *MessageService) isGroupMember(groupID, userID string) (bool, error) {
+	gid, err := strconv.Atoi(groupID)
+	if err != nil {
+		return false, err
+	}
+	uid, err := strconv.Atoi(userID)
+	if err != nil {
+		return false, err
+	}
+	return s.groupMemberRepo.IsMember(gid, uid)
+}
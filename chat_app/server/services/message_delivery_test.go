@@ -0,0 +1,182 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"chat_app/server/database"
+	"chat_app/server/models"
+	"chat_app/server/utils"
+	"chat_app/server/websocket"
+
+	ws "github.com/gorilla/websocket"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+)
+
+// fakeGroupMemberRepository 只实现成员查询的群组成员仓库，admins中的用户为群组管理员
+type fakeGroupMemberRepository struct {
+	models.GroupMemberRepository
+	members map[int][]*models.User
+	admins  map[int][]int
+}
+
+func (r *fakeGroupMemberRepository) GetMembers(groupID int) ([]*models.User, error) {
+	return r.members[groupID], nil
+}
+
+func (r *fakeGroupMemberRepository) IsMember(groupID, userID int) (bool, error) {
+	for _, member := range r.members[groupID] {
+		if member.ID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeGroupMemberRepository) IsAdmin(groupID, userID int) (bool, error) {
+	for _, adminID := range r.admins[groupID] {
+		if adminID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// testNode 一个连接到NATS的节点，包括hub、消息投递和WebSocket入口
+type testNode struct {
+	hub     *websocket.Hub
+	service *MessageService
+	server  *httptest.Server
+}
+
+func newTestNode(t *testing.T, natsURL string, members models.GroupMemberRepository) *testNode {
+	t.Helper()
+
+	conn, err := nats.Connect(natsURL)
+	if err != nil {
+		t.Fatalf("连接NATS失败: %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	hub := websocket.NewHub()
+	go hub.Run()
+
+	service := &MessageService{
+		groupMemberRepo: members,
+		natsDB:          &database.NATSDB{Conn: conn},
+		wsHub:           hub,
+	}
+	if err := service.StartDelivery(); err != nil {
+		t.Fatalf("订阅消息主题失败: %v", err)
+	}
+	t.Cleanup(service.StopDelivery)
+
+	// 确保订阅已到达服务器，之后其他节点发布的消息不会丢失
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("刷新NATS连接失败: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(websocket.NewHandler(hub, nil).HandleWebSocket))
+	t.Cleanup(server.Close)
+
+	return &testNode{hub: hub, service: service, server: server}
+}
+
+// dial 以用户身份连接节点的WebSocket入口，等待连接注册完成
+func (n *testNode) dial(t *testing.T, userID int) *ws.Conn {
+	t.Helper()
+
+	token, err := utils.GenerateToken(userID)
+	if err != nil {
+		t.Fatalf("生成令牌失败: %v", err)
+	}
+
+	url := "ws" + strings.TrimPrefix(n.server.URL, "http") + "?device_id=test&token=" + token
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("连接WebSocket失败: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(n.hub.Sessions(strconv.Itoa(userID))) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待用户 %d 的连接注册超时", userID)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return conn
+}
+
+// readMessage 读取下一帧并解析为聊天消息
+func readMessage(t *testing.T, conn *ws.Conn) *models.Message {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("读取WebSocket帧失败: %v", err)
+	}
+
+	var env websocket.Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatalf("解析帧失败: %v", err)
+	}
+	if env.Type != websocket.FrameMessage {
+		t.Fatalf("收到 %s 帧, 期望 %s", env.Type, websocket.FrameMessage)
+	}
+
+	var message models.Message
+	if err := env.DecodePayload(&message); err != nil {
+		t.Fatalf("解析消息负载失败: %v", err)
+	}
+	return &message
+}
+
+func TestDeliveryAcrossNodes(t *testing.T) {
+	server := natstest.RunRandClientPortServer()
+	defer server.Shutdown()
+
+	members := &fakeGroupMemberRepository{members: map[int][]*models.User{
+		7: {{ID: 1}, {ID: 2}},
+	}}
+	nodeA := newTestNode(t, server.ClientURL(), members)
+	nodeB := newTestNode(t, server.ClientURL(), members)
+
+	// 接收者只连接在节点A上，消息由节点B发布
+	conn := nodeA.dial(t, 2)
+
+	tests := []struct {
+		name    string
+		message *models.Message
+	}{
+		{
+			name:    "private",
+			message: &models.Message{SenderID: "1", ReceiverID: "2", Type: models.TextMessage, Content: "私聊消息"},
+		},
+		{
+			name:    "group",
+			message: &models.Message{SenderID: "1", GroupID: "7", Type: models.TextMessage, Content: "群组消息"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.message.ConversationID = tt.message.ConversationKey()
+			if err := nodeB.service.publish(tt.message); err != nil {
+				t.Fatalf("发布消息失败: %v", err)
+			}
+
+			got := readMessage(t, conn)
+			if got.Content != tt.message.Content || got.ConversationID != tt.message.ConversationID {
+				t.Errorf("收到 %q (%s), 期望 %q (%s)", got.Content, got.ConversationID, tt.message.Content, tt.message.ConversationID)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+)
+
+// MessageEditedEvent message_edited帧的负载
+type MessageEditedEvent struct {
+	MessageID      string    `json:"message_id"`
+	ConversationID string    `json:"conversation_id"`
+	Content        string    `json:"content"`
+	EditedAt       time.Time `json:"edited_at"`
+}
+
+// SetEditWindow 设置消息发送后允许编辑的时间
+func (s *MessageService) SetEditWindow(window time.Duration) {
+	s.editWindow = window
+}
+
+// EditMessage 修改消息内容，只有发送者可以在允许的时间内编辑文本消息
+// 旧内容保存在编辑历史中，并向会话的所有参与者推送message_edited帧
+func (s *MessageService) EditMessage(userID, messageID, content string) (*models.Message, error) {
+	if content == "" {
+		return nil, ErrEmptyMessage
+	}
+
+	message, err := s.getMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.SenderID != userID {
+		return nil, ErrNotMessageSender
+	}
+	if message.Recalled {
+		return nil, ErrMessageRecalled
+	}
+	if message.Type != models.TextMessage {
+		return nil, ErrMessageNotEditable
+	}
+	if time.Since(message.Timestamp) > s.editWindow {
+		return nil, ErrEditWindowExpired
+	}
+	if message.Content == content {
+		return message, nil
+	}
+
+	editedAt := time.Now()
+	updated, err := s.messageRepo.EditMessage(messageID, message.Content, content, editedAt)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, ErrEditConflict
+	}
+
+	message.Edits = append(message.Edits, models.MessageEdit{Content: message.Content, ReplacedAt: editedAt})
+	message.Content = content
+	message.EditedAt = &editedAt
+
+	conversationID := message.ConversationKey()
+	if err := s.conversationRepo.UpdateLastMessagePreview(conversationID, message.Preview()); err != nil {
+		log.Printf("更新会话 %s 的消息摘要失败: %v", conversationID, err)
+	}
+	s.indexMessage(message)
+
+	s.pushToParticipants(message, websocket.FrameMessageEdited, &MessageEditedEvent{
+		MessageID:      messageID,
+		ConversationID: conversationID,
+		Content:        content,
+		EditedAt:       editedAt,
+	})
+
+	return message, nil
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+
+	"chat_app/server/models"
+)
+
+const (
+	// 一次最多转发的消息数
+	maxForwardMessages = 100
+
+	// 一次最多转发到的会话数
+	maxForwardTargets = 9
+)
+
+// ForwardTarget 转发的目标会话，私聊指定receiver_id，群聊指定group_id
+type ForwardTarget struct {
+	ReceiverID string `json:"receiver_id,omitempty"`
+	GroupID    string `json:"group_id,omitempty"`
+}
+
+// ForwardRequest 转发请求
+type ForwardRequest struct {
+	MessageIDs []string        `json:"message_ids"`
+	Targets    []ForwardTarget `json:"targets"`
+
+	// 为true时将所有消息合并为一条chat_record消息，否则逐条转发
+	Merged bool `json:"merged,omitempty"`
+
+	// 合并转发的聊天记录标题，为空时根据原会话生成
+	Title string `json:"title,omitempty"`
+
+	// 客户端生成的请求ID，重试时携带相同的ID，已转发成功的消息不会重复发送
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+}
+
+// ForwardResult 转发到一个目标会话的结果，失败时Messages为已发送成功的部分
+type ForwardResult struct {
+	Target   ForwardTarget     `json:"target"`
+	Messages []*models.Message `json:"messages"`
+	Error    string            `json:"error,omitempty"`
+
+	// 转发失败的原因
+	Err error `json:"-"`
+}
+
+// ForwardMessages 将消息逐条或合并转发到一个或多个会话，按请求中的顺序返回每个目标会话的结果
+// 转发者必须能够访问所有原消息，已撤回、系统消息和转发者已删除的消息不能转发，这类错误使整个请求失败
+// 每条转发的消息都通过SendMessage发送，与直接发送的消息使用相同的校验和投递流程，
+// 一个目标会话发送失败时停止向该会话发送，不影响其他目标会话
+func (s *MessageService) ForwardMessages(userID string, req *ForwardRequest) ([]*ForwardResult, error) {
+	if len(req.MessageIDs) == 0 || len(req.MessageIDs) > maxForwardMessages {
+		return nil, ErrInvalidForward
+	}
+	if len(req.Targets) == 0 || len(req.Targets) > maxForwardTargets {
+		return nil, ErrInvalidForward
+	}
+	if len(req.ClientMsgID) > maxClientMsgIDLength {
+		return nil, ErrInvalidClientMsgID
+	}
+
+	sources, err := s.loadForwardSources(userID, req.MessageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var record *models.ChatRecord
+	if req.Merged {
+		record = s.buildChatRecord(userID, req.Title, sources)
+	}
+
+	results := make([]*ForwardResult, 0, len(req.Targets))
+	for _, target := range req.Targets {
+		result := &ForwardResult{Target: target, Messages: []*models.Message{}}
+		results = append(results, result)
+
+		if err := s.checkForwardTarget(userID, target); err != nil {
+			result.Err = err
+			result.Error = err.Error()
+			continue
+		}
+
+		var messages []*models.Message
+		if record != nil {
+			messages = []*models.Message{{
+				Type:       models.ChatRecordMessage,
+				Content:    "[聊天记录] " + record.Title,
+				ChatRecord: record,
+			}}
+		} else {
+			for _, source := range sources {
+				messages = append(messages, &models.Message{
+					Type:          source.Type,
+					Content:       source.Content,
+					MediaURL:      source.MediaURL,
+					Metadata:      source.Metadata,
+					ChatRecord:    source.ChatRecord,
+					ForwardedFrom: &models.ForwardInfo{MessageID: source.ID.Hex(), SenderID: source.SenderID},
+				})
+			}
+		}
+
+		for _, message := range messages {
+			message.SenderID = userID
+			message.ReceiverID = target.ReceiverID
+			message.GroupID = target.GroupID
+			if message.GroupID != "" {
+				message.ReceiverID = ""
+			}
+			if req.ClientMsgID != "" {
+				message.ClientMsgID = forwardClientMsgID(req.ClientMsgID, message)
+			}
+			if err := s.SendMessage(message); err != nil {
+				result.Err = err
+				result.Error = err.Error()
+				break
+			}
+			result.Messages = append(result.Messages, message)
+		}
+	}
+
+	return results, nil
+}
+
+// forwardClientMsgID 根据请求ID、目标会话和原消息生成转发消息的客户端消息ID
+// 重试时目标会话的顺序变化也生成相同的ID
+func forwardClientMsgID(requestID string, message *models.Message) string {
+	source := ""
+	if message.ForwardedFrom != nil {
+		source = message.ForwardedFrom.MessageID
+	}
+	sum := sha1.Sum([]byte(requestID + "|" + message.ConversationKey() + "|" + source))
+	return "forward_" + hex.EncodeToString(sum[:])
+}
+
+// loadForwardSources 加载并校验被转发的消息，按发送时间先后排列
+func (s *MessageService) loadForwardSources(userID string, messageIDs []string) ([]*models.Message, error) {
+	viewers := make(map[string]*models.MessageViewer)
+	seen := make(map[string]bool, len(messageIDs))
+	sources := make([]*models.Message, 0, len(messageIDs))
+
+	for _, messageID := range messageIDs {
+		if seen[messageID] {
+			continue
+		}
+		seen[messageID] = true
+
+		message, err := s.getMessage(messageID)
+		if err != nil {
+			return nil, err
+		}
+
+		conversationID := message.ConversationKey()
+		viewer, ok := viewers[conversationID]
+		if !ok {
+			if err := s.checkConversationAccess(userID, conversationID); err != nil {
+				return nil, err
+			}
+			viewer, err = s.messageViewer(userID, conversationID)
+			if err != nil {
+				return nil, err
+			}
+			viewers[conversationID] = viewer
+		}
+
+		if !viewer.CanSee(message) {
+			return nil, ErrMessageNotFound
+		}
+		if message.Recalled {
+			return nil, ErrMessageRecalled
+		}
+		if message.Type == models.SystemMessage {
+			return nil, ErrInvalidMessageType
+		}
+		sources = append(sources, message)
+	}
+
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].Timestamp.Before(sources[j].Timestamp)
+	})
+	return sources, nil
+}
+
+// checkForwardTarget 检查用户是否可以向目标会话发送消息
+func (s *MessageService) checkForwardTarget(userID string, target ForwardTarget) error {
+	if target.GroupID != "" {
+		isMember, err := s.isGroupMember(target.GroupID, userID)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return ErrNotGroupMember
+		}
+		return nil
+	}
+
+	if target.ReceiverID == "" || target.ReceiverID == userID {
+		return ErrMissingRecipient
+	}
+	return nil
+}
+
+// buildChatRecord 将消息合并为聊天记录，保存消息内容和媒体地址的副本
+func (s *MessageService) buildChatRecord(userID, title string, sources []*models.Message) *models.ChatRecord {
+	names := make(map[string]string)
+	senderName := func(senderID string) string {
+		name, ok := names[senderID]
+		if !ok {
+			name = s.displayName(senderID)
+			names[senderID] = name
+		}
+		return name
+	}
+
+	record := &models.ChatRecord{
+		Title: title,
+		Items: make([]*models.ChatRecordItem, 0, len(sources)),
+	}
+	for _, source := range sources {
+		record.Items = append(record.Items, &models.ChatRecordItem{
+			MessageID:  source.ID.Hex(),
+			SenderID:   source.SenderID,
+			SenderName: senderName(source.SenderID),
+			Type:       source.Type,
+			Content:    source.Content,
+			MediaURL:   source.MediaURL,
+			Metadata:   source.Metadata,
+			Timestamp:  source.Timestamp,
+			ChatRecord: source.ChatRecord,
+		})
+	}
+
+	if record.Title == "" {
+		record.Title = defaultChatRecordTitle(sources, senderName)
+	}
+	return record
+}
+
+// defaultChatRecordTitle 生成聊天记录的默认标题，私聊为“A和B的聊天记录”，群聊为“群聊的聊天记录”
+// 消息来自多个私聊会话时为“聊天记录”
+func defaultChatRecordTitle(sources []*models.Message, senderName func(string) string) string {
+	conversationID := sources[0].ConversationKey()
+	for _, source := range sources {
+		if source.GroupID != "" {
+			return "群聊的聊天记录"
+		}
+		if source.ConversationKey() != conversationID {
+			return "聊天记录"
+		}
+	}
+
+	ref, err := models.ParseConversationID(conversationID)
+	if err != nil {
+		return "聊天记录"
+	}
+	return senderName(ref.UserIDs[0]) + "和" + senderName(ref.UserIDs[1]) + "的聊天记录"
+}
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+)
+
+// SendMessagePayload send_message帧的负载，字段与POST /messages的请求一致
+type SendMessagePayload struct {
+	ReceiverID  string             `json:"receiver_id,omitempty"`
+	GroupID     string             `json:"group_id,omitempty"`
+	Type        models.MessageType `json:"type"`
+	Content     string             `json:"content"`
+	MediaURL    string             `json:"media_url,omitempty"`
+	ClientMsgID string             `json:"client_msg_id,omitempty"`
+	ReplyToID   string             `json:"reply_to_id,omitempty"`
+	ThreadID    string             `json:"thread_id,omitempty"`
+	Mentions    []string           `json:"mentions,omitempty"`
+	MentionAll  bool               `json:"mention_all,omitempty"`
+}
+
+// SendMessageAck send_message帧的ack负载
+type SendMessageAck struct {
+	MessageID      string               `json:"message_id"`
+	ClientMsgID    string               `json:"client_msg_id,omitempty"`
+	ConversationID string               `json:"conversation_id"`
+	Seq            int64                `json:"seq"`
+	Timestamp      time.Time            `json:"timestamp"`
+	Status         models.MessageStatus `json:"status"`
+}
+
+// TypingPayload typing帧的负载，私聊指定receiver_id，群聊指定group_id
+type TypingPayload struct {
+	ReceiverID string `json:"receiver_id,omitempty"`
+	GroupID    string `json:"group_id,omitempty"`
+
+	// 为false时表示停止输入，不携带时视为正在输入
+	IsTyping *bool `json:"is_typing,omitempty"`
+}
+
+// ReadReceiptPayload read_receipt帧的负载，会话由conversation_id、chat_id或sender_id之一指定
+type ReadReceiptPayload struct {
+	ConversationID string `json:"conversation_id,omitempty"`
+	ChatID         string `json:"chat_id,omitempty"`
+
+	// 私聊中已读消息的发送者，兼容旧客户端
+	SenderID string `json:"sender_id,omitempty"`
+
+	// 已读到的序列号，不携带时标记会话中的所有消息为已读
+	Seq int64 `json:"seq,omitempty"`
+}
+
+// SyncPayload sync帧的负载
+type SyncPayload struct {
+	ConversationID string `json:"conversation_id"`
+	SinceSeq       int64  `json:"since_seq"`
+	Limit          int    `json:"limit,omitempty"`
+}
+
+// DeliveryAckPayload delivery_ack帧的负载
+type DeliveryAckPayload struct {
+	MessageIDs []string `json:"message_ids"`
+}
+
+// RegisterFrameHandlers 注册由消息服务处理的WebSocket帧
+func (s *MessageService) RegisterFrameHandlers() {
+	s.wsHub.RegisterHandler(websocket.FrameSendMessage, s.handleSendMessageFrame)
+	s.wsHub.RegisterHandler(websocket.FrameTyping, s.handleTypingFrame)
+	s.wsHub.RegisterHandler(websocket.FrameReadReceipt, s.handleReadReceiptFrame)
+	s.wsHub.RegisterHandler(websocket.FrameSync, s.handleSyncFrame)
+	s.wsHub.RegisterHandler(websocket.FrameDeliveryAck, s.handleDeliveryAckFrame)
+	s.wsHub.RegisterHandler(websocket.FrameAddReaction, s.handleAddReactionFrame)
+	s.wsHub.RegisterHandler(websocket.FrameRemoveReaction, s.handleRemoveReactionFrame)
+}
+
+// handleSendMessageFrame 通过WebSocket发送消息，与POST /messages使用相同的校验和持久化流程
+// 无论客户端是否请求确认都会回复ack帧，携带服务端消息ID和时间戳
+func (s *MessageService) handleSendMessageFrame(client *websocket.Client, env *websocket.Envelope) (interface{}, error) {
+	var payload SendMessagePayload
+	if err := env.DecodePayload(&payload); err != nil {
+		return nil, err
+	}
+
+	message := &models.Message{
+		SenderID:    client.UserID(),
+		ReceiverID:  payload.ReceiverID,
+		GroupID:     payload.GroupID,
+		Type:        payload.Type,
+		Content:     payload.Content,
+		MediaURL:    payload.MediaURL,
+		ClientMsgID: payload.ClientMsgID,
+		ThreadID:    payload.ThreadID,
+		Mentions:    payload.Mentions,
+		MentionAll:  payload.MentionAll,
+	}
+	if payload.ReplyToID != "" {
+		message.ReplyTo = &models.MessagePreview{MessageID: payload.ReplyToID}
+	}
+	if err := s.SendMessage(message); err != nil {
+		return nil, frameError(err)
+	}
+
+	return &websocket.Reply{Type: websocket.FrameAck, Payload: &SendMessageAck{
+		MessageID:      message.ID.Hex(),
+		ClientMsgID:    message.ClientMsgID,
+		ConversationID: message.ConversationID,
+		Seq:            message.Seq,
+		Timestamp:      message.Timestamp,
+		Status:         message.Status,
+	}}, nil
+}
+
+// handleTypingFrame 将正在输入状态转发给私聊对方或群组成员
+func (s *MessageService) handleTypingFrame(client *websocket.Client, env *websocket.Envelope) (interface{}, error) {
+	var payload TypingPayload
+	if err := env.DecodePayload(&payload); err != nil {
+		return nil, err
+	}
+	if payload.ReceiverID == "" && payload.GroupID == "" {
+		return nil, websocket.NewProtocolError(websocket.ErrCodeInvalidPayload, "接收者ID或群组ID不能为空")
+	}
+
+	event := TypingEvent{
+		UserID:     client.UserID(),
+		ReceiverID: payload.ReceiverID,
+		GroupID:    payload.GroupID,
+	}
+
+	if payload.IsTyping != nil && !*payload.IsTyping {
+		if err := s.resolveTypingTarget(&event); err != nil {
+			return nil, frameError(err)
+		}
+		s.StopTyping(event.UserID, event.ConversationID)
+		return nil, nil
+	}
+
+	if err := s.StartTyping(event); err != nil {
+		return nil, frameError(err)
+	}
+	return nil, nil
+}
+
+// handleReadReceiptFrame 前移用户在会话中的已读位置，并通知消息发送者
+func (s *MessageService) handleReadReceiptFrame(client *websocket.Client, env *websocket.Envelope) (interface{}, error) {
+	var payload ReadReceiptPayload
+	if err := env.DecodePayload(&payload); err != nil {
+		return nil, err
+	}
+
+	conversationID := payload.ConversationID
+	switch {
+	case conversationID != "":
+	case payload.ChatID != "":
+		id, err := models.ConversationIDFromChatID(client.UserID(), payload.ChatID)
+		if err != nil {
+			return nil, frameError(err)
+		}
+		conversationID = id
+	case payload.SenderID != "":
+		conversationID = models.PrivateConversationID(client.UserID(), payload.SenderID)
+	default:
+		return nil, websocket.NewProtocolError(websocket.ErrCodeInvalidPayload, "会话ID不能为空")
+	}
+
+	receipt, err := s.MarkConversationRead(client.UserID(), conversationID, payload.Seq)
+	if err != nil {
+		return nil, frameError(err)
+	}
+	return receipt, nil
+}
+
+// handleSyncFrame 以sync帧返回会话中客户端缺失的消息，代替ack帧
+func (s *MessageService) handleSyncFrame(client *websocket.Client, env *websocket.Envelope) (interface{}, error) {
+	var payload SyncPayload
+	if err := env.DecodePayload(&payload); err != nil {
+		return nil, err
+	}
+	if payload.ConversationID == "" {
+		return nil, websocket.NewProtocolError(websocket.ErrCodeInvalidPayload, "会话ID不能为空")
+	}
+
+	result, err := s.SyncMessages(client.UserID(), payload.ConversationID, payload.SinceSeq, payload.Limit)
+	if err != nil {
+		return nil, frameError(err)
+	}
+
+	return &websocket.Reply{Type: websocket.FrameSync, Payload: result}, nil
+}
+
+// handleDeliveryAckFrame 处理接收者设备收到消息推送后的确认
+func (s *MessageService) handleDeliveryAckFrame(client *websocket.Client, env *websocket.Envelope) (interface{}, error) {
+	var payload DeliveryAckPayload
+	if err := env.DecodePayload(&payload); err != nil {
+		return nil, err
+	}
+	if len(payload.MessageIDs) == 0 {
+		return nil, websocket.NewProtocolError(websocket.ErrCodeInvalidPayload, "消息ID列表不能为空")
+	}
+
+	acked, err := s.AckDelivery(client.UserID(), payload.MessageIDs)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"message_ids": acked}, nil
+}
+
+// handleAddReactionFrame 为消息添加表情回应，ack负载为更新后的回应
+func (s *MessageService) handleAddReactionFrame(client *websocket.Client, env *websocket.Envelope) (interface{}, error) {
+	var payload ReactionPayload
+	if err := env.DecodePayload(&payload); err != nil {
+		return nil, err
+	}
+
+	event, err := s.AddReaction(client.UserID(), payload.MessageID, payload.Emoji)
+	if err != nil {
+		return nil, frameError(err)
+	}
+	return event, nil
+}
+
+// handleRemoveReactionFrame 移除对消息的表情回应，ack负载为更新后的回应
+func (s *MessageService) handleRemoveReactionFrame(client *websocket.Client, env *websocket.Envelope) (interface{}, error) {
+	var payload ReactionPayload
+	if err := env.DecodePayload(&payload); err != nil {
+		return nil, err
+	}
+
+	event, err := s.RemoveReaction(client.UserID(), payload.MessageID, payload.Emoji)
+	if err != nil {
+		return nil, frameError(err)
+	}
+	return event, nil
+}
+
+// frameError 将服务错误转换为带错误码的协议错误，未知错误原样返回
+func frameError(err error) error {
+	switch err {
+	case ErrNotParticipant, ErrNotGroupMember, ErrNotMessageSender, ErrMentionAllForbidden:
+		return websocket.NewProtocolError(websocket.ErrCodeForbidden, err.Error())
+	case models.ErrInvalidConversationID, ErrInvalidClientMsgID, ErrMissingRecipient, ErrEmptyMessage,
+		ErrInvalidMessageType, ErrInvalidReply, ErrThreadNotSupported, ErrMessageNotFound, ErrMessageRecalled,
+		ErrInvalidReaction, ErrTooManyReactions, ErrMentionNotSupported, ErrInvalidMediaURL:
+		return websocket.NewProtocolError(websocket.ErrCodeInvalidPayload, err.Error())
+	}
+	return err
+}
+
+// pushFrame 向用户的所有连接推送一帧，用户可能连接在其他节点
+func (s *MessageService) pushFrame(userID, frameType string, payload interface{}) {
+	if err := s.push.PushFrame(userID, frameType, payload); err != nil {
+		log.Printf("向用户 %s 推送 %s 帧失败: %v", userID, frameType, err)
+	}
+}
+
+// pushToParticipants 向消息所属会话的所有参与者推送一帧，包括操作者自己的其他设备
+func (s *MessageService) pushToParticipants(message *models.Message, frameType string, payload interface{}) {
+	if message.GroupID == "" {
+		s.pushFrame(message.SenderID, frameType, payload)
+		s.pushFrame(message.ReceiverID, frameType, payload)
+		return
+	}
+
+	memberIDs, err := s.groupMemberIDs(message.GroupID)
+	if err != nil {
+		log.Printf("获取群组 %s 的成员失败: %v", message.GroupID, err)
+		return
+	}
+	for _, memberID := range memberIDs {
+		s.pushFrame(memberID, frameType, payload)
+	}
+}
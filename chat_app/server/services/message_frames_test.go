@@ -0,0 +1,75 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"chat_app/server/websocket"
+
+	ws "github.com/gorilla/websocket"
+)
+
+// repliesByID 读取一段时间内收到的帧，按帧ID分组
+func repliesByID(t *testing.T, conn *ws.Conn, wait time.Duration) map[string][]*websocket.Envelope {
+	t.Helper()
+
+	replies := make(map[string][]*websocket.Envelope)
+	conn.SetReadDeadline(time.Now().Add(wait))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return replies
+		}
+		var env websocket.Envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			t.Fatalf("解析帧失败: %v", err)
+		}
+		if env.ID != "" {
+			replies[env.ID] = append(replies[env.ID], &env)
+		}
+	}
+}
+
+func TestFrameHandlersReplyOnce(t *testing.T) {
+	service, _ := newTestMessageService(t)
+	service.RegisterFrameHandlers()
+
+	server := httptest.NewServer(http.HandlerFunc(websocket.NewHandler(service.wsHub, nil).HandleWebSocket))
+	t.Cleanup(server.Close)
+	node := &testNode{hub: service.wsHub, service: service, server: server}
+	conn := node.dial(t, 1)
+
+	frames := []struct {
+		id    string
+		frame string
+		want  string
+	}{
+		{id: "send", frame: `{"v":1,"type":"send_message","id":"send","ack":true,"payload":{"receiver_id":"2","content":"你好"}}`, want: websocket.FrameAck},
+		{id: "send-no-ack", frame: `{"v":1,"type":"send_message","id":"send-no-ack","payload":{"receiver_id":"2","content":"你好"}}`, want: websocket.FrameAck},
+		{id: "sync", frame: `{"v":1,"type":"sync","id":"sync","ack":true,"payload":{"conversation_id":"p_1_2"}}`, want: websocket.FrameSync},
+		{id: "ping", frame: `{"v":1,"type":"ping","id":"ping","ack":true}`, want: websocket.FramePong},
+	}
+	for _, f := range frames {
+		if err := conn.WriteMessage(ws.TextMessage, []byte(f.frame)); err != nil {
+			t.Fatalf("发送 %s 帧失败: %v", f.id, err)
+		}
+	}
+
+	replies := repliesByID(t, conn, 500*time.Millisecond)
+	for _, f := range frames {
+		got := replies[f.id]
+		if len(got) != 1 {
+			t.Errorf("帧 %s 收到 %d 个回复, 期望 1 个", f.id, len(got))
+			continue
+		}
+		if got[0].Type != f.want {
+			t.Errorf("帧 %s 的回复为 %s, 期望 %s", f.id, got[0].Type, f.want)
+		}
+		if len(got[0].Payload) == 0 || string(got[0].Payload) == "null" {
+			t.Errorf("帧 %s 的回复没有负载", f.id)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"chat_app/server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// 游标分页默认每页返回的消息数
+	defaultHistoryLimit = 20
+
+	// 游标分页每页最多返回的消息数
+	maxHistoryLimit = 100
+)
+
+// HistoryQuery 游标分页查询参数，Before、After和Around最多指定一个，都不指定时返回最新的消息
+// 游标可以是消息ID，也可以是 <毫秒时间戳>_<消息ID>
+type HistoryQuery struct {
+	// 返回早于该位置的消息
+	Before string
+
+	// 返回晚于该位置的消息
+	After string
+
+	// 返回该消息及其前后的消息，用于跳转到指定消息
+	Around string
+
+	Limit int
+}
+
+// MessagePage 游标分页的一页消息，按时间升序排列
+type MessagePage struct {
+	ConversationID string            `json:"conversation_id"`
+	Messages       []*models.Message `json:"messages"`
+
+	// 第一条消息之前是否还有更早的消息，继续翻页时以第一条消息的ID作为before
+	HasMoreBefore bool `json:"has_more_before"`
+
+	// 最后一条消息之后是否还有更新的消息，继续翻页时以最后一条消息的ID作为after
+	HasMoreAfter bool `json:"has_more_after"`
+}
+
+// GetConversationHistory 使用游标分页获取会话的消息历史
+// 与偏移分页不同，翻页期间到达的新消息不会导致消息被跳过或重复
+func (s *MessageService) GetConversationHistory(userID, conversationID string, query HistoryQuery) (*MessagePage, error) {
+	if err := s.checkConversationAccess(userID, conversationID); err != nil {
+		return nil, err
+	}
+
+	specified := 0
+	for _, value := range []string{query.Before, query.After, query.Around} {
+		if value != "" {
+			specified++
+		}
+	}
+	if specified > 1 {
+		return nil, ErrInvalidCursor
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	viewer, err := s.messageViewer(userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &MessagePage{ConversationID: conversationID}
+
+	switch {
+	case query.Around != "":
+		err = s.historyAround(page, viewer, query.Around, limit)
+
+	case query.After != "":
+		var cursor *models.MessageCursor
+		cursor, err = s.resolveMessageCursor(conversationID, query.After)
+		if err != nil {
+			return nil, err
+		}
+		page.Messages, page.HasMoreAfter, err = s.messagesAfter(conversationID, cursor, viewer, limit)
+		page.HasMoreBefore = true
+
+	default:
+		var cursor *models.MessageCursor
+		if query.Before != "" {
+			cursor, err = s.resolveMessageCursor(conversationID, query.Before)
+			if err != nil {
+				return nil, err
+			}
+			page.HasMoreAfter = true
+		}
+		page.Messages, page.HasMoreBefore, err = s.messagesBefore(conversationID, cursor, viewer, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if page.Messages == nil {
+		page.Messages = []*models.Message{}
+	}
+	summarizeReactions(page.Messages, userID)
+	return page, nil
+}
+
+// historyAround 返回指定消息前后的消息，目标消息位于窗口中间
+func (s *MessageService) historyAround(page *MessagePage, viewer *models.MessageViewer, messageID string, limit int) error {
+	target, err := s.getMessage(messageID)
+	if err != nil {
+		return err
+	}
+	if target.ConversationKey() != page.ConversationID || !viewer.CanSee(target) {
+		return ErrMessageNotFound
+	}
+
+	beforeCount := (limit - 1) / 2
+	afterCount := limit - 1 - beforeCount
+
+	var before, after []*models.Message
+	if beforeCount > 0 {
+		before, page.HasMoreBefore, err = s.messagesBefore(page.ConversationID, target.Cursor(), viewer, beforeCount)
+		if err != nil {
+			return err
+		}
+	}
+	if afterCount > 0 {
+		after, page.HasMoreAfter, err = s.messagesAfter(page.ConversationID, target.Cursor(), viewer, afterCount)
+		if err != nil {
+			return err
+		}
+	}
+
+	page.Messages = make([]*models.Message, 0, len(before)+1+len(after))
+	page.Messages = append(page.Messages, before...)
+	page.Messages = append(page.Messages, target)
+	page.Messages = append(page.Messages, after...)
+	return nil
+}
+
+// messagesBefore 获取早于cursor的limit条消息，并返回是否还有更早的消息
+func (s *MessageService) messagesBefore(conversationID string, cursor *models.MessageCursor, viewer *models.MessageViewer, limit int) ([]*models.Message, bool, error) {
+	messages, err := s.messageRepo.GetMessagesBefore(conversationID, cursor, viewer, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(messages) > limit {
+		return messages[len(messages)-limit:], true, nil
+	}
+	return messages, false, nil
+}
+
+// messagesAfter 获取晚于cursor的limit条消息，并返回是否还有更新的消息
+func (s *MessageService) messagesAfter(conversationID string, cursor *models.MessageCursor, viewer *models.MessageViewer, limit int) ([]*models.Message, bool, error) {
+	messages, err := s.messageRepo.GetMessagesAfter(conversationID, cursor, viewer, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(messages) > limit {
+		return messages[:limit], true, nil
+	}
+	return messages, false, nil
+}
+
+// resolveMessageCursor 解析游标，游标为消息ID时该消息必须属于会话
+func (s *MessageService) resolveMessageCursor(conversationID, value string) (*models.MessageCursor, error) {
+	if primitive.IsValidObjectID(value) {
+		message, err := s.getMessage(value)
+		if err != nil {
+			return nil, err
+		}
+		if message.ConversationKey() != conversationID {
+			return nil, ErrMessageNotFound
+		}
+		return message.Cursor(), nil
+	}
+
+	return decodeMessageCursor(value)
+}
+
+// encodeMessageCursor 将分页位置编码为 <毫秒时间戳>_<消息ID> 格式的游标
+func encodeMessageCursor(cursor *models.MessageCursor) string {
+	return strconv.FormatInt(cursor.Timestamp.UnixMilli(), 10) + "_" + cursor.ID.Hex()
+}
+
+// decodeMessageCursor 解析 <毫秒时间戳>_<消息ID> 格式的游标
+func decodeMessageCursor(value string) (*models.MessageCursor, error) {
+	parts := strings.SplitN(value, "_", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	millis, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	id, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &models.MessageCursor{Timestamp: time.UnixMilli(millis), ID: id}, nil
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"chat_app/server/models"
+)
+
+// 上传的媒体文件的访问地址前缀，地址格式为 /api/media/<类型>/<文件名>
+const mediaURLPrefix = "/api/media/"
+
+// SetMediaDir 设置上传的媒体文件所在的目录，必须在开始处理请求之前调用
+// 未设置时不检查媒体文件的上传者，撤回消息也不删除媒体文件
+func (s *MessageService) SetMediaDir(dir string) {
+	s.mediaDir = dir
+}
+
+// checkMediaOwner 检查消息引用的本服务媒体文件是否由发送者上传，其他地址的媒体不检查
+// 上传的文件保存在 <类型>/user_<上传者ID>/ 目录下
+func (s *MessageService) checkMediaOwner(message *models.Message) error {
+	if s.mediaDir == "" || !strings.HasPrefix(message.MediaURL, mediaURLPrefix) {
+		return nil
+	}
+
+	path, ok := s.uploadedMediaPath(message.MediaURL, message.SenderID)
+	if !ok {
+		return ErrInvalidMediaURL
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrInvalidMediaURL
+		}
+		return err
+	}
+	return nil
+}
+
+// removeUnreferencedMedia 删除撤回的消息的发送者上传的、不再被任何消息或定时消息引用的媒体文件，失败时只记录日志
+// 逐条转发和合并转发的消息保存了同一个媒体地址，仍被引用的文件保留，其他用户上传的文件不会被删除
+func (s *MessageService) removeUnreferencedMedia(senderID string, mediaURLs []string) {
+	if s.mediaDir == "" {
+		return
+	}
+
+	for _, mediaURL := range mediaURLs {
+		path, ok := s.uploadedMediaPath(mediaURL, senderID)
+		if !ok {
+			continue
+		}
+
+		referenced, err := s.messageRepo.IsMediaReferenced(mediaURL)
+		if err == nil && !referenced && s.scheduledRepo != nil {
+			referenced, err = s.scheduledRepo.IsMediaReferenced(mediaURL)
+		}
+		if err != nil {
+			log.Printf("检查媒体 %s 的引用失败: %v", mediaURL, err)
+			continue
+		}
+		if referenced {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("删除媒体文件 %s 失败: %v", path, err)
+		}
+	}
+}
+
+// uploadedMediaPath 返回用户上传的媒体地址对应的文件路径，不是本服务上传的地址返回false
+func (s *MessageService) uploadedMediaPath(mediaURL, uploaderID string) (string, bool) {
+	rest := strings.TrimPrefix(mediaURL, mediaURLPrefix)
+	if rest == mediaURL {
+		return "", false
+	}
+	mediaType, fileName, ok := strings.Cut(rest, "/")
+	if !ok || !validPathElement(mediaType) || !validPathElement(fileName) || !validPathElement(uploaderID) {
+		return "", false
+	}
+
+	return filepath.Join(s.mediaDir, mediaType, "user_"+uploaderID, fileName), true
+}
+
+// validPathElement 检查字符串是否可以作为单独一级路径使用
+func validPathElement(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
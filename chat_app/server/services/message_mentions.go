@@ -0,0 +1,195 @@
+package services
+
+import (
+	"log"
+	"strconv"
+
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+)
+
+// MentionEvent mentioned帧的负载，推送给被@的成员
+type MentionEvent struct {
+	MessageID      string                 `json:"message_id"`
+	ConversationID string                 `json:"conversation_id"`
+	GroupID        string                 `json:"group_id"`
+	SenderID       string                 `json:"sender_id"`
+	MentionAll     bool                   `json:"mention_all"`
+	Message        *models.MessagePreview `json:"message"`
+}
+
+// SetNotificationService 设置用于发送@通知的推送服务，必须在开始处理请求之前调用
+// 未设置时被@的成员只收到WebSocket推送
+func (s *MessageService) SetNotificationService(notificationService *NotificationService) {
+	s.notificationService = notificationService
+}
+
+// resolveMentions 校验消息中的@，去除重复、发送者自己和不在群组中的成员
+func (s *MessageService) resolveMentions(message *models.Message) error {
+	if len(message.Mentions) == 0 && !message.MentionAll {
+		return nil
+	}
+	if message.GroupID == "" {
+		return ErrMentionNotSupported
+	}
+
+	if message.MentionAll {
+		isAdmin, err := s.isGroupAdmin(message.GroupID, message.SenderID)
+		if err != nil {
+			return err
+		}
+		if !isAdmin {
+			return ErrMentionAllForbidden
+		}
+		message.Mentions = nil
+		return nil
+	}
+
+	memberIDs, err := s.groupMemberIDs(message.GroupID)
+	if err != nil {
+		return err
+	}
+	isMember := make(map[string]bool, len(memberIDs))
+	for _, memberID := range memberIDs {
+		isMember[memberID] = true
+	}
+
+	mentions := make([]string, 0, len(message.Mentions))
+	seen := make(map[string]bool, len(message.Mentions))
+	for _, userID := range message.Mentions {
+		if seen[userID] || userID == message.SenderID || !isMember[userID] {
+			continue
+		}
+		seen[userID] = true
+		mentions = append(mentions, userID)
+	}
+	if len(mentions) == 0 {
+		mentions = nil
+	}
+	message.Mentions = mentions
+	return nil
+}
+
+// notifyMentions 向被@的成员推送mentioned帧和推送通知，不受会话免打扰设置的影响
+func (s *MessageService) notifyMentions(message *models.Message) {
+	targets := message.Mentions
+	if message.MentionAll {
+		memberIDs, err := s.groupMemberIDs(message.GroupID)
+		if err != nil {
+			log.Printf("获取群组 %s 的成员失败: %v", message.GroupID, err)
+			return
+		}
+		targets = memberIDs
+	}
+
+	event := &MentionEvent{
+		MessageID:      message.ID.Hex(),
+		ConversationID: message.ConversationID,
+		GroupID:        message.GroupID,
+		SenderID:       message.SenderID,
+		MentionAll:     message.MentionAll,
+		Message:        message.Preview(),
+	}
+
+	recipients := make([]string, 0, len(targets))
+	for _, userID := range targets {
+		if userID == message.SenderID {
+			continue
+		}
+		recipients = append(recipients, userID)
+		s.pushFrame(userID, websocket.FrameMentioned, event)
+	}
+
+	if s.notificationService != nil && len(recipients) > 0 {
+		go s.sendMentionNotifications(message, recipients)
+	}
+}
+
+// sendMentionNotifications 向被@的成员发送推送通知，没有注册推送令牌的成员会被跳过
+func (s *MessageService) sendMentionNotifications(message *models.Message, recipients []string) {
+	senderName := s.displayName(message.SenderID)
+	preview := message.Preview().Content
+
+	for _, userID := range recipients {
+		err := s.notificationService.SendMentionNotification(userID, message.SenderID, senderName, message.GroupID, message.ID.Hex(), preview)
+		if err != nil {
+			log.Printf("向用户 %s 发送@通知失败: %v", userID, err)
+		}
+	}
+}
+
+// MentionPage @我的消息的一页，按时间倒序排列
+type MentionPage struct {
+	Messages []*models.Message `json:"messages"`
+
+	// 下一页的游标，没有更多消息时为空
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ListMentions 返回用户所在群组中@了用户的消息，包括@所有人，按时间倒序排列
+// before为上一页返回的游标，为空时从最新的消息开始
+func (s *ConversationService) ListMentions(userID, before string, limit int) (*MentionPage, error) {
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	var cursor *models.MessageCursor
+	if before != "" {
+		var err error
+		cursor, err = decodeMessageCursor(before)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	uid, err := strconv.Atoi(userID)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.groupRepo.GetGroupsByMember(uid)
+	if err != nil {
+		return nil, err
+	}
+	page := &MentionPage{Messages: []*models.Message{}}
+	if len(groups) == 0 {
+		return page, nil
+	}
+	groupIDs := make([]string, 0, len(groups))
+	for _, group := range groups {
+		groupIDs = append(groupIDs, strconv.Itoa(group.ID))
+	}
+
+	// 多取一条用于判断是否还有下一页
+	messages, err := s.messageRepo.GetMentions(userID, groupIDs, cursor, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		page.NextCursor = encodeMessageCursor(messages[limit-1].Cursor())
+	}
+
+	// 过滤用户清空会话之前的消息
+	settingsList, err := s.conversationRepo.GetSettingsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	clearedAt := make(map[string]*models.MessageViewer, len(settingsList))
+	for _, settings := range settingsList {
+		if settings.ClearedAt != nil {
+			clearedAt[settings.ConversationID] = &models.MessageViewer{UserID: userID, ClearedAt: settings.ClearedAt}
+		}
+	}
+	for _, message := range messages {
+		if viewer := clearedAt[message.ConversationKey()]; viewer != nil && !viewer.CanSee(message) {
+			continue
+		}
+		message.SummarizeReactions(userID)
+		page.Messages = append(page.Messages, message)
+	}
+
+	return page, nil
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+)
+
+// 每个会话最多置顶的消息数
+const maxPinnedMessages = 10
+
+// MessagePinnedEvent message_pinned和message_unpinned帧的负载
+type MessagePinnedEvent struct {
+	MessageID      string                 `json:"message_id"`
+	ConversationID string                 `json:"conversation_id"`
+	OperatorID     string                 `json:"operator_id"`
+	PinnedAt       *time.Time             `json:"pinned_at,omitempty"`
+	Message        *models.MessagePreview `json:"message,omitempty"`
+}
+
+// PinMessage 置顶消息，群组中只有管理员可以置顶，私聊的双方都可以置顶
+// 置顶后向会话推送message_pinned帧和一条系统消息，重复置顶不做任何操作
+func (s *MessageService) PinMessage(userID, messageID string) (*models.Message, error) {
+	message, err := s.getMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkPinPermission(message, userID); err != nil {
+		return nil, err
+	}
+	if message.Recalled {
+		return nil, ErrMessageRecalled
+	}
+	if message.Type == models.SystemMessage {
+		return nil, ErrInvalidMessageType
+	}
+	if message.PinnedAt != nil {
+		return message, nil
+	}
+
+	conversationID := message.ConversationKey()
+	pinnedAt := time.Now()
+	updated, err := s.messageRepo.PinMessage(messageID, conversationID, userID, pinnedAt, maxPinnedMessages)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		// 消息在此期间被撤回或被其他人置顶，否则会话中的置顶消息数已达上限
+		current, err := s.getPinnedState(messageID)
+		if err != nil {
+			return nil, err
+		}
+		if current.PinnedAt == nil {
+			return nil, ErrTooManyPins
+		}
+		return current, nil
+	}
+
+	message.PinnedBy = userID
+	message.PinnedAt = &pinnedAt
+
+	s.pushToParticipants(message, websocket.FrameMessagePinned, &MessagePinnedEvent{
+		MessageID:      messageID,
+		ConversationID: conversationID,
+		OperatorID:     userID,
+		PinnedAt:       &pinnedAt,
+		Message:        message.Preview(),
+	})
+
+	s.sendPinNotice(message, userID)
+	return message, nil
+}
+
+// UnpinMessage 取消置顶消息，权限与置顶相同，消息没有被置顶时不做任何操作
+func (s *MessageService) UnpinMessage(userID, messageID string) (*models.Message, error) {
+	message, err := s.getMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkPinPermission(message, userID); err != nil {
+		return nil, err
+	}
+	if message.PinnedAt == nil {
+		return message, nil
+	}
+
+	updated, err := s.messageRepo.UnpinMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	message.PinnedBy = ""
+	message.PinnedAt = nil
+	if !updated {
+		return message, nil
+	}
+
+	s.pushToParticipants(message, websocket.FrameMessageUnpinned, &MessagePinnedEvent{
+		MessageID:      messageID,
+		ConversationID: message.ConversationKey(),
+		OperatorID:     userID,
+	})
+	return message, nil
+}
+
+// GetPinnedMessages 获取会话中的置顶消息，最近置顶的排在前面
+func (s *MessageService) GetPinnedMessages(userID, conversationID string) ([]*models.Message, error) {
+	if err := s.checkConversationAccess(userID, conversationID); err != nil {
+		return nil, err
+	}
+	viewer, err := s.messageViewer(userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := s.messageRepo.GetPinnedMessages(conversationID, viewer, maxPinnedMessages)
+	if err != nil {
+		return nil, err
+	}
+	if messages == nil {
+		messages = []*models.Message{}
+	}
+	summarizeReactions(messages, userID)
+	return messages, nil
+}
+
+// checkPinPermission 检查用户是否可以置顶或取消置顶消息
+func (s *MessageService) checkPinPermission(message *models.Message, userID string) error {
+	if message.GroupID == "" {
+		return s.checkConversationAccess(userID, message.ConversationKey())
+	}
+
+	isAdmin, err := s.isGroupAdmin(message.GroupID, userID)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return ErrPinForbidden
+	}
+	return nil
+}
+
+// getPinnedState 重新读取置顶失败的消息，消息已被撤回时返回ErrMessageRecalled
+func (s *MessageService) getPinnedState(messageID string) (*models.Message, error) {
+	message, err := s.getMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.Recalled {
+		return nil, ErrMessageRecalled
+	}
+	return message, nil
+}
+
+// sendPinNotice 在会话中发送一条“某人置顶了一条消息”的系统消息
+func (s *MessageService) sendPinNotice(message *models.Message, operatorID string) {
+	notice := &models.Message{
+		SenderID:   operatorID,
+		ReceiverID: message.ReceiverID,
+		GroupID:    message.GroupID,
+		Type:       models.SystemMessage,
+		Content:    s.displayName(operatorID) + " 置顶了一条消息",
+		Metadata: map[string]interface{}{
+			"event":      "message_pinned",
+			"message_id": message.ID.Hex(),
+		},
+	}
+	// 私聊中由对方接收通知
+	if message.GroupID == "" && operatorID == message.ReceiverID {
+		notice.ReceiverID = message.SenderID
+	}
+	if err := s.storeAndPublish(notice); err != nil {
+		log.Printf("发送置顶通知失败: %v", err)
+	}
+}
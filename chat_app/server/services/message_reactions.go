@@ -0,0 +1,180 @@
+package services
+
+import (
+	"unicode/utf8"
+
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+)
+
+const (
+	// 表情的最大字节数，组合表情由多个码点组成
+	maxReactionLength = 32
+
+	// 每条消息最多的表情种类数，已有的表情仍可以继续回应
+	maxReactionKinds = 20
+)
+
+// emojiRanges 可以作为表情基础字符的码点范围
+var emojiRanges = [][2]rune{
+	{0x00A9, 0x00A9}, {0x00AE, 0x00AE}, {0x203C, 0x203C}, {0x2049, 0x2049},
+	{0x2122, 0x2122}, {0x2139, 0x2139}, {0x2194, 0x2199}, {0x21A9, 0x21AA},
+	{0x231A, 0x231B}, {0x2328, 0x2328}, {0x23CF, 0x23CF}, {0x23E9, 0x23F3},
+	{0x23F8, 0x23FA}, {0x24C2, 0x24C2}, {0x25AA, 0x25AB}, {0x25B6, 0x25B6},
+	{0x25C0, 0x25C0}, {0x25FB, 0x25FE}, {0x2600, 0x27BF}, {0x2934, 0x2935},
+	{0x2B05, 0x2B07}, {0x2B1B, 0x2B1C}, {0x2B50, 0x2B50}, {0x2B55, 0x2B55},
+	{0x3030, 0x3030}, {0x303D, 0x303D}, {0x3297, 0x3297}, {0x3299, 0x3299},
+	{0x1F000, 0x1FAFF},
+}
+
+// 组合表情中使用的修饰字符
+const (
+	zeroWidthJoiner   = 0x200D
+	textPresentation  = 0xFE0E
+	emojiPresentation = 0xFE0F
+	combiningKeycap   = 0x20E3
+	skinToneFirst     = 0x1F3FB
+	skinToneLast      = 0x1F3FF
+	tagFirst          = 0xE0020
+	tagLast           = 0xE007F
+)
+
+// ReactionUpdatedEvent reaction_updated帧的负载
+type ReactionUpdatedEvent struct {
+	MessageID      string `json:"message_id"`
+	ConversationID string `json:"conversation_id"`
+	Emoji          string `json:"emoji"`
+
+	// 本次添加或移除回应的用户
+	UserID string `json:"user_id"`
+	Added  bool   `json:"added"`
+
+	// 该表情当前的回应人数和回应者
+	Count   int      `json:"count"`
+	UserIDs []string `json:"user_ids"`
+}
+
+// ReactionPayload add_reaction和remove_reaction帧的负载
+type ReactionPayload struct {
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// AddReaction 为消息添加表情回应，并向会话参与者推送reaction_updated帧
+func (s *MessageService) AddReaction(userID, messageID, emoji string) (*ReactionUpdatedEvent, error) {
+	return s.updateReaction(userID, messageID, emoji, true)
+}
+
+// RemoveReaction 移除用户对消息的表情回应，并向会话参与者推送reaction_updated帧
+func (s *MessageService) RemoveReaction(userID, messageID, emoji string) (*ReactionUpdatedEvent, error) {
+	return s.updateReaction(userID, messageID, emoji, false)
+}
+
+// updateReaction 校验权限后添加或移除表情回应
+func (s *MessageService) updateReaction(userID, messageID, emoji string, add bool) (*ReactionUpdatedEvent, error) {
+	if !validReaction(emoji) {
+		return nil, ErrInvalidReaction
+	}
+
+	message, err := s.getMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	conversationID := message.ConversationKey()
+	if err := s.checkConversationAccess(userID, conversationID); err != nil {
+		return nil, err
+	}
+	if message.Recalled {
+		return nil, ErrMessageRecalled
+	}
+
+	var updated *models.Message
+	if add {
+		updated, err = s.messageRepo.AddReaction(messageID, emoji, userID, maxReactionKinds)
+	} else {
+		updated, err = s.messageRepo.RemoveReaction(messageID, emoji, userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if updated == nil {
+		// 消息在校验之后被撤回，或表情种类已达上限
+		current, err := s.getMessage(messageID)
+		if err != nil {
+			return nil, err
+		}
+		if current.Recalled || !add {
+			return nil, ErrMessageRecalled
+		}
+		return nil, ErrTooManyReactions
+	}
+
+	event := &ReactionUpdatedEvent{
+		MessageID:      messageID,
+		ConversationID: conversationID,
+		Emoji:          emoji,
+		UserID:         userID,
+		Added:          add,
+		UserIDs:        updated.Reactions[emoji],
+	}
+	if event.UserIDs == nil {
+		event.UserIDs = []string{}
+	}
+	event.Count = len(event.UserIDs)
+
+	s.pushToParticipants(message, websocket.FrameReactionUpdated, event)
+	return event, nil
+}
+
+// validReaction 检查回应是否为表情，每个码点都必须是表情字符或组合表情使用的修饰字符
+// 数字、#和*只能出现在键帽表情中，只由修饰字符组成的回应无效
+func validReaction(emoji string) bool {
+	if emoji == "" || len(emoji) > maxReactionLength || !utf8.ValidString(emoji) {
+		return false
+	}
+
+	runes := []rune(emoji)
+	hasBase := false
+	for i, r := range runes {
+		switch {
+		case r == zeroWidthJoiner, r == textPresentation, r == emojiPresentation, r == combiningKeycap,
+			r >= skinToneFirst && r <= skinToneLast, r >= tagFirst && r <= tagLast:
+			// 肤色修饰符也在表情字符范围内，需要先于表情字符判断
+		case isEmojiRune(r):
+			hasBase = true
+		case r == '#' || r == '*' || (r >= '0' && r <= '9'):
+			if !isKeycap(runes[i+1:]) {
+				return false
+			}
+			hasBase = true
+		default:
+			return false
+		}
+	}
+	return hasBase
+}
+
+// isEmojiRune 检查码点是否在表情字符范围内
+func isEmojiRune(r rune) bool {
+	for _, rng := range emojiRanges {
+		if r >= rng[0] && r <= rng[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// isKeycap 检查键帽基础字符之后是否紧跟键帽组合字符，中间可以有一个表情变体选择符
+func isKeycap(rest []rune) bool {
+	if len(rest) > 0 && rest[0] == emojiPresentation {
+		rest = rest[1:]
+	}
+	return len(rest) > 0 && rest[0] == combiningKeycap
+}
+
+// summarizeReactions 为返回给用户的消息汇总表情回应
+func summarizeReactions(messages []*models.Message, userID string) {
+	for _, message := range messages {
+		message.SummarizeReactions(userID)
+	}
+}
@@ -0,0 +1,39 @@
+package services
+
+import "testing"
+
+func TestValidReaction(t *testing.T) {
+	tests := []struct {
+		name  string
+		emoji string
+		valid bool
+	}{
+		{name: "thumbs up", emoji: "👍", valid: true},
+		{name: "heart with variation selector", emoji: "❤️", valid: true},
+		{name: "skin tone", emoji: "👍🏽", valid: true},
+		{name: "family zwj sequence", emoji: "👨‍👩‍👧", valid: true},
+		{name: "flag", emoji: "🇨🇳", valid: true},
+		{name: "keycap", emoji: "1️⃣", valid: true},
+		{name: "subdivision flag", emoji: "🏴󠁧󠁢󠁥󠁮󠁧󠁿", valid: true},
+		{name: "empty", emoji: "", valid: false},
+		{name: "word", emoji: "lol", valid: false},
+		{name: "html", emoji: "<script>", valid: false},
+		{name: "letter", emoji: "a", valid: false},
+		{name: "digit", emoji: "1", valid: false},
+		{name: "chinese", emoji: "好", valid: false},
+		{name: "emoji with text", emoji: "👍ok", valid: false},
+		{name: "field path", emoji: "👍.$", valid: false},
+		{name: "space", emoji: "👍 ", valid: false},
+		{name: "modifiers only", emoji: "‍️", valid: false},
+		{name: "skin tone only", emoji: "🏽", valid: false},
+		{name: "invalid utf8", emoji: "\xff", valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validReaction(tt.emoji); got != tt.valid {
+				t.Errorf("validReaction(%q) = %v, 期望 %v", tt.emoji, got, tt.valid)
+			}
+		})
+	}
+}
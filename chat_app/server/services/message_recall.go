@@ -0,0 +1,159 @@
+package services
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+)
+
+// MessageRecalledEvent message_recalled帧的负载
+type MessageRecalledEvent struct {
+	MessageID      string    `json:"message_id"`
+	ConversationID string    `json:"conversation_id"`
+	RecalledBy     string    `json:"recalled_by"`
+	RecalledAt     time.Time `json:"recalled_at"`
+}
+
+// RecallMessage 撤回消息，发送者可以在2分钟内撤回，群组管理员可以随时撤回群组中的消息
+// 消息被替换为墓碑，并向会话推送message_recalled帧和一条系统消息，不再被引用的媒体文件一并删除
+func (s *MessageService) RecallMessage(userID, messageID string) (*models.Message, error) {
+	message, err := s.getMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.Recalled {
+		return nil, ErrMessageRecalled
+	}
+	if message.Type == models.SystemMessage {
+		return nil, ErrInvalidMessageType
+	}
+	if err := s.checkRecallPermission(message, userID); err != nil {
+		return nil, err
+	}
+
+	recalledAt := time.Now()
+	updated, err := s.messageRepo.RecallMessage(messageID, userID, recalledAt)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, ErrMessageRecalled
+	}
+
+	mediaRefs := message.MediaReferences()
+	message.Content = ""
+	message.MediaURL = ""
+	message.MediaRefs = nil
+	message.Metadata = nil
+	message.Edits = nil
+	message.EditedAt = nil
+	message.Reactions = nil
+	message.ChatRecord = nil
+	message.PinnedBy = ""
+	message.PinnedAt = nil
+	message.Recalled = true
+	message.RecalledBy = userID
+	message.RecalledAt = &recalledAt
+
+	conversationID := message.ConversationKey()
+	if err := s.conversationRepo.UpdateLastMessagePreview(conversationID, message.Preview()); err != nil {
+		log.Printf("更新会话 %s 的消息摘要失败: %v", conversationID, err)
+	}
+
+	if s.searchIndex != nil {
+		if err := s.searchIndex.RemoveMessage(messageID); err != nil {
+			log.Printf("从索引中移除消息 %s 失败: %v", messageID, err)
+		}
+	}
+
+	// 引用了该消息的回复中保存的摘要也一并清空
+	if err := s.messageRepo.RecallReplySnippets(messageID); err != nil {
+		log.Printf("清空消息 %s 的引用摘要失败: %v", messageID, err)
+	}
+
+	s.pushToParticipants(message, websocket.FrameMessageRecalled, &MessageRecalledEvent{
+		MessageID:      messageID,
+		ConversationID: conversationID,
+		RecalledBy:     userID,
+		RecalledAt:     recalledAt,
+	})
+
+	s.sendRecallNotice(message, userID)
+	s.removeUnreferencedMedia(message.SenderID, mediaRefs)
+	return message, nil
+}
+
+// checkRecallPermission 检查用户是否可以撤回消息
+func (s *MessageService) checkRecallPermission(message *models.Message, userID string) error {
+	if message.SenderID == userID && time.Since(message.Timestamp) <= recallWindow {
+		return nil
+	}
+
+	if message.GroupID != "" {
+		isAdmin, err := s.isGroupAdmin(message.GroupID, userID)
+		if err != nil {
+			return err
+		}
+		if isAdmin {
+			return nil
+		}
+	}
+
+	if message.SenderID == userID {
+		return ErrRecallWindowExpired
+	}
+	return ErrRecallForbidden
+}
+
+// sendRecallNotice 在会话中发送一条“某人撤回了一条消息”的系统消息
+func (s *MessageService) sendRecallNotice(message *models.Message, operatorID string) {
+	content := s.displayName(operatorID) + " 撤回了一条消息"
+	if operatorID != message.SenderID {
+		content = s.displayName(operatorID) + " 撤回了一条成员消息"
+	}
+
+	notice := &models.Message{
+		SenderID:   operatorID,
+		ReceiverID: message.ReceiverID,
+		GroupID:    message.GroupID,
+		Type:       models.SystemMessage,
+		Content:    content,
+		Metadata: map[string]interface{}{
+			"event":      "message_recalled",
+			"message_id": message.ID.Hex(),
+		},
+	}
+	if err := s.storeAndPublish(notice); err != nil {
+		log.Printf("发送撤回通知失败: %v", err)
+	}
+}
+
+// isGroupAdmin 检查用户是否为群组管理员
+func (s *MessageService) isGroupAdmin(groupID, userID string) (bool, error) {
+	gid, err := strconv.Atoi(groupID)
+	if err != nil {
+		return false, err
+	}
+	uid, err := strconv.Atoi(userID)
+	if err != nil {
+		return false, err
+	}
+	return s.groupMemberRepo.IsAdmin(gid, uid)
+}
+
+// displayName 返回用户的显示名称，查询失败时返回通用称呼
+func (s *MessageService) displayName(userID string) string {
+	uid, err := strconv.Atoi(userID)
+	if err != nil {
+		return "用户"
+	}
+
+	user, err := s.userRepo.GetUserByID(uid)
+	if err != nil || user == nil {
+		return "用户"
+	}
+	return user.Username
+}
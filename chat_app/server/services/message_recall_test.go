@@ -0,0 +1,315 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeMessageRepository 保存在内存中的消息仓库，只实现撤回流程用到的方法
+type fakeMessageRepository struct {
+	models.MessageRepository
+
+	mu       sync.Mutex
+	messages map[string]*models.Message
+}
+
+func newFakeMessageRepository() *fakeMessageRepository {
+	return &fakeMessageRepository{messages: make(map[string]*models.Message)}
+}
+
+// add 直接保存一条消息，不经过发送流程的校验
+func (r *fakeMessageRepository) add(message *models.Message) *models.Message {
+	if message.ID.IsZero() {
+		message.ID = primitive.NewObjectID()
+	}
+	if message.Type == "" {
+		message.Type = models.TextMessage
+	}
+	message.ConversationID = message.ConversationKey()
+	message.MediaRefs = message.MediaReferences()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages[message.ID.Hex()] = message
+	return message
+}
+
+func (r *fakeMessageRepository) SaveMessage(message *models.Message) error {
+	r.mu.Lock()
+	var lastSeq int64
+	for _, saved := range r.messages {
+		if saved.ConversationID == message.ConversationID && saved.Seq > lastSeq {
+			lastSeq = saved.Seq
+		}
+	}
+	message.Seq = lastSeq + 1
+	r.mu.Unlock()
+
+	saved := *message
+	r.add(&saved)
+	return nil
+}
+
+func (r *fakeMessageRepository) GetMessagesSince(conversationID string, sinceSeq int64, viewer *models.MessageViewer, limit int) ([]*models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var messages []*models.Message
+	for _, message := range r.messages {
+		if message.ConversationID == conversationID && message.Seq > sinceSeq {
+			copied := *message
+			messages = append(messages, &copied)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Seq < messages[j].Seq })
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+func (r *fakeMessageRepository) GetMessageByID(id string) (*models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	message, ok := r.messages[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *message
+	return &copied, nil
+}
+
+func (r *fakeMessageRepository) GetMessageByClientMsgID(senderID, clientMsgID string) (*models.Message, error) {
+	return nil, nil
+}
+
+func (r *fakeMessageRepository) RecallMessage(id, recalledBy string, recalledAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	message, ok := r.messages[id]
+	if !ok || message.Recalled {
+		return false, nil
+	}
+	message.Content = ""
+	message.MediaURL = ""
+	message.MediaRefs = nil
+	message.ChatRecord = nil
+	message.Recalled = true
+	message.RecalledBy = recalledBy
+	message.RecalledAt = &recalledAt
+	return true, nil
+}
+
+func (r *fakeMessageRepository) RecallReplySnippets(id string) error {
+	return nil
+}
+
+func (r *fakeMessageRepository) IsMediaReferenced(mediaURL string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, message := range r.messages {
+		for _, ref := range message.MediaRefs {
+			if ref == mediaURL {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// fakeConversationRepository 忽略所有会话更新的会话仓库
+type fakeConversationRepository struct {
+	models.ConversationRepository
+}
+
+func (r *fakeConversationRepository) UpdateLastMessage(conversation *models.Conversation) error {
+	return nil
+}
+
+func (r *fakeConversationRepository) GetSettings(userID, conversationID string) (*models.ConversationSettings, error) {
+	return nil, nil
+}
+
+func (r *fakeConversationRepository) UpdateLastMessagePreview(conversationID string, preview *models.MessagePreview) error {
+	return nil
+}
+
+// fakeUserRepository 以用户ID作为用户名的用户仓库
+type fakeUserRepository struct {
+	models.UserRepository
+}
+
+func (r *fakeUserRepository) GetUserByID(id int) (*models.User, error) {
+	return &models.User{ID: id, Username: "user" + strconv.Itoa(id)}, nil
+}
+
+// newTestMessageService 创建使用内存仓库的消息服务，上传的媒体文件保存在临时目录中
+// 群组7的成员为用户1、2、3，其中用户3是管理员
+func newTestMessageService(t *testing.T) (*MessageService, *fakeMessageRepository) {
+	t.Helper()
+
+	hub := websocket.NewHub()
+	go hub.Run()
+
+	repo := newFakeMessageRepository()
+	members := &fakeGroupMemberRepository{
+		members: map[int][]*models.User{7: {{ID: 1}, {ID: 2}, {ID: 3}}},
+		admins:  map[int][]int{7: {3}},
+	}
+	service := NewMessageService(repo, nil, &fakeConversationRepository{}, members, &fakeUserRepository{},
+		nil, nil, hub, NewPushService(nil, hub))
+	service.SetMediaDir(t.TempDir())
+	return service, repo
+}
+
+// upload 在媒体目录中创建用户上传的文件，返回文件的访问地址和路径
+func upload(t *testing.T, service *MessageService, uploaderID, fileName string) (string, string) {
+	t.Helper()
+
+	dir := filepath.Join(service.mediaDir, "image", "user_"+uploaderID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("创建上传目录失败: %v", err)
+	}
+	path := filepath.Join(dir, fileName)
+	if err := os.WriteFile(path, []byte("image"), 0644); err != nil {
+		t.Fatalf("创建上传文件失败: %v", err)
+	}
+	return mediaURLPrefix + "image/" + fileName, path
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestSendMessageRejectsOtherUsersMedia(t *testing.T) {
+	service, _ := newTestMessageService(t)
+	url, _ := upload(t, service, "2", "b.png")
+
+	message := &models.Message{SenderID: "1", ReceiverID: "2", Type: models.ImageMessage, MediaURL: url}
+	if err := service.SendMessage(message); err != ErrInvalidMediaURL {
+		t.Fatalf("发送其他用户上传的媒体返回 %v, 期望 %v", err, ErrInvalidMediaURL)
+	}
+
+	message = &models.Message{SenderID: "2", ReceiverID: "1", Type: models.ImageMessage, MediaURL: url}
+	if err := service.SendMessage(message); err != nil {
+		t.Fatalf("发送自己上传的媒体失败: %v", err)
+	}
+}
+
+func TestRecallKeepsOtherUsersMedia(t *testing.T) {
+	service, repo := newTestMessageService(t)
+	url, path := upload(t, service, "2", "b.png")
+
+	// 用户1的消息引用了用户2上传但尚未发送的文件
+	message := repo.add(&models.Message{
+		SenderID: "1", ReceiverID: "2", Type: models.ImageMessage, MediaURL: url, Timestamp: time.Now(),
+	})
+	if _, err := service.RecallMessage("1", message.ID.Hex()); err != nil {
+		t.Fatalf("撤回消息失败: %v", err)
+	}
+	if !fileExists(path) {
+		t.Fatal("撤回其他用户上传的媒体时删除了文件")
+	}
+
+	own := repo.add(&models.Message{
+		SenderID: "2", ReceiverID: "1", Type: models.ImageMessage, MediaURL: url, Timestamp: time.Now(),
+	})
+	if _, err := service.RecallMessage("2", own.ID.Hex()); err != nil {
+		t.Fatalf("撤回消息失败: %v", err)
+	}
+	if fileExists(path) {
+		t.Fatal("撤回自己上传的媒体后文件没有被删除")
+	}
+}
+
+func TestRecallPermission(t *testing.T) {
+	expired := time.Now().Add(-2 * recallWindow)
+
+	tests := []struct {
+		name      string
+		message   *models.Message
+		userID    string
+		wantError error
+	}{
+		{
+			name:    "sender within window",
+			message: &models.Message{SenderID: "1", GroupID: "7", Content: "群组消息", Timestamp: time.Now()},
+			userID:  "1",
+		},
+		{
+			name:    "admin after window",
+			message: &models.Message{SenderID: "1", GroupID: "7", Content: "群组消息", Timestamp: expired},
+			userID:  "3",
+		},
+		{
+			name:      "sender after window",
+			message:   &models.Message{SenderID: "1", GroupID: "7", Content: "群组消息", Timestamp: expired},
+			userID:    "1",
+			wantError: ErrRecallWindowExpired,
+		},
+		{
+			name:      "non-admin member",
+			message:   &models.Message{SenderID: "1", GroupID: "7", Content: "群组消息", Timestamp: time.Now()},
+			userID:    "2",
+			wantError: ErrRecallForbidden,
+		},
+		{
+			name:      "private recipient",
+			message:   &models.Message{SenderID: "1", ReceiverID: "2", Content: "私聊消息", Timestamp: time.Now()},
+			userID:    "2",
+			wantError: ErrRecallForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, repo := newTestMessageService(t)
+			message := repo.add(tt.message)
+
+			_, err := service.RecallMessage(tt.userID, message.ID.Hex())
+			if err != tt.wantError {
+				t.Fatalf("用户 %s 撤回消息返回 %v, 期望 %v", tt.userID, err, tt.wantError)
+			}
+
+			stored, _ := repo.GetMessageByID(message.ID.Hex())
+			if stored.Recalled != (tt.wantError == nil) {
+				t.Errorf("消息的撤回状态为 %v, 期望 %v", stored.Recalled, tt.wantError == nil)
+			}
+		})
+	}
+}
+
+func TestRecallKeepsForwardedMedia(t *testing.T) {
+	service, repo := newTestMessageService(t)
+	url, path := upload(t, service, "1", "a.png")
+
+	original := repo.add(&models.Message{
+		SenderID: "1", ReceiverID: "2", Type: models.ImageMessage, MediaURL: url, Timestamp: time.Now(),
+	})
+	// 用户2把消息转发到群组，转发的副本引用同一个文件
+	repo.add(&models.Message{
+		SenderID: "2", GroupID: "7", Type: models.ImageMessage, MediaURL: url, Timestamp: time.Now(),
+		ForwardedFrom: &models.ForwardInfo{MessageID: original.ID.Hex(), SenderID: "1"},
+	})
+
+	if _, err := service.RecallMessage("1", original.ID.Hex()); err != nil {
+		t.Fatalf("撤回消息失败: %v", err)
+	}
+	if !fileExists(path) {
+		t.Fatal("撤回消息时删除了仍被转发副本引用的文件")
+	}
+}
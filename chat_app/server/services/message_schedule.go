@@ -0,0 +1,355 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+)
+
+const (
+	// 发送节点检查到期定时消息的间隔
+	scheduledPollInterval = time.Second
+
+	// 每次检查最多发送的定时消息数，剩余的消息在下一次检查时发送
+	scheduledBatchSize = 100
+
+	// 领取后超过该时间仍未完成的定时消息视为领取节点已失效，可以被重新领取
+	scheduledClaimTimeout = time.Minute
+
+	// 定时消息最多被领取的次数，超过后标记为发送失败
+	maxScheduledAttempts = 3
+
+	// 每个用户最多同时等待发送的定时消息数
+	maxPendingScheduled = 100
+
+	// 定时发送时间最多可以设置到多久以后
+	maxScheduleAhead = 365 * 24 * time.Hour
+)
+
+// SetScheduledMessageRepository 设置定时消息仓库，必须在开始处理请求之前调用
+// 未设置时定时消息相关的请求返回ErrSchedulingUnavailable
+func (s *MessageService) SetScheduledMessageRepository(repo models.ScheduledMessageRepository) {
+	s.scheduledRepo = repo
+}
+
+// ScheduleMessage 保存一条在message.SendAt发送的定时消息
+// 引用、话题和@在保存时按普通消息校验一次，发送时再次校验，期间失效的定时消息标记为发送失败
+// 保存后向发送者的所有设备推送scheduled_message帧
+func (s *MessageService) ScheduleMessage(message *models.ScheduledMessage) error {
+	if s.scheduledRepo == nil {
+		return ErrSchedulingUnavailable
+	}
+	if message.ReceiverID == "" && message.GroupID == "" {
+		return ErrMissingRecipient
+	}
+	if message.Type == "" {
+		message.Type = models.TextMessage
+	}
+	if message.Content == "" && message.MediaURL == "" {
+		return ErrEmptyMessage
+	}
+	if message.Type == models.SystemMessage || message.Type == models.ChatRecordMessage {
+		return ErrInvalidMessageType
+	}
+	if err := checkSendAt(message.SendAt); err != nil {
+		return err
+	}
+	if err := s.checkMediaOwner(scheduledDraft(message)); err != nil {
+		return err
+	}
+
+	if message.GroupID != "" {
+		isMember, err := s.isGroupMember(message.GroupID, message.SenderID)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return ErrNotGroupMember
+		}
+	}
+
+	// 引用的消息不存在或已被撤回时直接拒绝，不等到发送时才失败
+	draft := scheduledDraft(message)
+	if err := s.resolveReply(draft); err != nil {
+		if err == ErrMessageNotFound || err == ErrMessageRecalled {
+			return ErrInvalidReply
+		}
+		return err
+	}
+	if err := s.resolveMentions(draft); err != nil {
+		return err
+	}
+	message.ThreadID = draft.ThreadID
+	message.Mentions = draft.Mentions
+
+	now := time.Now()
+	message.Status = models.ScheduledPending
+	message.CreatedAt = now
+	message.UpdatedAt = now
+	created, err := s.scheduledRepo.CreateScheduledMessage(message, maxPendingScheduled)
+	if err != nil {
+		return err
+	}
+	if !created {
+		return ErrTooManyScheduled
+	}
+
+	s.pushFrame(message.SenderID, websocket.FrameScheduledMessage, message)
+	return nil
+}
+
+// ListScheduledMessages 获取用户尚未成功发送的定时消息，包括发送失败的消息，按计划发送时间升序排列
+func (s *MessageService) ListScheduledMessages(userID string) ([]*models.ScheduledMessage, error) {
+	if s.scheduledRepo == nil {
+		return nil, ErrSchedulingUnavailable
+	}
+
+	messages, err := s.scheduledRepo.ListScheduledMessages(userID, []models.ScheduledMessageStatus{
+		models.ScheduledPending,
+		models.ScheduledSending,
+		models.ScheduledFailed,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if messages == nil {
+		messages = []*models.ScheduledMessage{}
+	}
+	return messages, nil
+}
+
+// EditScheduledMessage 修改等待发送的定时消息的内容或发送时间，参数为nil时保持不变
+func (s *MessageService) EditScheduledMessage(userID, id string, content *string, sendAt *time.Time) (*models.ScheduledMessage, error) {
+	message, err := s.getScheduledMessage(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if message.Status != models.ScheduledPending {
+		return nil, ErrScheduledMessageNotPending
+	}
+
+	if content != nil {
+		message.Content = *content
+	}
+	if message.Content == "" && message.MediaURL == "" {
+		return nil, ErrEmptyMessage
+	}
+	if sendAt != nil {
+		if err := checkSendAt(*sendAt); err != nil {
+			return nil, err
+		}
+		message.SendAt = *sendAt
+	}
+
+	message.UpdatedAt = time.Now()
+	updated, err := s.scheduledRepo.UpdateScheduledMessage(id, userID, message.Content, message.SendAt, message.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	// 消息在此期间已被领取发送或被取消
+	if !updated {
+		return nil, ErrScheduledMessageNotPending
+	}
+
+	s.pushFrame(userID, websocket.FrameScheduledMessage, message)
+	return message, nil
+}
+
+// CancelScheduledMessage 取消等待发送的定时消息，已被领取发送的消息不能取消
+func (s *MessageService) CancelScheduledMessage(userID, id string) (*models.ScheduledMessage, error) {
+	message, err := s.getScheduledMessage(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if message.Status != models.ScheduledPending {
+		return nil, ErrScheduledMessageNotPending
+	}
+
+	cancelledAt := time.Now()
+	cancelled, err := s.scheduledRepo.CancelScheduledMessage(id, userID, cancelledAt)
+	if err != nil {
+		return nil, err
+	}
+	if !cancelled {
+		return nil, ErrScheduledMessageNotPending
+	}
+
+	message.Status = models.ScheduledCancelled
+	message.UpdatedAt = cancelledAt
+	s.pushFrame(userID, websocket.FrameScheduledMessage, message)
+	return message, nil
+}
+
+// getScheduledMessage 获取用户自己的定时消息，其他用户的定时消息视为不存在
+func (s *MessageService) getScheduledMessage(userID, id string) (*models.ScheduledMessage, error) {
+	if s.scheduledRepo == nil {
+		return nil, ErrSchedulingUnavailable
+	}
+
+	message, err := s.scheduledRepo.GetScheduledMessage(id)
+	if err != nil {
+		return nil, err
+	}
+	if message == nil || message.SenderID != userID {
+		return nil, ErrScheduledMessageNotFound
+	}
+	return message, nil
+}
+
+// checkSendAt 检查定时发送时间是否在允许的范围内
+func checkSendAt(sendAt time.Time) error {
+	now := time.Now()
+	if !sendAt.After(now) || sendAt.After(now.Add(maxScheduleAhead)) {
+		return ErrInvalidSchedule
+	}
+	return nil
+}
+
+// StartScheduler 启动定时消息的发送循环，每个节点都可以启动
+// 到期的消息由各节点通过原子更新领取，每条消息只会被一个节点发送
+func (s *MessageService) StartScheduler() {
+	if s.scheduledRepo == nil || s.schedulerStop != nil {
+		return
+	}
+
+	s.schedulerStop = make(chan struct{})
+	s.schedulerDone = make(chan struct{})
+	go s.runScheduler(s.schedulerStop, s.schedulerDone)
+}
+
+// StopScheduler 停止定时消息的发送循环，等待正在发送的消息完成
+func (s *MessageService) StopScheduler() {
+	if s.schedulerStop == nil {
+		return
+	}
+
+	close(s.schedulerStop)
+	<-s.schedulerDone
+	s.schedulerStop = nil
+	s.schedulerDone = nil
+}
+
+// runScheduler 定期发送到期的定时消息，直到stop被关闭
+func (s *MessageService) runScheduler(stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(scheduledPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.sendDueScheduledMessages(stop)
+		}
+	}
+}
+
+// sendDueScheduledMessages 逐条领取并发送到期的定时消息
+func (s *MessageService) sendDueScheduledMessages(stop chan struct{}) {
+	for i := 0; i < scheduledBatchSize; i++ {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		now := time.Now()
+		scheduled, err := s.scheduledRepo.ClaimDueScheduledMessage(now, now.Add(-scheduledClaimTimeout))
+		if err != nil {
+			log.Printf("领取到期的定时消息失败: %v", err)
+			return
+		}
+		if scheduled == nil {
+			return
+		}
+
+		s.sendScheduledMessage(scheduled)
+	}
+}
+
+// sendScheduledMessage 按普通消息发送一条已领取的定时消息
+// 发送时使用固定的客户端消息ID，领取节点失效后被重新领取的消息不会重复保存
+func (s *MessageService) sendScheduledMessage(scheduled *models.ScheduledMessage) {
+	id := scheduled.ID.Hex()
+	if scheduled.Attempts > maxScheduledAttempts {
+		s.failScheduledMessage(scheduled, "多次发送均未完成")
+		return
+	}
+
+	message := scheduledDraft(scheduled)
+	message.ClientMsgID = scheduled.ClientMsgID()
+
+	if err := s.SendMessage(message); err != nil {
+		if isPermanentSendError(err) {
+			s.failScheduledMessage(scheduled, err.Error())
+			return
+		}
+		// 临时错误不修改状态，领取超时后由任意节点重试
+		log.Printf("发送定时消息 %s 失败: %v", id, err)
+		return
+	}
+
+	sentAt := time.Now()
+	if err := s.scheduledRepo.CompleteScheduledMessage(id, message.ID.Hex(), sentAt); err != nil {
+		log.Printf("更新定时消息 %s 的发送结果失败: %v", id, err)
+		return
+	}
+
+	scheduled.Status = models.ScheduledSent
+	scheduled.MessageID = message.ID.Hex()
+	scheduled.SentAt = &sentAt
+	scheduled.UpdatedAt = sentAt
+	s.pushFrame(scheduled.SenderID, websocket.FrameScheduledMessage, scheduled)
+}
+
+// failScheduledMessage 将定时消息标记为发送失败并通知发送者
+func (s *MessageService) failScheduledMessage(scheduled *models.ScheduledMessage, reason string) {
+	id := scheduled.ID.Hex()
+	failedAt := time.Now()
+	if err := s.scheduledRepo.FailScheduledMessage(id, reason, failedAt); err != nil {
+		log.Printf("更新定时消息 %s 的失败状态失败: %v", id, err)
+		return
+	}
+
+	scheduled.Status = models.ScheduledFailed
+	scheduled.Error = reason
+	scheduled.UpdatedAt = failedAt
+	s.pushFrame(scheduled.SenderID, websocket.FrameScheduledMessage, scheduled)
+}
+
+// scheduledDraft 根据定时消息生成待发送的普通消息
+func scheduledDraft(scheduled *models.ScheduledMessage) *models.Message {
+	message := &models.Message{
+		SenderID:   scheduled.SenderID,
+		ReceiverID: scheduled.ReceiverID,
+		GroupID:    scheduled.GroupID,
+		Type:       scheduled.Type,
+		Content:    scheduled.Content,
+		MediaURL:   scheduled.MediaURL,
+		ThreadID:   scheduled.ThreadID,
+		Mentions:   scheduled.Mentions,
+		MentionAll: scheduled.MentionAll,
+	}
+	if scheduled.ReplyToID != "" {
+		message.ReplyTo = &models.MessagePreview{MessageID: scheduled.ReplyToID}
+	}
+	return message
+}
+
+// isPermanentSendError 检查发送错误是否由消息本身导致，这类错误重试也不会成功
+// 引用的消息或话题在计划发送前被删除或撤回也属于这类错误
+func isPermanentSendError(err error) bool {
+	switch err {
+	case ErrMissingRecipient, ErrEmptyMessage, ErrInvalidMessageType, ErrNotGroupMember,
+		ErrInvalidClientMsgID, ErrInvalidReply, ErrThreadNotSupported,
+		ErrMentionNotSupported, ErrMentionAllForbidden,
+		ErrMessageNotFound, ErrMessageRecalled, ErrInvalidMediaURL:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,90 +1,312 @@
 package services
 
 import (
-	"context"
-	"encoding/json"
 	"errors"
+	"log"
 	"time"
 
 	"chat_app/server/database"
 	"chat_app/server/models"
 	"chat_app/server/websocket"
 
+	"github.com/nats-io/nats.go"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+var (
+	// ErrNotGroupMember 用户不是群组成员
+	ErrNotGroupMember = errors.New("您不是该群组成员")
+
+	// ErrNotParticipant 用户不是会话的参与者
+	ErrNotParticipant = errors.New("您不是该会话的参与者")
+
+	// ErrMessageNotFound 消息不存在
+	ErrMessageNotFound = errors.New("消息不存在")
+
+	// ErrNotMessageSender 用户不是消息的发送者
+	ErrNotMessageSender = errors.New("只有消息的发送者可以执行该操作")
+
+	// ErrInvalidMessageType 客户端不能发送该类型的消息
+	ErrInvalidMessageType = errors.New("不支持的消息类型")
+
+	// ErrMessageRecalled 消息已被撤回
+	ErrMessageRecalled = errors.New("消息已被撤回")
+
+	// ErrRecallForbidden 用户无权撤回该消息
+	ErrRecallForbidden = errors.New("只有发送者或群组管理员可以撤回消息")
+
+	// ErrRecallWindowExpired 已超过允许撤回的时间
+	ErrRecallWindowExpired = errors.New("消息发送时间超过2分钟，无法撤回")
+
+	// ErrInvalidReply 引用的消息或话题不在该会话中
+	ErrInvalidReply = errors.New("引用的消息不在该会话中")
+
+	// ErrThreadNotSupported 只有群组消息可以创建话题
+	ErrThreadNotSupported = errors.New("只能在群组消息中使用话题")
+
+	// ErrInvalidForward 转发请求中的消息或目标数量无效
+	ErrInvalidForward = errors.New("转发的消息或目标数量无效")
+
+	// ErrMentionNotSupported 只有群组消息可以@成员
+	ErrMentionNotSupported = errors.New("只能在群组消息中@成员")
+
+	// ErrMentionAllForbidden 只有群组管理员可以@所有人
+	ErrMentionAllForbidden = errors.New("只有群组管理员可以@所有人")
+
+	// ErrPinForbidden 用户无权置顶该消息
+	ErrPinForbidden = errors.New("只有群组管理员可以置顶群组消息")
+
+	// ErrTooManyPins 会话中的置顶消息数已达上限
+	ErrTooManyPins = errors.New("置顶消息数已达上限，请先取消其他消息的置顶")
+
+	// ErrInvalidReaction 表情回应格式无效
+	ErrInvalidReaction = errors.New("无效的表情")
+
+	// ErrTooManyReactions 消息的表情种类数已达上限
+	ErrTooManyReactions = errors.New("该消息的表情种类已达上限")
+
+	// ErrMessageNotEditable 消息类型不支持编辑
+	ErrMessageNotEditable = errors.New("只能编辑文本消息")
+
+	// ErrEditWindowExpired 已超过允许编辑的时间
+	ErrEditWindowExpired = errors.New("消息发送时间过久，无法编辑")
+
+	// ErrEditConflict 消息在编辑期间被其他请求修改
+	ErrEditConflict = errors.New("消息已被修改，请刷新后重试")
+
+	// ErrMissingRecipient 消息没有指定接收者或群组
+	ErrMissingRecipient = errors.New("接收者ID或群组ID不能为空")
+
+	// ErrInvalidMediaURL 消息引用了不是发送者上传的媒体文件
+	ErrInvalidMediaURL = errors.New("无效的媒体地址")
+
+	// ErrEmptyMessage 消息既没有内容也没有媒体
+	ErrEmptyMessage = errors.New("消息内容不能为空")
+
+	// ErrInvalidSchedule 定时发送时间不在允许的范围内
+	ErrInvalidSchedule = errors.New("定时发送时间必须晚于当前时间且不超过一年")
+
+	// ErrScheduledMessageNotFound 定时消息不存在或不属于该用户
+	ErrScheduledMessageNotFound = errors.New("定时消息不存在")
+
+	// ErrScheduledMessageNotPending 定时消息已发送、正在发送或已取消
+	ErrScheduledMessageNotPending = errors.New("定时消息已发送或已取消，无法修改")
+
+	// ErrTooManyScheduled 等待发送的定时消息数已达上限
+	ErrTooManyScheduled = errors.New("等待发送的定时消息数已达上限")
+
+	// ErrSchedulingUnavailable 没有配置定时消息仓库
+	ErrSchedulingUnavailable = errors.New("定时消息不可用")
+
+	// ErrInvalidClientMsgID 客户端消息ID过长
+	ErrInvalidClientMsgID = errors.New("客户端消息ID不能超过64个字符")
+)
+
+const (
+	// 增量同步默认返回的消息数
+	defaultSyncLimit = 100
+
+	// 增量同步单次最多返回的消息数
+	maxSyncLimit = 500
+
+	// 客户端消息ID的最大长度
+	maxClientMsgIDLength = 64
+
+	// 默认允许编辑消息的时间
+	defaultEditWindow = 15 * time.Minute
+
+	// 发送者允许撤回消息的时间，群组管理员撤回不受限制
+	recallWindow = 2 * time.Minute
+)
+
+// SyncResult 增量同步结果
+type SyncResult struct {
+	ConversationID string            `json:"conversation_id"`
+	Messages       []*models.Message `json:"messages"`
+
+	// 本次返回的最后一条消息的序列号，客户端下次同步时作为since_seq
+	LatestSeq int64 `json:"latest_seq"`
+
+	// 是否还有更多消息需要继续同步
+	HasMore bool `json:"has_more"`
+}
+
 // MessageService 处理消息相关的业务逻辑
 type MessageService struct {
-	messageRepo models.MessageRepository
-	redisDB     *database.RedisDB
-	natsDB      *database.NATSDB
-	wsHub       *websocket.Hub
+	messageRepo      models.MessageRepository
+	readStateRepo    models.ReadStateRepository
+	conversationRepo models.ConversationRepository
+	groupMemberRepo  models.GroupMemberRepository
+	userRepo         models.UserRepository
+	redisDB          *database.RedisDB
+	natsDB           *database.NATSDB
+	wsHub            *websocket.Hub
+	push             *PushService
+
+	// 本节点用户的正在输入状态
+	typing *typingTracker
+
+	// 消息发送后允许编辑的时间
+	editWindow time.Duration
+
+	// 发送@通知的推送服务，可以为nil
+	notificationService *NotificationService
+
+	// 消息全文索引，可以为nil
+	searchIndex models.MessageSearchIndex
+
+	// 上传的媒体文件所在的目录，为空时撤回消息不删除媒体文件
+	mediaDir string
+
+	// 定时消息仓库，可以为nil；以及定时消息发送循环的停止信号
+	scheduledRepo models.ScheduledMessageRepository
+	schedulerStop chan struct{}
+	schedulerDone chan struct{}
+
+	// 消息投递的NATS订阅
+	subscriptions []*nats.Subscription
 }
 
 // NewMessageService 创建新的消息服务
 func NewMessageService(
 	messageRepo models.MessageRepository,
+	readStateRepo models.ReadStateRepository,
+	conversationRepo models.ConversationRepository,
+	groupMemberRepo models.GroupMemberRepository,
+	userRepo models.UserRepository,
 	redisDB *database.RedisDB,
 	natsDB *database.NATSDB,
 	wsHub *websocket.Hub,
+	push *PushService,
 ) *MessageService {
 	return &MessageService{
-		messageRepo: messageRepo,
-		redisDB:     redisDB,
-		natsDB:      natsDB,
-		wsHub:       wsHub,
+		messageRepo:      messageRepo,
+		readStateRepo:    readStateRepo,
+		conversationRepo: conversationRepo,
+		groupMemberRepo:  groupMemberRepo,
+		userRepo:         userRepo,
+		redisDB:          redisDB,
+		natsDB:           natsDB,
+		wsHub:            wsHub,
+		push:             push,
+		typing:           newTypingTracker(),
+		editWindow:       defaultEditWindow,
 	}
 }
 
 // SendMessage 发送消息
 func (s *MessageService) SendMessage(message *models.Message) error {
-	// 设置消息ID和时间戳
-	message.ID = primitive.NewObjectID()
-	message.Timestamp = time.Now()
-	message.Read = false
-
-	// 保存消息到数据库
-	err := s.messageRepo.SaveMessage(message)
-	if err != nil {
-		return err
+	if message.ReceiverID == "" && message.GroupID == "" {
+		return ErrMissingRecipient
+	}
+	if message.Type == "" {
+		message.Type = models.TextMessage
+	}
+	if message.Content == "" && message.MediaURL == "" {
+		return ErrEmptyMessage
+	}
+	if message.Type == models.SystemMessage {
+		return ErrInvalidMessageType
+	}
+	// 聊天记录只能通过合并转发生成
+	if (message.Type == models.ChatRecordMessage) != (message.ChatRecord != nil) {
+		return ErrInvalidMessageType
 	}
 
-	// 将消息转换为JSON
-	messageJSON, err := json.Marshal(message)
-	if err != nil {
-		return err
+	// 转发的消息可以引用原发送者上传的媒体，其他消息只能引用发送者自己上传的媒体
+	if message.ForwardedFrom == nil {
+		if err := s.checkMediaOwner(message); err != nil {
+			return err
+		}
 	}
 
-	// 如果是私聊消息
-	if message.ReceiverID != "" {
-		// 检查接收者是否在线
-		ctx := context.Background()
-		isOnline, err := s.redisDB.IsUserOnline(ctx, message.ReceiverID)
+	// 群组消息只能由群组成员发送
+	if message.GroupID != "" {
+		isMember, err := s.isGroupMember(message.GroupID, message.SenderID)
 		if err != nil {
 			return err
 		}
+		if !isMember {
+			return ErrNotGroupMember
+		}
+	}
 
-		// 如果接收者在线，通过WebSocket发送消息
-		if isOnline {
-			s.wsHub.SendToUser(message.ReceiverID, messageJSON)
+	// 客户端重试时携带相同的消息ID，直接返回已保存的消息
+	if message.ClientMsgID != "" {
+		if len(message.ClientMsgID) > maxClientMsgIDLength {
+			return ErrInvalidClientMsgID
 		}
 
-		// 无论如何都通过NATS发布消息，以便其他服务可以处理
-		err = s.natsDB.PublishMessage("message.private."+message.ReceiverID, messageJSON)
+		existing, err := s.messageRepo.GetMessageByClientMsgID(message.SenderID, message.ClientMsgID)
 		if err != nil {
 			return err
 		}
-	} else if message.GroupID != "" {
-		// 如果是群组消息，通过NATS发布
-		err = s.natsDB.PublishMessage("message.group."+message.GroupID, messageJSON)
+		if existing != nil {
+			*message = *existing
+			return nil
+		}
+	}
+
+	if err := s.resolveReply(message); err != nil {
+		return err
+	}
+	if err := s.resolveMentions(message); err != nil {
+		return err
+	}
+
+	return s.storeAndPublish(message)
+}
+
+// storeAndPublish 分配序列号、保存消息并投递给接收者，调用方负责校验消息
+func (s *MessageService) storeAndPublish(message *models.Message) error {
+	// 设置消息ID和时间戳
+	message.ConversationID = message.ConversationKey()
+	message.ID = primitive.NewObjectID()
+	message.Timestamp = time.Now()
+	message.MediaRefs = message.MediaReferences()
+	message.Read = false
+	message.Status = models.MessageStatusServerAck
+
+	// 保存消息到数据库，同时分配会话内的序列号
+	err := s.messageRepo.SaveMessage(message)
+	if err == models.ErrDuplicateMessage {
+		// 并发的重试请求已经保存了这条消息，以先保存的为准
+		existing, err := s.messageRepo.GetMessageByClientMsgID(message.SenderID, message.ClientMsgID)
 		if err != nil {
 			return err
 		}
-	} else {
-		return errors.New("消息必须指定接收者ID或群组ID")
+		if existing != nil {
+			*message = *existing
+			return nil
+		}
+		return models.ErrDuplicateMessage
+	}
+	if err != nil {
+		return err
 	}
 
-	return nil
+	// 更新会话列表中的最后一条消息
+	s.updateConversation(message)
+	s.indexMessage(message)
+
+	if message.ThreadID != "" {
+		s.recordThreadReply(message)
+	}
+	if len(message.Mentions) > 0 || message.MentionAll {
+		s.notifyMentions(message)
+	}
+
+	// 消息发出后发送者不再处于正在输入状态
+	s.StopTyping(message.SenderID, message.ConversationID)
+
+	return s.publish(message)
+}
+
+// updateConversation 更新消息所属会话的最后一条消息和活跃时间，失败时只记录日志
+func (s *MessageService) updateConversation(message *models.Message) {
+	if err := s.conversationRepo.UpdateLastMessage(models.ConversationFromMessage(message)); err != nil {
+		log.Printf("更新会话 %s 的最后一条消息失败: %v", message.ConversationID, err)
+	}
 }
 
 // GetMessageHistory 获取消息历史
@@ -92,7 +314,12 @@ func (s *MessageService) GetMessageHistory(userID1, userID2 string, limit, offse
 	// 打印请求参数
 	println("获取私聊消息历史: 用户1=", userID1, "用户2=", userID2, "限制=", limit, "偏移=", offset)
 
-	messages, err := s.messageRepo.GetMessagesBetweenUsers(userID1, userID2, limit, offset)
+	viewer, err := s.messageViewer(userID1, models.PrivateConversationID(userID1, userID2))
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := s.messageRepo.GetMessagesBetweenUsers(userID1, userID2, viewer, limit, offset)
 	if err != nil {
 		println("获取消息历史失败:", err.Error())
 		return nil, err
@@ -107,15 +334,26 @@ func (s *MessageService) GetMessageHistory(userID1, userID2 string, limit, offse
 		messages[i], messages[j] = messages[j], messages[i]
 	}
 
+	summarizeReactions(messages, userID1)
 	return messages, nil
 }
 
-// GetGroupMessageHistory 获取群组消息历史
-func (s *MessageService) GetGroupMessageHistory(groupID string, limit, offset int) ([]*models.Message, error) {
+// GetGroupMessageHistory 获取群组消息历史，不包括用户删除或清空的消息，只有群组成员可以查看
+func (s *MessageService) GetGroupMessageHistory(userID, groupID string, limit, offset int) ([]*models.Message, error) {
 	// 打印请求参数
 	println("获取群组消息历史: 群组ID=", groupID, "限制=", limit, "偏移=", offset)
 
-	messages, err := s.messageRepo.GetGroupMessages(groupID, limit, offset)
+	conversationID := models.GroupConversationID(groupID)
+	if err := s.checkConversationAccess(userID, conversationID); err != nil {
+		return nil, err
+	}
+
+	viewer, err := s.messageViewer(userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := s.messageRepo.GetGroupMessages(groupID, viewer, limit, offset)
 	if err != nil {
 		println("获取群组消息历史失败:", err.Error())
 		return nil, err
@@ -130,25 +368,169 @@ func (s *MessageService) GetGroupMessageHistory(groupID string, limit, offset in
 		messages[i], messages[j] = messages[j], messages[i]
 	}
 
+	summarizeReactions(messages, userID)
 	return messages, nil
 }
 
+// SyncMessages 返回会话中序列号大于sinceSeq的消息，用于断线重连后无缺漏、无重复地补齐消息
+func (s *MessageService) SyncMessages(userID, conversationID string, sinceSeq int64, limit int) (*SyncResult, error) {
+	if err := s.checkConversationAccess(userID, conversationID); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = defaultSyncLimit
+	}
+	if limit > maxSyncLimit {
+		limit = maxSyncLimit
+	}
+
+	viewer, err := s.messageViewer(userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 多取一条用于判断是否还有更多消息
+	messages, err := s.messageRepo.GetMessagesSince(conversationID, sinceSeq, viewer, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SyncResult{
+		ConversationID: conversationID,
+		Messages:       messages,
+		LatestSeq:      sinceSeq,
+	}
+	if len(messages) > limit {
+		result.Messages = messages[:limit]
+		result.HasMore = true
+	}
+	if n := len(result.Messages); n > 0 {
+		result.LatestSeq = result.Messages[n-1].Seq
+	} else {
+		result.Messages = []*models.Message{}
+	}
+
+	summarizeReactions(result.Messages, userID)
+	return result, nil
+}
+
+// checkConversationAccess 检查用户是否可以访问会话
+func (s *MessageService) checkConversationAccess(userID, conversationID string) error {
+	ref, err := models.ParseConversationID(conversationID)
+	if err != nil {
+		return err
+	}
+
+	if ref.Type == models.PrivateConversation {
+		if !ref.HasParticipant(userID) {
+			return ErrNotParticipant
+		}
+		return nil
+	}
+
+	isMember, err := s.isGroupMember(ref.GroupID, userID)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return ErrNotParticipant
+	}
+	return nil
+}
+
+// AckDelivery 处理接收者设备对推送消息的确认，将消息标记为已送达并通知发送者
+// 只处理用户作为接收者的消息，返回本次确认的消息ID
+func (s *MessageService) AckDelivery(userID string, messageIDs []string) ([]string, error) {
+	acked := make([]string, 0, len(messageIDs))
+
+	for _, messageID := range messageIDs {
+		message, err := s.messageRepo.GetMessageByID(messageID)
+		if err != nil {
+			log.Printf("确认送达时获取消息 %s 失败: %v", messageID, err)
+			continue
+		}
+		if message == nil {
+			continue
+		}
+
+		isRecipient, err := s.isRecipient(message, userID)
+		if err != nil {
+			return acked, err
+		}
+		if !isRecipient {
+			continue
+		}
+
+		deliveredAt := time.Now()
+		updated, err := s.messageRepo.MarkMessageDelivered(messageID, deliveredAt)
+		if err != nil {
+			return acked, err
+		}
+		acked = append(acked, messageID)
+
+		// 群组消息只在第一个成员确认时通知发送者
+		if updated {
+			s.pushFrame(message.SenderID, websocket.FrameMessageStatus, map[string]interface{}{
+				"message_id":      messageID,
+				"conversation_id": message.ConversationID,
+				"status":          models.MessageStatusDelivered,
+				"delivered_at":    deliveredAt,
+			})
+		}
+	}
+
+	return acked, nil
+}
+
+// getMessage 获取消息，ID无效或消息不存在时返回ErrMessageNotFound
+func (s *MessageService) getMessage(messageID string) (*models.Message, error) {
+	if !primitive.IsValidObjectID(messageID) {
+		return nil, ErrMessageNotFound
+	}
+
+	message, err := s.messageRepo.GetMessageByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message == nil {
+		return nil, ErrMessageNotFound
+	}
+	return message, nil
+}
+
+// isRecipient 检查用户是否为消息的接收者
+func (s *MessageService) isRecipient(message *models.Message, userID string) (bool, error) {
+	if message.SenderID == userID {
+		return false, nil
+	}
+	if message.GroupID != "" {
+		return s.isGroupMember(message.GroupID, userID)
+	}
+	return message.ReceiverID == userID, nil
+}
+
 // MarkMessageAsRead 标记消息为已读
 func (s *MessageService) MarkMessageAsRead(messageID string) error {
 	return s.messageRepo.MarkMessageAsRead(messageID)
 }
 
-// MarkAllMessagesAsRead 标记用户之间的所有消息为已读
-func (s *MessageService) MarkAllMessagesAsRead(senderID, receiverID string) error {
-	return s.messageRepo.MarkAllMessagesAsReadBetweenUsers(senderID, receiverID)
-}
-
 // GetUnreadMessageCount 获取用户的未读消息数
 func (s *MessageService) GetUnreadMessageCount(userID string) (int, error) {
 	return s.messageRepo.GetUnreadMessageCount(userID)
 }
 
-// DeleteMessage 删除消息
-func (s *MessageService) DeleteMessage(messageID string) error {
-	return s.messageRepo.DeleteMessage(messageID)
+// SetSearchIndex 设置消息全文索引，必须在开始处理请求之前调用，未设置时不建立索引
+func (s *MessageService) SetSearchIndex(searchIndex models.MessageSearchIndex) {
+	s.searchIndex = searchIndex
+}
+
+// indexMessage 为消息建立全文索引，系统消息不建立索引，失败时只记录日志
+func (s *MessageService) indexMessage(message *models.Message) {
+	if s.searchIndex == nil || message.Type == models.SystemMessage {
+		return
+	}
+	if err := s.searchIndex.IndexMessage(message); err != nil {
+		log.Printf("为消息 %s 建立索引失败: %v", message.ID.Hex(), err)
+	}
 }
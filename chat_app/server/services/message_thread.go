@@ -0,0 +1,168 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+)
+
+// ThreadReplyEvent thread_reply帧的负载，推送给话题的参与者
+type ThreadReplyEvent struct {
+	ThreadID       string          `json:"thread_id"`
+	ConversationID string          `json:"conversation_id"`
+	GroupID        string          `json:"group_id"`
+	ReplyCount     int             `json:"reply_count"`
+	LastReplyAt    *time.Time      `json:"last_reply_at,omitempty"`
+	Message        *models.Message `json:"message"`
+}
+
+// ThreadPage 话题的根消息和一页回复，回复按时间升序排列
+type ThreadPage struct {
+	Root    *models.Message   `json:"root"`
+	Replies []*models.Message `json:"replies"`
+
+	// 最后一条回复之后是否还有更多回复，继续翻页时以最后一条回复的ID作为after
+	HasMore bool `json:"has_more"`
+}
+
+// resolveReply 校验被引用的消息和话题根消息，并用被引用消息的摘要替换客户端提交的引用
+func (s *MessageService) resolveReply(message *models.Message) error {
+	conversationID := message.ConversationKey()
+
+	if message.ReplyTo != nil && message.ReplyTo.MessageID == "" {
+		message.ReplyTo = nil
+	}
+	if message.ReplyTo != nil {
+		quoted, err := s.getMessage(message.ReplyTo.MessageID)
+		if err != nil {
+			return err
+		}
+		if quoted.ConversationKey() != conversationID {
+			return ErrInvalidReply
+		}
+		if quoted.Recalled {
+			return ErrMessageRecalled
+		}
+		message.ReplyTo = quoted.Preview()
+	}
+
+	if message.ThreadID == "" {
+		return nil
+	}
+	if message.GroupID == "" {
+		return ErrThreadNotSupported
+	}
+
+	root, err := s.getMessage(message.ThreadID)
+	if err != nil {
+		return err
+	}
+	if root.ConversationKey() != conversationID || root.Type == models.SystemMessage {
+		return ErrInvalidReply
+	}
+	if root.Recalled {
+		return ErrMessageRecalled
+	}
+
+	// 回复话题中的某条回复时，归入同一个话题
+	if root.ThreadID != "" {
+		message.ThreadID = root.ThreadID
+	}
+	return nil
+}
+
+// recordThreadReply 更新话题根消息的回复数，并通知话题的其他参与者，失败时只记录日志
+func (s *MessageService) recordThreadReply(message *models.Message) {
+	root, err := s.messageRepo.AddThreadReply(message.ThreadID, message.SenderID, message.Timestamp)
+	if err != nil {
+		log.Printf("更新话题 %s 的回复数失败: %v", message.ThreadID, err)
+		return
+	}
+	if root == nil {
+		return
+	}
+
+	memberIDs, err := s.groupMemberIDs(message.GroupID)
+	if err != nil {
+		log.Printf("获取群组 %s 的成员失败: %v", message.GroupID, err)
+		return
+	}
+	isMember := make(map[string]bool, len(memberIDs))
+	for _, memberID := range memberIDs {
+		isMember[memberID] = true
+	}
+
+	event := &ThreadReplyEvent{
+		ThreadID:       message.ThreadID,
+		ConversationID: message.ConversationID,
+		GroupID:        message.GroupID,
+		ReplyCount:     root.ThreadReplyCount,
+		LastReplyAt:    root.ThreadLastReplyAt,
+		Message:        message,
+	}
+
+	// 根消息的发送者总是话题的参与者，已退出群组的参与者不再通知
+	notified := map[string]bool{message.SenderID: true}
+	for _, userID := range append([]string{root.SenderID}, root.ThreadParticipants...) {
+		if notified[userID] || !isMember[userID] {
+			continue
+		}
+		notified[userID] = true
+		s.pushFrame(userID, websocket.FrameThreadReply, event)
+	}
+}
+
+// GetThread 获取话题的根消息和晚于after的回复，after为空时从第一条回复开始
+func (s *MessageService) GetThread(userID, rootID, after string, limit int) (*ThreadPage, error) {
+	root, err := s.getMessage(rootID)
+	if err != nil {
+		return nil, err
+	}
+	conversationID := root.ConversationKey()
+	if err := s.checkConversationAccess(userID, conversationID); err != nil {
+		return nil, err
+	}
+	if root.GroupID == "" || root.ThreadID != "" {
+		return nil, ErrThreadNotSupported
+	}
+
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	var cursor *models.MessageCursor
+	if after != "" {
+		cursor, err = s.resolveMessageCursor(conversationID, after)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	viewer, err := s.messageViewer(userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 多取一条用于判断是否还有更多回复
+	replies, err := s.messageRepo.GetThreadReplies(rootID, cursor, viewer, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ThreadPage{Root: root, Replies: replies}
+	if len(replies) > limit {
+		page.Replies = replies[:limit]
+		page.HasMore = true
+	}
+	if page.Replies == nil {
+		page.Replies = []*models.Message{}
+	}
+	root.SummarizeReactions(userID)
+	summarizeReactions(page.Replies, userID)
+	return page, nil
+}
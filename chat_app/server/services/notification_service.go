@@ -223,6 +223,40 @@ func (s *NotificationService) SendNewContactNotification(
 	return s.sendFCMNotification(token, notification, data)
 }
 
+// 发送群组@通知，被@的成员即使开启了免打扰也会收到
+func (s *NotificationService) SendMentionNotification(
+	receiverID string,
+	senderID string,
+	senderName string,
+	groupID string,
+	messageID string,
+	messageContent string,
+) error {
+	// 获取接收者的FCM令牌
+	token, err := s.GetUserFCMToken(receiverID)
+	if err != nil {
+		return err
+	}
+
+	// 准备通知内容
+	notification := FCMNotification{
+		Title: fmt.Sprintf("%s 在群聊中@了你", senderName),
+		Body:  messageContent,
+	}
+
+	// 准备数据负载
+	data := map[string]string{
+		"type":        "mention",
+		"sender_id":   senderID,
+		"sender_name": senderName,
+		"group_id":    groupID,
+		"message_id":  messageID,
+	}
+
+	// 发送通知
+	return s.sendFCMNotification(token, notification, data)
+}
+
 // 发送FCM通知
 func (s *NotificationService) sendFCMNotification(
 	token string,
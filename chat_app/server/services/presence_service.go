@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"chat_app/server/database"
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+)
+
+const (
+	// 连接记录的有效期，心跳会续期，节点崩溃后残留的连接在此时间后失效
+	presenceTTL = 2 * time.Minute
+
+	// 在线状态事件队列长度
+	presenceEventBuffer = 1024
+)
+
+// 在线状态事件类型
+type presenceEventType int
+
+const (
+	presenceConnected presenceEventType = iota
+	presenceDisconnected
+	presenceHeartbeat
+)
+
+// presenceEvent 连接生命周期事件
+type presenceEvent struct {
+	kind   presenceEventType
+	userID string
+	connID string
+	at     time.Time
+}
+
+// PresenceService 维护用户在线状态并向联系人广播状态变化
+type PresenceService struct {
+	redisDB     *database.RedisDB
+	contactRepo models.ContactRepository
+	privacyRepo models.PrivacyRepository
+	push        *PushService
+	events      chan presenceEvent
+}
+
+// NewPresenceService 创建新的在线状态服务
+func NewPresenceService(
+	redisDB *database.RedisDB,
+	contactRepo models.ContactRepository,
+	privacyRepo models.PrivacyRepository,
+	push *PushService,
+) *PresenceService {
+	return &PresenceService{
+		redisDB:     redisDB,
+		contactRepo: contactRepo,
+		privacyRepo: privacyRepo,
+		push:        push,
+		events:      make(chan presenceEvent, presenceEventBuffer),
+	}
+}
+
+// Run 按顺序处理连接事件，保证同一连接的上线和下线不会乱序
+func (s *PresenceService) Run() {
+	for event := range s.events {
+		s.handleEvent(event)
+	}
+}
+
+// ClientConnected 实现websocket.PresenceTracker
+func (s *PresenceService) ClientConnected(userID, connID string) {
+	s.enqueue(presenceEvent{kind: presenceConnected, userID: userID, connID: connID, at: time.Now()})
+}
+
+// ClientDisconnected 实现websocket.PresenceTracker
+func (s *PresenceService) ClientDisconnected(userID, connID string) {
+	s.enqueue(presenceEvent{kind: presenceDisconnected, userID: userID, connID: connID, at: time.Now()})
+}
+
+// ClientHeartbeat 实现websocket.PresenceTracker
+func (s *PresenceService) ClientHeartbeat(userID, connID string) {
+	s.enqueue(presenceEvent{kind: presenceHeartbeat, userID: userID, connID: connID, at: time.Now()})
+}
+
+// enqueue 将事件放入队列，队列已满时丢弃，残留的连接记录会在过期后自动清理
+func (s *PresenceService) enqueue(event presenceEvent) {
+	select {
+	case s.events <- event:
+	default:
+		log.Printf("在线状态事件队列已满，丢弃用户 %s 的事件", event.userID)
+	}
+}
+
+// handleEvent 更新Redis中的在线状态，状态变化时通知联系人
+func (s *PresenceService) handleEvent(event presenceEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	switch event.kind {
+	case presenceConnected:
+		count, err := s.redisDB.AddUserConnection(ctx, event.userID, event.connID, presenceTTL)
+		if err != nil {
+			log.Printf("记录用户 %s 的连接失败: %v", event.userID, err)
+			return
+		}
+		s.redisDB.SetUserLastSeen(ctx, event.userID, event.at)
+
+		// 第一个连接建立时用户上线
+		if count == 1 {
+			s.broadcastStatus(event.userID, true, event.at)
+		}
+
+	case presenceHeartbeat:
+		if err := s.redisDB.RefreshUserSession(ctx, event.userID); err != nil {
+			log.Printf("刷新用户 %s 会话失败: %v", event.userID, err)
+		}
+		if _, err := s.redisDB.AddUserConnection(ctx, event.userID, event.connID, presenceTTL); err != nil {
+			log.Printf("续期用户 %s 的连接失败: %v", event.userID, err)
+		}
+		s.redisDB.SetUserLastSeen(ctx, event.userID, event.at)
+
+	case presenceDisconnected:
+		count, err := s.redisDB.RemoveUserConnection(ctx, event.userID, event.connID)
+		if err != nil {
+			log.Printf("移除用户 %s 的连接失败: %v", event.userID, err)
+			return
+		}
+		s.redisDB.SetUserLastSeen(ctx, event.userID, event.at)
+
+		// 所有节点上都没有连接时用户下线
+		if count == 0 {
+			s.broadcastStatus(event.userID, false, event.at)
+		}
+	}
+}
+
+// broadcastStatus 向用户的联系人推送user_status帧，遵守用户的隐私设置
+func (s *PresenceService) broadcastStatus(userID string, isOnline bool, lastSeen time.Time) {
+	uid, err := strconv.Atoi(userID)
+	if err != nil {
+		return
+	}
+
+	settings, err := s.privacyRepo.GetPrivacySettings(uid)
+	if err != nil {
+		log.Printf("获取用户 %s 的隐私设置失败: %v", userID, err)
+		return
+	}
+	if settings.PresenceVisibility == models.PresenceVisibleNobody {
+		return
+	}
+
+	contacts, err := s.contactRepo.GetContacts(uid)
+	if err != nil {
+		log.Printf("获取用户 %s 的联系人失败: %v", userID, err)
+		return
+	}
+
+	payload := map[string]interface{}{
+		"user_id":   userID,
+		"is_online": isOnline,
+		"last_seen": lastSeen,
+	}
+	for _, contact := range contacts {
+		if err := s.push.PushFrame(strconv.Itoa(contact.ID), websocket.FrameUserStatus, payload); err != nil {
+			log.Printf("推送用户 %s 的在线状态失败: %v", userID, err)
+		}
+	}
+}
+
+// GetPresence 获取用户的在线状态，对查看者不可见时只返回用户ID
+func (s *PresenceService) GetPresence(viewerID, targetID int) (*models.Presence, error) {
+	if s.redisDB == nil {
+		return nil, errors.New("在线状态服务不可用")
+	}
+
+	presence := &models.Presence{UserID: targetID}
+
+	visible, err := s.canViewPresence(viewerID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if !visible {
+		return presence, nil
+	}
+	presence.Visible = true
+
+	ctx := context.Background()
+	userID := strconv.Itoa(targetID)
+
+	// 在线状态取决于是否还有未过期的连接，节点崩溃后残留的连接在presenceTTL后不再算作在线
+	presence.IsOnline, err = s.redisDB.HasUserConnections(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	lastSeen, err := s.redisDB.GetUserLastSeen(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !lastSeen.IsZero() {
+		presence.LastSeen = &lastSeen
+	}
+
+	return presence, nil
+}
+
+// canViewPresence 检查查看者是否可以看到目标用户的在线状态
+func (s *PresenceService) canViewPresence(viewerID, targetID int) (bool, error) {
+	if viewerID == targetID {
+		return true, nil
+	}
+
+	settings, err := s.privacyRepo.GetPrivacySettings(targetID)
+	if err != nil {
+		return false, err
+	}
+
+	switch settings.PresenceVisibility {
+	case models.PresenceVisibleEveryone:
+		return true, nil
+	case models.PresenceVisibleNobody:
+		return false, nil
+	default:
+		return s.contactRepo.IsContact(targetID, viewerID)
+	}
+}
+
+// GetPrivacySettings 获取用户的隐私设置
+func (s *PresenceService) GetPrivacySettings(userID int) (*models.PrivacySettings, error) {
+	return s.privacyRepo.GetPrivacySettings(userID)
+}
+
+// UpdatePresenceVisibility 更新用户在线状态的可见范围
+func (s *PresenceService) UpdatePresenceVisibility(userID int, visibility models.PresenceVisibility) (*models.PrivacySettings, error) {
+	switch visibility {
+	case models.PresenceVisibleEveryone, models.PresenceVisibleContacts, models.PresenceVisibleNobody:
+	default:
+		return nil, errors.New("无效的可见范围")
+	}
+
+	settings := &models.PrivacySettings{
+		UserID:             userID,
+		PresenceVisibility: visibility,
+	}
+	if err := s.privacyRepo.SavePrivacySettings(settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
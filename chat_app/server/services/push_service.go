@@ -0,0 +1,71 @@
+package services
+
+import (
+	"log"
+	"strings"
+
+	"chat_app/server/database"
+	"chat_app/server/websocket"
+
+	"github.com/nats-io/nats.go"
+)
+
+// 按用户投递WebSocket帧的NATS主题前缀
+const userDeliverySubjectPrefix = "deliver.user."
+
+// PushService 将WebSocket帧推送给用户，无论用户连接在哪个节点
+type PushService struct {
+	natsDB *database.NATSDB
+	wsHub  *websocket.Hub
+	sub    *nats.Subscription
+}
+
+// NewPushService 创建新的推送服务
+func NewPushService(natsDB *database.NATSDB, wsHub *websocket.Hub) *PushService {
+	return &PushService{
+		natsDB: natsDB,
+		wsHub:  wsHub,
+	}
+}
+
+// Start 订阅按用户投递的主题，将帧转发给本节点上的连接
+func (s *PushService) Start() error {
+	if s.natsDB == nil {
+		return nil
+	}
+
+	sub, err := s.natsDB.SubscribeToMessages(userDeliverySubjectPrefix+"*", func(msg *nats.Msg) {
+		userID := strings.TrimPrefix(msg.Subject, userDeliverySubjectPrefix)
+		s.wsHub.SendToUser(userID, msg.Data)
+	})
+	if err != nil {
+		return err
+	}
+	s.sub = sub
+	return nil
+}
+
+// Stop 取消订阅
+func (s *PushService) Stop() {
+	if s.sub == nil {
+		return
+	}
+	if err := s.sub.Unsubscribe(); err != nil {
+		log.Printf("取消订阅 %s 失败: %v", s.sub.Subject, err)
+	}
+	s.sub = nil
+}
+
+// PushFrame 向用户的所有连接推送一帧；NATS不可用时只推送给本节点的连接
+func (s *PushService) PushFrame(userID, frameType string, payload interface{}) error {
+	frame, err := websocket.NewFrame(frameType, payload)
+	if err != nil {
+		return err
+	}
+
+	if s.natsDB == nil {
+		s.wsHub.SendToUser(userID, frame)
+		return nil
+	}
+	return s.natsDB.PublishMessage(userDeliverySubjectPrefix+userID, frame)
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+)
+
+// 一次已读回执最多统计的群组消息数，读者一次读完大量消息时只统计最新的部分
+const maxReceiptMessages = 100
+
+// ReadReceipt 已读回执，推送给消息发送者和读者的其他设备
+type ReadReceipt struct {
+	ConversationID string `json:"conversation_id"`
+	ReaderID       string `json:"reader_id"`
+
+	// 私聊中被标记为已读的消息的发送者，兼容旧的回执格式
+	SenderID string `json:"sender_id,omitempty"`
+
+	// 读者已读到的序列号，不大于该序列号的消息均已读
+	LastReadSeq int64     `json:"last_read_seq"`
+	ReadAt      time.Time `json:"read_at"`
+
+	// 群组消息的最新已读人数，只包含推送对象发送的消息
+	Messages []*MessageReadCount `json:"messages,omitempty"`
+}
+
+// MessageReadCount 群组消息的已读和未读人数，不包括发送者
+type MessageReadCount struct {
+	MessageID   string `json:"message_id"`
+	Seq         int64  `json:"seq"`
+	ReadCount   int    `json:"read_count"`
+	UnreadCount int    `json:"unread_count"`
+}
+
+// MessageReader 消息的一个接收者
+// LastReadAt 是接收者最近一次前移已读位置的时间，已读位置可能在读到这条消息之后又前移过，所以不是这条消息的已读时间
+type MessageReader struct {
+	UserID     string     `json:"user_id"`
+	Username   string     `json:"username,omitempty"`
+	AvatarURL  string     `json:"avatar_url,omitempty"`
+	LastReadAt *time.Time `json:"last_read_at,omitempty"`
+}
+
+// MessageReaders 消息的已读和未读成员列表
+type MessageReaders struct {
+	MessageID      string           `json:"message_id"`
+	ConversationID string           `json:"conversation_id"`
+	ReadCount      int              `json:"read_count"`
+	UnreadCount    int              `json:"unread_count"`
+	ReadMembers    []*MessageReader `json:"read_members"`
+	UnreadMembers  []*MessageReader `json:"unread_members"`
+}
+
+// MarkConversationRead 将用户在会话中的已读位置前移到seq，seq不大于0时标记会话中的所有消息为已读
+// 已读位置前移时向消息发送者推送read_receipt帧
+func (s *MessageService) MarkConversationRead(userID, conversationID string, seq int64) (*ReadReceipt, error) {
+	if err := s.checkConversationAccess(userID, conversationID); err != nil {
+		return nil, err
+	}
+	ref, err := models.ParseConversationID(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := &ReadReceipt{
+		ConversationID: conversationID,
+		ReaderID:       userID,
+		ReadAt:         time.Now(),
+	}
+	if ref.Type == models.PrivateConversation {
+		receipt.SenderID = ref.Peer(userID)
+	}
+
+	last, err := s.messageRepo.GetLastMessage(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return receipt, nil
+	}
+	if seq <= 0 || seq > last.Seq {
+		seq = last.Seq
+	}
+	receipt.LastReadSeq = seq
+
+	previousSeq, advanced, err := s.readStateRepo.AdvanceReadState(conversationID, userID, seq, receipt.ReadAt)
+	if err != nil {
+		return nil, err
+	}
+	if !advanced {
+		return receipt, nil
+	}
+
+	// 读到最新消息时同时更新旧的已读标记，未读数统计仍依赖该标记
+	if ref.Type == models.PrivateConversation && seq == last.Seq {
+		if err := s.messageRepo.MarkAllMessagesAsReadBetweenUsers(receipt.SenderID, userID); err != nil {
+			log.Printf("标记会话 %s 的消息为已读失败: %v", conversationID, err)
+		}
+	}
+
+	s.notifyReadReceipt(ref, receipt, previousSeq)
+	return receipt, nil
+}
+
+// GetReadState 获取用户在会话中的已读位置
+func (s *MessageService) GetReadState(userID, conversationID string) (*models.ReadState, error) {
+	if err := s.checkConversationAccess(userID, conversationID); err != nil {
+		return nil, err
+	}
+
+	state, err := s.readStateRepo.GetReadState(conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		state = &models.ReadState{ConversationID: conversationID, UserID: userID}
+	}
+	return state, nil
+}
+
+// GetMessageReaders 返回消息的已读和未读成员，只有消息的发送者可以查看
+func (s *MessageService) GetMessageReaders(userID, messageID string) (*MessageReaders, error) {
+	message, err := s.getMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.SenderID != userID {
+		return nil, ErrNotMessageSender
+	}
+
+	conversationID := message.ConversationKey()
+	recipients, err := s.messageRecipients(message)
+	if err != nil {
+		return nil, err
+	}
+
+	states, err := s.readStateRepo.GetReadStates(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	lastReadAt := make(map[string]time.Time, len(states))
+	for _, state := range states {
+		if state.LastReadSeq >= message.Seq {
+			lastReadAt[state.UserID] = state.UpdatedAt
+		}
+	}
+
+	readers := &MessageReaders{
+		MessageID:      messageID,
+		ConversationID: conversationID,
+		ReadMembers:    []*MessageReader{},
+		UnreadMembers:  []*MessageReader{},
+	}
+	for _, recipient := range recipients {
+		// 没有序列号的旧消息只能依据已读标记判断
+		at, ok := lastReadAt[recipient.UserID]
+		if message.Seq == 0 {
+			ok = message.Read
+		}
+		if ok {
+			if !at.IsZero() {
+				recipient.LastReadAt = &at
+			}
+			readers.ReadMembers = append(readers.ReadMembers, recipient)
+		} else {
+			readers.UnreadMembers = append(readers.UnreadMembers, recipient)
+		}
+	}
+	readers.ReadCount = len(readers.ReadMembers)
+	readers.UnreadCount = len(readers.UnreadMembers)
+
+	return readers, nil
+}
+
+// messageRecipients 返回消息的接收者，群组消息为除发送者外的当前成员
+func (s *MessageService) messageRecipients(message *models.Message) ([]*MessageReader, error) {
+	if message.GroupID == "" {
+		return []*MessageReader{{UserID: message.ReceiverID}}, nil
+	}
+
+	groupID, err := strconv.Atoi(message.GroupID)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.groupMemberRepo.GetMembers(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := make([]*MessageReader, 0, len(members))
+	for _, member := range members {
+		memberID := strconv.Itoa(member.ID)
+		if memberID == message.SenderID {
+			continue
+		}
+		recipients = append(recipients, &MessageReader{
+			UserID:    memberID,
+			Username:  member.Username,
+			AvatarURL: member.AvatarURL,
+		})
+	}
+	return recipients, nil
+}
+
+// notifyReadReceipt 将已读回执推送给消息发送者，并同步给读者的其他设备
+func (s *MessageService) notifyReadReceipt(ref *models.ConversationRef, receipt *ReadReceipt, previousSeq int64) {
+	if ref.Type == models.PrivateConversation {
+		s.pushFrame(receipt.SenderID, websocket.FrameReadReceipt, receipt)
+	} else {
+		s.notifyGroupReadCounts(ref, receipt, previousSeq)
+	}
+	s.pushFrame(receipt.ReaderID, websocket.FrameReadReceipt, receipt)
+}
+
+// notifyGroupReadCounts 向本次新读到的群组消息的发送者推送这些消息的最新已读人数
+func (s *MessageService) notifyGroupReadCounts(ref *models.ConversationRef, receipt *ReadReceipt, previousSeq int64) {
+	fromSeq := previousSeq
+	if receipt.LastReadSeq-fromSeq > maxReceiptMessages {
+		fromSeq = receipt.LastReadSeq - maxReceiptMessages
+	}
+
+	messages, err := s.messageRepo.GetMessagesSince(receipt.ConversationID, fromSeq, nil, int(receipt.LastReadSeq-fromSeq))
+	if err != nil {
+		log.Printf("获取会话 %s 的已读消息失败: %v", receipt.ConversationID, err)
+		return
+	}
+
+	memberIDs, err := s.groupMemberIDs(ref.GroupID)
+	if err != nil {
+		log.Printf("获取群组 %s 的成员失败: %v", ref.GroupID, err)
+		return
+	}
+	states, err := s.readStateRepo.GetReadStates(receipt.ConversationID)
+	if err != nil {
+		log.Printf("获取会话 %s 的已读位置失败: %v", receipt.ConversationID, err)
+		return
+	}
+
+	// 只统计仍在群组中的成员
+	isMember := make(map[string]bool, len(memberIDs))
+	for _, memberID := range memberIDs {
+		isMember[memberID] = true
+	}
+	lastRead := make(map[string]int64, len(states))
+	for _, state := range states {
+		if isMember[state.UserID] {
+			lastRead[state.UserID] = state.LastReadSeq
+		}
+	}
+
+	// 按发送者分组，每个发送者只收到自己消息的已读人数
+	countsBySender := make(map[string][]*MessageReadCount)
+	for _, message := range messages {
+		if message.SenderID == receipt.ReaderID || message.Seq > receipt.LastReadSeq {
+			continue
+		}
+
+		count := &MessageReadCount{MessageID: message.ID.Hex(), Seq: message.Seq}
+		for _, memberID := range memberIDs {
+			if memberID == message.SenderID {
+				continue
+			}
+			if lastRead[memberID] >= message.Seq {
+				count.ReadCount++
+			} else {
+				count.UnreadCount++
+			}
+		}
+		countsBySender[message.SenderID] = append(countsBySender[message.SenderID], count)
+	}
+
+	for senderID, counts := range countsBySender {
+		senderReceipt := *receipt
+		senderReceipt.Messages = counts
+		s.pushFrame(senderID, websocket.FrameReadReceipt, &senderReceipt)
+	}
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"chat_app/server/models"
+	"chat_app/server/utils"
+)
+
+var (
+	// ErrInvalidSearchQuery 搜索词为空或过长
+	ErrInvalidSearchQuery = errors.New("搜索词不能为空且不能超过100个字符")
+
+	// ErrSearchUnavailable 没有配置消息全文索引
+	ErrSearchUnavailable = errors.New("消息搜索不可用")
+)
+
+const (
+	// 搜索词的最大字符数
+	maxSearchQueryRunes = 100
+
+	// 搜索结果默认每页返回的消息数
+	defaultSearchLimit = 20
+
+	// 搜索结果每页最多返回的消息数
+	maxSearchLimit = 50
+
+	// 高亮摘要的最大字符数，以及第一个匹配位置之前保留的字符数
+	snippetMaxRunes     = 80
+	snippetContextRunes = 20
+)
+
+// SearchQuery 消息搜索请求
+type SearchQuery struct {
+	Text string
+
+	// 可选的过滤条件，会话可以是会话ID或客户端的聊天ID
+	ConversationID string
+	SenderID       string
+	Type           models.MessageType
+	Since          *time.Time
+	Until          *time.Time
+
+	// 上一页返回的游标
+	Before string
+	Limit  int
+}
+
+// HighlightRange 摘要中匹配关键词的位置，按字符计算，左闭右开
+type HighlightRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// SearchHit 一条搜索结果
+type SearchHit struct {
+	Message *models.Message `json:"message"`
+
+	// 消息内容中包含匹配位置的片段，以及片段中需要高亮的位置
+	Snippet    string           `json:"snippet"`
+	Highlights []HighlightRange `json:"highlights"`
+}
+
+// SearchResult 一页搜索结果，按时间倒序排列
+type SearchResult struct {
+	Hits []*SearchHit `json:"hits"`
+
+	// 下一页的游标，没有更多结果时为空
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// SearchService 处理消息搜索，只搜索用户参与的私聊和所在群组的消息
+type SearchService struct {
+	searchIndex      models.MessageSearchIndex
+	conversationRepo models.ConversationRepository
+	groupRepo        models.GroupRepository
+}
+
+// NewSearchService 创建新的消息搜索服务，searchIndex为nil时搜索不可用
+func NewSearchService(
+	searchIndex models.MessageSearchIndex,
+	conversationRepo models.ConversationRepository,
+	groupRepo models.GroupRepository,
+) *SearchService {
+	return &SearchService{
+		searchIndex:      searchIndex,
+		conversationRepo: conversationRepo,
+		groupRepo:        groupRepo,
+	}
+}
+
+// Search 搜索用户可以访问的消息，结果中不包括用户删除或清空的消息
+func (s *SearchService) Search(userID string, query *SearchQuery) (*SearchResult, error) {
+	if s.searchIndex == nil {
+		return nil, ErrSearchUnavailable
+	}
+
+	text := strings.TrimSpace(query.Text)
+	if text == "" || utf8.RuneCountInString(text) > maxSearchQueryRunes {
+		return nil, ErrInvalidSearchQuery
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	indexQuery := &models.MessageSearchQuery{
+		Text:     text,
+		UserID:   userID,
+		SenderID: query.SenderID,
+		Type:     query.Type,
+		Since:    query.Since,
+		Until:    query.Until,
+		Limit:    limit + 1,
+	}
+	if query.Before != "" {
+		cursor, err := decodeMessageCursor(query.Before)
+		if err != nil {
+			return nil, err
+		}
+		indexQuery.Cursor = cursor
+	}
+
+	uid, err := strconv.Atoi(userID)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.groupRepo.GetGroupsByMember(uid)
+	if err != nil {
+		return nil, err
+	}
+	indexQuery.GroupIDs = make([]string, 0, len(groups))
+	for _, group := range groups {
+		indexQuery.GroupIDs = append(indexQuery.GroupIDs, strconv.Itoa(group.ID))
+	}
+
+	if query.ConversationID != "" {
+		conversationID, err := s.resolveConversation(userID, query.ConversationID, indexQuery.GroupIDs)
+		if err != nil {
+			return nil, err
+		}
+		indexQuery.ConversationID = conversationID
+	}
+
+	settingsList, err := s.conversationRepo.GetSettingsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, settings := range settingsList {
+		if settings.ClearedAt == nil {
+			continue
+		}
+		if indexQuery.ClearedAt == nil {
+			indexQuery.ClearedAt = make(map[string]time.Time)
+		}
+		indexQuery.ClearedAt[settings.ConversationID] = *settings.ClearedAt
+	}
+
+	messages, err := s.searchIndex.Search(indexQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SearchResult{Hits: make([]*SearchHit, 0, len(messages))}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		result.NextCursor = encodeMessageCursor(messages[limit-1].Cursor())
+	}
+
+	terms := utils.SearchTerms(text)
+	for _, message := range messages {
+		message.SummarizeReactions(userID)
+		hit := &SearchHit{Message: message}
+		hit.Snippet, hit.Highlights = highlight(message.Content, terms)
+		result.Hits = append(result.Hits, hit)
+	}
+	return result, nil
+}
+
+// resolveConversation 将会话ID或聊天ID转换为会话ID，并检查用户是否参与该会话
+func (s *SearchService) resolveConversation(userID, id string, groupIDs []string) (string, error) {
+	conversationID, err := models.ConversationIDFromChatID(userID, id)
+	if err != nil {
+		conversationID = id
+	}
+
+	ref, err := models.ParseConversationID(conversationID)
+	if err != nil {
+		return "", err
+	}
+	if ref.Type == models.PrivateConversation {
+		if !ref.HasParticipant(userID) {
+			return "", ErrNotParticipant
+		}
+		return conversationID, nil
+	}
+
+	for _, groupID := range groupIDs {
+		if groupID == ref.GroupID {
+			return conversationID, nil
+		}
+	}
+	return "", ErrNotParticipant
+}
+
+// highlight 截取内容中第一个匹配位置附近的片段，并返回片段中所有关键词的位置
+func highlight(content string, terms []string) (string, []HighlightRange) {
+	runes := []rune(content)
+	folded := utils.FoldCase(content)
+
+	var ranges []HighlightRange
+	first := -1
+	for _, term := range terms {
+		termRunes := []rune(term)
+		for i := 0; i+len(termRunes) <= len(folded); i++ {
+			if string(folded[i:i+len(termRunes)]) != term {
+				continue
+			}
+			ranges = append(ranges, HighlightRange{Start: i, End: i + len(termRunes)})
+			if first < 0 || i < first {
+				first = i
+			}
+		}
+	}
+
+	start := 0
+	if first > snippetContextRunes {
+		start = first - snippetContextRunes
+	}
+	end := start + snippetMaxRunes
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	highlights := []HighlightRange{}
+	for _, r := range ranges {
+		if r.Start < start || r.End > end {
+			continue
+		}
+		highlights = append(highlights, HighlightRange{Start: r.Start - start, End: r.End - start})
+	}
+	sort.Slice(highlights, func(i, j int) bool {
+		return highlights[i].Start < highlights[j].Start
+	})
+
+	return string(runes[start:end]), highlights
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"chat_app/server/models"
+	"chat_app/server/websocket"
+)
+
+const (
+	// 同一用户在同一会话中转发正在输入状态的最小间隔
+	typingThrottle = 2 * time.Second
+
+	// 没有收到新的输入状态时，正在输入状态自动结束的时间
+	typingTTL = 6 * time.Second
+)
+
+// TypingEvent 推送给会话其他参与者的typing帧负载
+type TypingEvent struct {
+	UserID         string `json:"user_id"`
+	ConversationID string `json:"conversation_id"`
+	ReceiverID     string `json:"receiver_id,omitempty"`
+	GroupID        string `json:"group_id,omitempty"`
+	IsTyping       bool   `json:"is_typing"`
+
+	// 正在输入状态的有效秒数，客户端超过该时间没有收到新的帧时应隐藏提示
+	ExpiresIn int `json:"expires_in,omitempty"`
+}
+
+// typingState 一个用户在一个会话中的正在输入状态
+type typingState struct {
+	event    TypingEvent
+	lastSent time.Time
+	timer    *time.Timer
+}
+
+// typingTracker 记录本节点上用户的正在输入状态，用于节流和自动过期
+type typingTracker struct {
+	states map[string]*typingState
+	mu     sync.Mutex
+}
+
+// newTypingTracker 创建正在输入状态跟踪器
+func newTypingTracker() *typingTracker {
+	return &typingTracker{
+		states: make(map[string]*typingState),
+	}
+}
+
+// typingKey 返回用户在会话中的状态键
+func typingKey(userID, conversationID string) string {
+	return userID + "|" + conversationID
+}
+
+// StartTyping 记录用户正在输入，并转发给会话的其他参与者
+// 在节流间隔内重复的输入状态只会延长过期时间，不会再次转发
+func (s *MessageService) StartTyping(event TypingEvent) error {
+	if err := s.resolveTypingTarget(&event); err != nil {
+		return err
+	}
+	event.IsTyping = true
+	event.ExpiresIn = int(typingTTL.Seconds())
+
+	key := typingKey(event.UserID, event.ConversationID)
+	now := time.Now()
+
+	s.typing.mu.Lock()
+	state, ok := s.typing.states[key]
+	if !ok {
+		state = &typingState{event: event}
+		state.timer = time.AfterFunc(typingTTL, func() {
+			s.expireTyping(key, state)
+		})
+		s.typing.states[key] = state
+	} else {
+		state.timer.Reset(typingTTL)
+	}
+	forward := now.Sub(state.lastSent) >= typingThrottle
+	if forward {
+		state.lastSent = now
+	}
+	s.typing.mu.Unlock()
+
+	if forward {
+		s.pushTyping(event)
+	}
+	return nil
+}
+
+// StopTyping 结束用户在会话中的正在输入状态，并通知会话的其他参与者
+func (s *MessageService) StopTyping(userID, conversationID string) {
+	key := typingKey(userID, conversationID)
+
+	s.typing.mu.Lock()
+	state, ok := s.typing.states[key]
+	if ok {
+		state.timer.Stop()
+		delete(s.typing.states, key)
+	}
+	s.typing.mu.Unlock()
+
+	if ok {
+		event := state.event
+		event.IsTyping = false
+		event.ExpiresIn = 0
+		s.pushTyping(event)
+	}
+}
+
+// expireTyping 在超时后结束正在输入状态，状态已被替换或清除时不做任何操作
+func (s *MessageService) expireTyping(key string, state *typingState) {
+	s.typing.mu.Lock()
+	if s.typing.states[key] != state {
+		s.typing.mu.Unlock()
+		return
+	}
+	delete(s.typing.states, key)
+	s.typing.mu.Unlock()
+
+	event := state.event
+	event.IsTyping = false
+	event.ExpiresIn = 0
+	s.pushTyping(event)
+}
+
+// resolveTypingTarget 校验正在输入状态的目标并填充会话ID
+func (s *MessageService) resolveTypingTarget(event *TypingEvent) error {
+	if event.GroupID != "" {
+		isMember, err := s.isGroupMember(event.GroupID, event.UserID)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return ErrNotGroupMember
+		}
+		event.ReceiverID = ""
+		event.ConversationID = models.GroupConversationID(event.GroupID)
+		return nil
+	}
+
+	if event.ReceiverID == "" || event.ReceiverID == event.UserID {
+		return ErrMissingRecipient
+	}
+	event.ConversationID = models.PrivateConversationID(event.UserID, event.ReceiverID)
+	return nil
+}
+
+// pushTyping 将typing帧推送给私聊对方或除自己外的所有群组成员
+func (s *MessageService) pushTyping(event TypingEvent) {
+	if event.GroupID == "" {
+		s.pushFrame(event.ReceiverID, websocket.FrameTyping, &event)
+		return
+	}
+
+	memberIDs, err := s.groupMemberIDs(event.GroupID)
+	if err != nil {
+		log.Printf("获取群组 %s 的成员失败: %v", event.GroupID, err)
+		return
+	}
+	for _, memberID := range memberIDs {
+		if memberID != event.UserID {
+			s.pushFrame(memberID, websocket.FrameTyping, &event)
+		}
+	}
+}
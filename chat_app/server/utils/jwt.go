@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"time"
 
@@ -18,6 +21,22 @@ const (
 // 密钥（实际应用中应从环境变量或配置文件中获取）
 var jwtSecret = []byte("chat_app_secret_key")
 
+// ErrTokenRevoked 令牌已被吊销
+var ErrTokenRevoked = errors.New("令牌已被吊销")
+
+// TokenRevocationStore 查询令牌是否已被吊销
+type TokenRevocationStore interface {
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+// 令牌吊销存储，未设置时不检查吊销状态
+var revocationStore TokenRevocationStore
+
+// SetTokenRevocationStore 设置令牌吊销存储
+func SetTokenRevocationStore(store TokenRevocationStore) {
+	revocationStore = store
+}
+
 // Claims JWT声明
 type Claims struct {
 	UserID int `json:"user_id"`
@@ -29,10 +48,17 @@ func GenerateToken(userID int) (string, error) {
 	// 设置过期时间（7天）
 	expirationTime := time.Now().Add(7 * 24 * time.Hour)
 
+	// 生成令牌ID，用于吊销
+	tokenID, err := GenerateRandomString(16)
+	if err != nil {
+		return "", err
+	}
+
 	// 创建声明
 	claims := &Claims{
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
@@ -62,9 +88,33 @@ func ParseToken(tokenString string) (*Claims, error) {
 	}
 
 	// 验证令牌
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("无效的令牌")
+	}
+
+	// 检查令牌是否已被吊销
+	if revocationStore != nil && claims.ID != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		revoked, err := revocationStore.IsTokenRevoked(ctx, claims.ID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
 	}
 
-	return nil, errors.New("无效的令牌")
+	return claims, nil
+}
+
+// GenerateRandomString 生成指定字节数的随机十六进制字符串
+func GenerateRandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
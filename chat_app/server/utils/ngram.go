@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	// 单条文本最多生成的索引词元数，超长消息只索引前面的部分
+	maxIndexTokens = 2000
+
+	// 字母和数字组成的单词最多索引的前缀长度，更长的搜索词按该长度的前缀查询
+	maxWordPrefixRunes = 16
+)
+
+// IndexTokens 将文本切分为建立全文索引使用的词元
+// 连续的汉字、假名和谚文生成单字和相邻两字的词元，
+// 其他字母和数字按单词切分并转为小写，每个单词生成完整单词和所有前缀的词元，搜索单词的开头部分也能匹配
+func IndexTokens(text string) []string {
+	return tokenize(text, true)
+}
+
+// QueryTokens 将搜索词切分为查询词元，与IndexTokens生成的词元匹配
+// 连续的汉字只使用相邻两字的词元，只有一个汉字时使用单字词元；超长的单词只使用前缀
+func QueryTokens(text string) []string {
+	return tokenize(text, false)
+}
+
+// tokenize 切分文本并去除重复的词元，index为true时生成索引词元，否则生成查询词元
+func tokenize(text string, index bool) []string {
+	var tokens []string
+	seen := make(map[string]bool)
+	add := func(token string) {
+		if len(tokens) >= maxIndexTokens || seen[token] {
+			return
+		}
+		seen[token] = true
+		tokens = append(tokens, token)
+	}
+
+	var word []rune
+	var ideographs []rune
+	flushWord := func() {
+		if len(word) == 0 {
+			return
+		}
+		if index {
+			for n := 1; n < len(word) && n <= maxWordPrefixRunes; n++ {
+				add(string(word[:n]))
+			}
+			add(string(word))
+		} else if len(word) > maxWordPrefixRunes {
+			add(string(word[:maxWordPrefixRunes]))
+		} else {
+			add(string(word))
+		}
+		word = word[:0]
+	}
+	flushIdeographs := func() {
+		if len(ideographs) == 1 || (index && len(ideographs) > 0) {
+			for _, r := range ideographs {
+				add(string(r))
+			}
+		}
+		for i := 0; i+1 < len(ideographs); i++ {
+			add(string(ideographs[i : i+2]))
+		}
+		ideographs = ideographs[:0]
+	}
+
+	for _, r := range text {
+		switch {
+		case isIdeograph(r):
+			flushWord()
+			ideographs = append(ideographs, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushIdeographs()
+			word = append(word, unicode.ToLower(r))
+		default:
+			flushWord()
+			flushIdeographs()
+		}
+	}
+	flushWord()
+	flushIdeographs()
+
+	return tokens
+}
+
+// isIdeograph 检查字符是否属于没有空格分词的文字
+func isIdeograph(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// FoldCase 将文本逐字符转为小写，与原文本的字符位置一一对应
+func FoldCase(text string) []rune {
+	runes := []rune(text)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+// SearchTerms 将搜索词按空白切分为需要同时匹配的关键词，并转为小写
+func SearchTerms(query string) []string {
+	fields := strings.Fields(query)
+	terms := make([]string, 0, len(fields))
+	for _, field := range fields {
+		terms = append(terms, string(FoldCase(field)))
+	}
+	return terms
+}
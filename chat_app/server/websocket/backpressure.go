@@ -0,0 +1,88 @@
+package websocket
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// SlowConsumerPolicy 连接发送缓冲区已满时的处理策略
+type SlowConsumerPolicy string
+
+const (
+	// PolicyDropOldest 丢弃缓冲区中最旧的一帧，为新帧腾出空间，连接保持打开
+	PolicyDropOldest SlowConsumerPolicy = "drop_oldest"
+
+	// PolicyDisconnect 丢弃新帧并断开连接，客户端重连后通过增量同步补齐消息
+	PolicyDisconnect SlowConsumerPolicy = "disconnect"
+
+	// PolicySpill 将新帧写入离线队列并断开连接，设备重连之前发给它的帧也写入离线队列，
+	// 客户端用同一device_id重连后收到队列中的帧；没有提供device_id的连接按disconnect处理
+	PolicySpill SlowConsumerPolicy = "spill"
+)
+
+// DefaultSlowConsumerPolicy 未配置时使用的策略，与最初的行为一致
+const DefaultSlowConsumerPolicy = PolicyDisconnect
+
+// ParseSlowConsumerPolicy 解析配置中的策略名称，为空时返回默认策略
+func ParseSlowConsumerPolicy(name string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(name); policy {
+	case "":
+		return DefaultSlowConsumerPolicy, nil
+	case PolicyDropOldest, PolicyDisconnect, PolicySpill:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("未知的慢连接策略: %s", name)
+	}
+}
+
+// OfflineQueue 保存因连接过慢而未能发送的帧，按用户和设备区分
+type OfflineQueue interface {
+	// SpillFrame 保存一帧
+	SpillFrame(userID, deviceID string, frame []byte) error
+
+	// DrainFrames 取出并删除设备的所有帧，按保存顺序返回
+	DrainFrames(userID, deviceID string) ([][]byte, error)
+}
+
+// HubStats hub的连接数和帧计数
+type HubStats struct {
+	Connections int                `json:"connections"`
+	Policy      SlowConsumerPolicy `json:"slow_consumer_policy"`
+
+	// 成功放入发送缓冲区的帧数
+	FramesSent uint64 `json:"frames_sent"`
+
+	// 因缓冲区已满被丢弃的帧数，包括drop_oldest丢弃的旧帧
+	FramesDropped uint64 `json:"frames_dropped"`
+
+	// 写入离线队列的帧数
+	FramesSpilled uint64 `json:"frames_spilled"`
+
+	// 因过慢被断开的连接数
+	SlowDisconnects uint64 `json:"slow_disconnects"`
+}
+
+// hubMetrics hub的帧计数器，可并发更新
+type hubMetrics struct {
+	framesSent      atomic.Uint64
+	framesDropped   atomic.Uint64
+	framesSpilled   atomic.Uint64
+	slowDisconnects atomic.Uint64
+}
+
+// enqueueResult 向连接发送缓冲区写入一帧的结果
+type enqueueResult int
+
+const (
+	// 帧已放入缓冲区
+	enqueueSent enqueueResult = iota
+
+	// 丢弃了最旧的一帧后放入缓冲区
+	enqueueDroppedOldest
+
+	// 缓冲区已满，帧未放入
+	enqueueFull
+
+	// 连接已关闭
+	enqueueClosed
+)
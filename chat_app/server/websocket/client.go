@@ -3,8 +3,11 @@ package websocket
 import (
 	"bytes"
 	"log"
+	"sync"
 	"time"
 
+	"chat_app/server/utils"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -41,17 +44,42 @@ type Client struct {
 	// 缓冲的发送消息通道
 	send chan []byte
 
+	// 连接ID，在所有节点中唯一
+	id string
+
 	// 用户ID
 	userID string
+
+	// 设备会话信息
+	session SessionInfo
+
+	// 认证令牌的ID，令牌被吊销后hub会关闭连接，旧令牌没有ID
+	tokenID string
+
+	// 认证令牌的过期时间，到期后hub会关闭连接
+	expiresAt time.Time
+
+	// hub关闭连接时发送给对等方的关闭帧，为空时发送默认关闭帧
+	closeMessage []byte
+
+	// 保护send通道的写入和关闭，closed之后不再写入
+	mu     sync.Mutex
+	closed bool
 }
 
 // NewClient 创建一个新的客户端
-func NewClient(hub *Hub, conn *websocket.Conn, userID string) *Client {
+func NewClient(hub *Hub, conn *websocket.Conn, userID string, session SessionInfo, tokenID string, expiresAt time.Time) *Client {
+	id, _ := utils.GenerateRandomString(16)
+
 	return &Client{
-		hub:    hub,
-		conn:   conn,
-		send:   make(chan []byte, sendBufferSize),
-		userID: userID,
+		id:        id,
+		hub:       hub,
+		conn:      conn,
+		send:      make(chan []byte, sendBufferSize),
+		userID:    userID,
+		session:   session,
+		tokenID:   tokenID,
+		expiresAt: expiresAt,
 	}
 }
 
@@ -66,6 +94,7 @@ func (c *Client) ReadPump() {
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.hub.heartbeat(c)
 		return nil
 	})
 
@@ -79,20 +108,83 @@ func (c *Client) ReadPump() {
 		}
 		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
 
-		// 打印接收到的消息
-		log.Printf("从用户 %s 接收到消息: %s", c.userID, string(message))
+		// 按帧类型分发消息
+		c.hub.dispatcher.Dispatch(c, message)
+	}
+}
 
-		// 检查是否是ping消息，如果是则回复pong
-		if bytes.Contains(message, []byte(`"type":"ping"`)) {
-			log.Printf("收到ping消息，发送pong回复")
-			pongMessage := []byte(`{"type":"pong","timestamp":"` + time.Now().Format(time.RFC3339) + `"}`)
-			c.send <- pongMessage
-			continue
-		}
+// UserID 返回连接所属的用户ID
+func (c *Client) UserID() string {
+	return c.userID
+}
+
+// Session 返回连接的设备会话信息
+func (c *Client) Session() SessionInfo {
+	return c.session
+}
+
+// Send 向客户端发送一帧，缓冲区已满时按hub的慢连接策略处理，帧未能发送时返回false
+func (c *Client) Send(message []byte) bool {
+	return c.hub.deliver(c, message)
+}
+
+// enqueue 将一帧放入发送缓冲区，不会阻塞
+// 策略为drop_oldest时，缓冲区已满会先丢弃最旧的一帧
+func (c *Client) enqueue(message []byte, policy SlowConsumerPolicy) enqueueResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return enqueueClosed
+	}
 
-		// 广播消息
-		c.hub.broadcast <- message
+	select {
+	case c.send <- message:
+		return enqueueSent
+	default:
 	}
+
+	if policy != PolicyDropOldest {
+		return enqueueFull
+	}
+
+	// 写协程可能同时取走了一帧，此时无需丢弃
+	select {
+	case <-c.send:
+	default:
+	}
+
+	// 持有c.mu时没有其他写入者，腾出空间后一定能放入
+	c.send <- message
+	return enqueueDroppedOldest
+}
+
+// close 关闭发送通道，写协程发送完缓冲区中的帧后发送closeMessage并关闭连接
+// 重复调用时不做任何操作
+func (c *Client) close(closeMessage []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	c.closed = true
+	c.closeMessage = closeMessage
+	close(c.send)
+	return true
+}
+
+// sendError 向客户端回复error帧
+func (c *Client) sendError(id string, protoErr *ProtocolError) {
+	frame, err := newReplyFrame(FrameError, id, &ErrorPayload{
+		Code:    protoErr.Code,
+		Message: protoErr.Message,
+	})
+	if err != nil {
+		log.Printf("编码error帧失败: %v", err)
+		return
+	}
+	c.Send(frame)
 }
 
 // writePump 将消息从hub泵送到WebSocket连接
@@ -109,24 +201,16 @@ func (c *Client) WritePump() {
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// hub关闭了通道
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				closeMessage := c.closeMessage
+				if closeMessage == nil {
+					closeMessage = []byte{}
+				}
+				c.conn.WriteMessage(websocket.CloseMessage, closeMessage)
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// 添加排队的聊天消息
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write(newline)
-				w.Write(<-c.send)
-			}
-
-			if err := w.Close(); err != nil {
+			// 每一帧单独写入一个WebSocket消息，客户端按帧解析JSON
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -0,0 +1,103 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// FrameHandler 处理一种类型的客户端帧，每个请求帧最多只回复一帧，处理器不直接向客户端回复
+// 返回*Reply时由分发器发送该回复帧，其他返回值在客户端请求确认时作为ack帧的负载
+type FrameHandler func(client *Client, env *Envelope) (interface{}, error)
+
+// Reply 处理器返回的回复帧，无论客户端是否请求确认都会发送，并代替ack帧
+type Reply struct {
+	Type    string
+	Payload interface{}
+}
+
+// Dispatcher 按帧类型将客户端帧路由到注册的处理器
+type Dispatcher struct {
+	handlers map[string]FrameHandler
+	mu       sync.RWMutex
+}
+
+// NewDispatcher 创建一个新的帧分发器，内置ping处理器
+func NewDispatcher() *Dispatcher {
+	d := &Dispatcher{
+		handlers: make(map[string]FrameHandler),
+	}
+	d.Register(FramePing, handlePing)
+	return d
+}
+
+// Register 注册帧处理器，同一类型重复注册时覆盖旧的处理器
+func (d *Dispatcher) Register(frameType string, handler FrameHandler) {
+	d.mu.Lock()
+	d.handlers[frameType] = handler
+	d.mu.Unlock()
+}
+
+// Dispatch 解析客户端帧并调用对应的处理器，失败时向客户端回复error帧
+func (d *Dispatcher) Dispatch(client *Client, data []byte) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		client.sendError("", NewProtocolError(ErrCodeMalformedFrame, "无法解析帧: "+err.Error()))
+		return
+	}
+	if env.Type == "" {
+		client.sendError(env.ID, NewProtocolError(ErrCodeMalformedFrame, "缺少帧类型"))
+		return
+	}
+	if env.Version != 0 && env.Version != ProtocolVersion {
+		client.sendError(env.ID, NewProtocolError(ErrCodeUnsupportedVersion, "不支持的协议版本"))
+		return
+	}
+
+	// 旧客户端直接发送扁平的JSON对象，此时将整个帧作为负载
+	if len(env.Payload) == 0 {
+		env.Payload = json.RawMessage(data)
+	}
+
+	d.mu.RLock()
+	handler, ok := d.handlers[env.Type]
+	d.mu.RUnlock()
+
+	if !ok {
+		client.sendError(env.ID, NewProtocolError(ErrCodeUnknownType, "未知的帧类型: "+env.Type))
+		return
+	}
+
+	result, err := handler(client, &env)
+	if err != nil {
+		var protoErr *ProtocolError
+		if !errors.As(err, &protoErr) {
+			log.Printf("处理用户 %s 的 %s 帧失败: %v", client.userID, env.Type, err)
+			protoErr = NewProtocolError(ErrCodeInternal, "服务器内部错误")
+		}
+		client.sendError(env.ID, protoErr)
+		return
+	}
+
+	frameType, payload := FrameAck, result
+	if reply, ok := result.(*Reply); ok {
+		frameType, payload = reply.Type, reply.Payload
+	} else if !env.Ack {
+		return
+	}
+
+	frame, err := newReplyFrame(frameType, env.ID, payload)
+	if err != nil {
+		log.Printf("编码 %s 帧失败: %v", frameType, err)
+		return
+	}
+	client.Send(frame)
+}
+
+// handlePing 记录心跳并回复pong帧
+func handlePing(client *Client, env *Envelope) (interface{}, error) {
+	client.hub.heartbeat(client)
+	return &Reply{Type: FramePong, Payload: &PongPayload{Timestamp: time.Now()}}, nil
+}
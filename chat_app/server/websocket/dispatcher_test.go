@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// replies 取出客户端发送缓冲区中已有的所有帧
+func replies(t *testing.T, client *Client) []*Envelope {
+	t.Helper()
+
+	var envs []*Envelope
+	for {
+		select {
+		case data := <-client.send:
+			var env Envelope
+			if err := json.Unmarshal(data, &env); err != nil {
+				t.Fatalf("解析回复帧失败: %v", err)
+			}
+			envs = append(envs, &env)
+		default:
+			return envs
+		}
+	}
+}
+
+func TestDispatcherRepliesOncePerFrame(t *testing.T) {
+	hub := newTestHub(PolicyDropOldest, nil)
+	hub.RegisterHandler("echo", func(client *Client, env *Envelope) (interface{}, error) {
+		return map[string]string{"echo": "ok"}, nil
+	})
+	hub.RegisterHandler("query", func(client *Client, env *Envelope) (interface{}, error) {
+		return &Reply{Type: "result", Payload: map[string]int{"count": 1}}, nil
+	})
+
+	tests := []struct {
+		name  string
+		frame string
+		want  string
+	}{
+		{name: "ping", frame: `{"v":1,"type":"ping","id":"1"}`, want: FramePong},
+		{name: "ping with ack", frame: `{"v":1,"type":"ping","id":"2","ack":true}`, want: FramePong},
+		{name: "ack requested", frame: `{"v":1,"type":"echo","id":"3","ack":true}`, want: FrameAck},
+		{name: "no ack", frame: `{"v":1,"type":"echo","id":"4"}`},
+		{name: "reply with ack", frame: `{"v":1,"type":"query","id":"5","ack":true}`, want: "result"},
+		{name: "unknown type", frame: `{"v":1,"type":"missing","id":"6","ack":true}`, want: FrameError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(hub, "1", "device")
+			hub.dispatcher.Dispatch(client, []byte(tt.frame))
+
+			got := replies(t, client)
+			if tt.want == "" {
+				if len(got) != 0 {
+					t.Fatalf("收到 %d 帧, 期望没有回复", len(got))
+				}
+				return
+			}
+			if len(got) != 1 {
+				t.Fatalf("收到 %d 帧, 期望 1 帧", len(got))
+			}
+
+			var sent Envelope
+			json.Unmarshal([]byte(tt.frame), &sent)
+			if got[0].Type != tt.want || got[0].ID != sent.ID {
+				t.Errorf("收到 %s 帧 (id=%s), 期望 %s 帧 (id=%s)", got[0].Type, got[0].ID, tt.want, sent.ID)
+			}
+			if len(got[0].Payload) == 0 {
+				t.Errorf("%s 帧没有负载", got[0].Type)
+			}
+		})
+	}
+}
@@ -1,12 +1,33 @@
 package websocket
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
-	
+	"strconv"
+	"strings"
+	"time"
+
+	"chat_app/server/database"
+	"chat_app/server/utils"
+
+	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// 一次性连接票据的有效期
+	ticketTTL = 30 * time.Second
+
+	// 通过Sec-WebSocket-Protocol携带令牌时使用的子协议名，下一项为令牌本身
+	bearerSubprotocol = "bearer"
+
+	// 设备ID和平台名称的最大长度
+	maxDeviceFieldLength = 64
+)
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -18,40 +39,279 @@ var upgrader = websocket.Upgrader{
 
 // Handler 处理WebSocket连接
 type Handler struct {
-	hub *Hub
+	hub     *Hub
+	redisDB *database.RedisDB
 }
 
 // NewHandler 创建一个新的WebSocket处理器
-func NewHandler(hub *Hub) *Handler {
-	return &Handler{hub: hub}
+func NewHandler(hub *Hub, redisDB *database.RedisDB) *Handler {
+	return &Handler{hub: hub, redisDB: redisDB}
+}
+
+// connAuth 握手阶段认证得到的连接身份
+type connAuth struct {
+	userID      int
+	tokenID     string
+	expiresAt   time.Time
+	subprotocol string
 }
 
 // HandleWebSocket 处理WebSocket连接请求
 func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// 从请求中获取用户ID
-	userID := r.URL.Query().Get("user_id")
-	if userID == "" {
-		log.Println("WebSocket连接请求缺少user_id参数")
-		http.Error(w, "缺少user_id参数", http.StatusBadRequest)
+	// 认证连接，用户身份只从令牌或票据中获取
+	auth, err := h.authenticate(r)
+	if err != nil {
+		log.Println("WebSocket连接认证失败:", err)
+		http.Error(w, "未授权", http.StatusUnauthorized)
 		return
 	}
-	
+
+	// 如果通过子协议携带令牌，必须回应所选子协议，否则浏览器会拒绝连接
+	var responseHeader http.Header
+	if auth.subprotocol != "" {
+		responseHeader = http.Header{"Sec-WebSocket-Protocol": {auth.subprotocol}}
+	}
+
 	// 升级HTTP连接为WebSocket连接
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Println("升级为WebSocket连接失败:", err)
 		return
 	}
-	
+
+	userID := strconv.Itoa(auth.userID)
+
 	// 创建客户端
-	client := NewClient(h.hub, conn, userID)
-	
+	client := NewClient(h.hub, conn, userID, sessionFromRequest(r), auth.tokenID, auth.expiresAt)
+
 	// 注册客户端
 	client.hub.register <- client
-	
+
+	// 认证之后、注册之前吊销的令牌不会被hub收到的吊销通知关闭，注册后再检查一次
+	h.closeIfRevoked(auth.tokenID)
+
 	// 启动goroutine处理消息
 	go client.WritePump()
 	go client.ReadPump()
-	
+
 	log.Printf("用户 %s 的WebSocket连接已建立", userID)
-} 
\ No newline at end of file
+}
+
+// ListSessions 列出当前用户在线的设备会话
+func (h *Handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(utils.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.hub.Sessions(strconv.Itoa(userID)))
+}
+
+// KickSession 将当前用户的指定设备下线
+func (h *Handler) KickSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(utils.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	deviceID := mux.Vars(r)["device_id"]
+	if !h.hub.KickSession(strconv.Itoa(userID), deviceID) {
+		http.Error(w, "设备不在线", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Stats 返回本节点的连接数和帧计数，只在内部监听地址上提供
+func (h *Handler) Stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.hub.Stats())
+}
+
+// IssueTicket 签发一次性WebSocket连接票据
+// 浏览器无法为WebSocket握手设置请求头，客户端可先用令牌换取短期票据，再通过?ticket=连接
+func (h *Handler) IssueTicket(w http.ResponseWriter, r *http.Request) {
+	if h.redisDB == nil {
+		http.Error(w, "票据服务不可用", http.StatusServiceUnavailable)
+		return
+	}
+
+	tokenString := bearerToken(r.Header.Get("Authorization"))
+	if tokenString == "" {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := utils.ParseToken(tokenString)
+	if err != nil {
+		http.Error(w, "无效的令牌", http.StatusUnauthorized)
+		return
+	}
+
+	ticket, err := utils.GenerateRandomString(32)
+	if err != nil {
+		http.Error(w, "生成票据失败", http.StatusInternalServerError)
+		return
+	}
+
+	data := &database.WSTicket{
+		UserID:  claims.UserID,
+		TokenID: claims.ID,
+	}
+	if claims.ExpiresAt != nil {
+		data.ExpiresAt = claims.ExpiresAt.Time
+	}
+
+	err = h.redisDB.StoreWSTicket(r.Context(), ticket, data, ticketTTL)
+	if err != nil {
+		http.Error(w, "保存票据失败", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ticket":     ticket,
+		"expires_in": int(ticketTTL.Seconds()),
+	})
+}
+
+// authenticate 按顺序尝试一次性票据、Authorization头、子协议和token查询参数
+func (h *Handler) authenticate(r *http.Request) (*connAuth, error) {
+	query := r.URL.Query()
+
+	if ticket := query.Get("ticket"); ticket != "" {
+		return h.authenticateTicket(r.Context(), ticket)
+	}
+
+	if tokenString := bearerToken(r.Header.Get("Authorization")); tokenString != "" {
+		return authenticateToken(tokenString, "")
+	}
+
+	if tokenString, ok := subprotocolToken(r); ok {
+		return authenticateToken(tokenString, bearerSubprotocol)
+	}
+
+	// 兼容现有客户端通过查询参数携带令牌的方式
+	if tokenString := query.Get("token"); tokenString != "" {
+		return authenticateToken(tokenString, "")
+	}
+
+	return nil, errors.New("缺少认证信息")
+}
+
+// authenticateTicket 使用一次性票据认证
+func (h *Handler) authenticateTicket(ctx context.Context, ticket string) (*connAuth, error) {
+	if h.redisDB == nil {
+		return nil, errors.New("票据服务不可用")
+	}
+
+	data, err := h.redisDB.ConsumeWSTicket(ctx, ticket)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, errors.New("票据无效或已使用")
+	}
+
+	if !data.ExpiresAt.IsZero() && time.Now().After(data.ExpiresAt) {
+		return nil, errors.New("令牌已过期")
+	}
+
+	// 票据签发后令牌可能已被吊销
+	if data.TokenID != "" {
+		revoked, err := h.redisDB.IsTokenRevoked(ctx, data.TokenID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, utils.ErrTokenRevoked
+		}
+	}
+
+	return &connAuth{userID: data.UserID, tokenID: data.TokenID, expiresAt: data.ExpiresAt}, nil
+}
+
+// authenticateToken 使用JWT令牌认证，ParseToken会拒绝过期和已吊销的令牌
+func authenticateToken(tokenString, subprotocol string) (*connAuth, error) {
+	claims, err := utils.ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &connAuth{userID: claims.UserID, tokenID: claims.ID, subprotocol: subprotocol}
+	if claims.ExpiresAt != nil {
+		auth.expiresAt = claims.ExpiresAt.Time
+	}
+	return auth, nil
+}
+
+// closeIfRevoked 令牌已被吊销时关闭使用它的连接
+func (h *Handler) closeIfRevoked(tokenID string) {
+	if tokenID == "" || h.redisDB == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), revocationCheckTimeout)
+	defer cancel()
+
+	revoked, err := h.redisDB.IsTokenRevoked(ctx, tokenID)
+	if err != nil {
+		log.Printf("检查令牌吊销状态失败: %v", err)
+		return
+	}
+	if revoked {
+		h.hub.CloseToken(tokenID)
+	}
+}
+
+// sessionFromRequest 从握手请求中读取设备ID和平台，未提供设备ID时生成一个随机ID
+// 随机ID在每次连接时都不同，这样的设备不使用离线队列
+func sessionFromRequest(r *http.Request) SessionInfo {
+	query := r.URL.Query()
+
+	deviceID := truncate(query.Get("device_id"), maxDeviceFieldLength)
+	stableDevice := deviceID != ""
+	if !stableDevice {
+		deviceID, _ = utils.GenerateRandomString(8)
+	}
+
+	platform := truncate(query.Get("platform"), maxDeviceFieldLength)
+	if platform == "" {
+		platform = "unknown"
+	}
+
+	return SessionInfo{
+		DeviceID:     deviceID,
+		Platform:     platform,
+		ConnectedAt:  time.Now(),
+		stableDevice: stableDevice,
+	}
+}
+
+// truncate 将字符串截断到最多n个字节
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// bearerToken 从Authorization头中提取令牌
+func bearerToken(header string) string {
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// subprotocolToken 从Sec-WebSocket-Protocol中提取令牌，格式为 "bearer, <token>"
+func subprotocolToken(r *http.Request) (string, bool) {
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == bearerSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1], true
+		}
+	}
+	return "", false
+}
@@ -3,15 +3,48 @@ package websocket
 import (
 	"log"
 	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
+// 检查连接令牌是否过期的周期
+const tokenCheckPeriod = 30 * time.Second
+
+// SessionInfo 描述用户的一个设备会话
+type SessionInfo struct {
+	DeviceID    string    `json:"device_id"`
+	Platform    string    `json:"platform"`
+	ConnectedAt time.Time `json:"connected_at"`
+
+	// 设备ID是否由客户端提供，服务端随机生成的设备ID在重连后会变化，不能用于离线队列
+	stableDevice bool
+}
+
+// PresenceTracker 接收连接生命周期事件，用于维护用户在线状态
+// 方法在hub的处理循环或读协程中调用，实现不能阻塞
+type PresenceTracker interface {
+	// ClientConnected 用户建立了一个连接
+	ClientConnected(userID, connID string)
+
+	// ClientDisconnected 用户断开了一个连接
+	ClientDisconnected(userID, connID string)
+
+	// ClientHeartbeat 连接收到心跳
+	ClientHeartbeat(userID, connID string)
+}
+
 // Hub 维护活跃的客户端连接集合，并广播消息
+//
+// 并发模型：mu只保护连接映射，向连接发送帧时不持有mu，
+// send通道的写入和关闭由每个连接自己的锁保护，因此不会重复关闭通道。
+// 需要移除连接时先释放读锁再获取写锁，移除操作是幂等的。
 type Hub struct {
 	// 注册的客户端
 	clients map[*Client]bool
 
-	// 通过用户ID索引客户端
-	userClients map[string]*Client
+	// 通过用户ID索引客户端，每个用户可以在多个设备上同时连接
+	userClients map[string]map[*Client]bool
 
 	// 从客户端接收的入站消息
 	broadcast chan []byte
@@ -22,6 +55,32 @@ type Hub struct {
 	// 注销请求
 	unregister chan *Client
 
+	// 被吊销的令牌ID，使用这些令牌的连接会被关闭
+	revoke chan string
+
+	// 客户端帧分发器
+	dispatcher *Dispatcher
+
+	// 在线状态跟踪器，可以为空
+	presence PresenceTracker
+
+	// 发送缓冲区已满时的处理策略
+	policy SlowConsumerPolicy
+
+	// 慢连接的离线队列，为空时spill策略退化为disconnect
+	offline OfflineQueue
+
+	// 因过慢被断开、帧需要写入离线队列的设备，按用户ID和设备ID索引，值为停止写入的时间
+	// 设备重新连接或到期后移除，由mu保护
+	spilling map[string]map[string]time.Time
+
+	// 写入离线队列的帧与重连设备读取离线队列之间的屏障
+	// 写入时持有读锁，重连的设备读取队列前获取一次写锁，等待之前开始的写入完成
+	spillMu sync.RWMutex
+
+	// 帧计数
+	metrics hubMetrics
+
 	// 互斥锁保护映射
 	mu sync.RWMutex
 }
@@ -32,78 +91,416 @@ func NewHub() *Hub {
 		broadcast:   make(chan []byte),
 		register:    make(chan *Client),
 		unregister:  make(chan *Client),
+		revoke:      make(chan string),
 		clients:     make(map[*Client]bool),
-		userClients: make(map[string]*Client),
+		userClients: make(map[string]map[*Client]bool),
+		spilling:    make(map[string]map[string]time.Time),
+		dispatcher:  NewDispatcher(),
+		policy:      DefaultSlowConsumerPolicy,
 		mu:          sync.RWMutex{},
 	}
 }
 
 // Run 启动Hub处理循环
 func (h *Hub) Run() {
+	ticker := time.NewTicker(tokenCheckPeriod)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client] = true
-			if client.userID != "" {
-				h.userClients[client.userID] = client
-			}
-			h.mu.Unlock()
-			log.Printf("新客户端连接。当前连接数: %d", len(h.clients))
+			h.registerClient(client)
 
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				if client.userID != "" {
-					delete(h.userClients, client.userID)
-				}
-				close(client.send)
-			}
+			h.removeClientLocked(client, nil)
 			h.mu.Unlock()
-			log.Printf("客户端断开连接。当前连接数: %d", len(h.clients))
+			log.Printf("客户端断开连接。当前连接数: %d", h.clientCount())
+
+		case tokenID := <-h.revoke:
+			h.closeTokenClients(tokenID)
 
 		case message := <-h.broadcast:
 			h.mu.RLock()
+			clients := make([]*Client, 0, len(h.clients))
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					h.mu.RUnlock()
-					h.mu.Lock()
-					delete(h.clients, client)
-					if client.userID != "" {
-						delete(h.userClients, client.userID)
-					}
-					h.mu.Unlock()
-					h.mu.RLock()
-				}
+				clients = append(clients, client)
 			}
 			h.mu.RUnlock()
+
+			for _, client := range clients {
+				h.deliver(client, message)
+			}
+
+		case now := <-ticker.C:
+			h.closeExpiredClients(now)
+			h.pruneSpilling(now)
 		}
 	}
 }
 
-// SendToUser 发送消息给特定用户
-func (h *Hub) SendToUser(userID string, message []byte) bool {
+// registerClient 注册客户端，同一设备重复连接时关闭旧连接
+func (h *Hub) registerClient(client *Client) {
+	h.mu.Lock()
+	var others []*Client
+	for existing := range h.userClients[client.userID] {
+		if existing.session.DeviceID == client.session.DeviceID {
+			h.removeClientLocked(existing, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "该设备已重新连接"))
+			continue
+		}
+		others = append(others, existing)
+	}
+	spilled := h.stopSpillingLocked(client.userID, client.session.DeviceID)
+
+	h.clients[client] = true
+	if client.userID != "" {
+		if h.userClients[client.userID] == nil {
+			h.userClients[client.userID] = make(map[*Client]bool)
+		}
+		h.userClients[client.userID][client] = true
+	}
+	h.mu.Unlock()
+
+	log.Printf("新客户端连接。用户: %s 设备: %s 当前连接数: %d", client.userID, client.session.DeviceID, h.clientCount())
+
+	if h.presence != nil {
+		h.presence.ClientConnected(client.userID, client.id)
+	}
+
+	// 通知用户的其他设备有新设备登录
+	h.notifySessionChange(others, "device_login", client.session)
+
+	// 补发该设备断开期间写入离线队列的帧
+	if spilled && h.offline != nil {
+		go h.drainOfflineQueue(client)
+	}
+}
+
+// drainOfflineQueue 将离线队列中的帧发送给重新连接的设备
+func (h *Hub) drainOfflineQueue(client *Client) {
+	// 等待注册之前开始的写入完成，之后的帧会直接发送给新连接
+	h.spillMu.Lock()
+	h.spillMu.Unlock()
+
+	frames, err := h.offline.DrainFrames(client.userID, client.session.DeviceID)
+	if err != nil {
+		log.Printf("读取用户 %s 设备 %s 的离线队列失败: %v", client.userID, client.session.DeviceID, err)
+		return
+	}
+
+	for _, frame := range frames {
+		if !h.deliver(client, frame) {
+			return
+		}
+	}
+}
+
+// removeClient 移除客户端并关闭其发送通道，调用方不能持有锁
+func (h *Hub) removeClient(client *Client, closeMessage []byte) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.removeClientLocked(client, closeMessage)
+}
+
+// removeClientLocked 移除客户端并关闭其发送通道，调用方必须持有写锁
+// 客户端已被移除时不做任何操作
+func (h *Hub) removeClientLocked(client *Client, closeMessage []byte) bool {
+	if _, ok := h.clients[client]; !ok {
+		return false
+	}
+
+	delete(h.clients, client)
+	if sessions, ok := h.userClients[client.userID]; ok {
+		delete(sessions, client)
+		if len(sessions) == 0 {
+			delete(h.userClients, client.userID)
+		}
+	}
+	client.close(closeMessage)
+
+	if h.presence != nil {
+		h.presence.ClientDisconnected(client.userID, client.id)
+	}
+	return true
+}
+
+// heartbeat 记录客户端心跳
+func (h *Hub) heartbeat(client *Client) {
+	if h.presence != nil {
+		h.presence.ClientHeartbeat(client.userID, client.id)
+	}
+}
+
+// closeExpiredClients 关闭认证令牌已过期的连接
+func (h *Hub) closeExpiredClients(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.clients {
+		if client.expiresAt.IsZero() || now.Before(client.expiresAt) {
+			continue
+		}
+
+		h.removeClientLocked(client, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "令牌已过期"))
+		log.Printf("用户 %s 的令牌已过期，关闭WebSocket连接", client.userID)
+	}
+}
+
+// CloseToken 关闭使用指定令牌认证的所有连接
+// 关闭在hub的处理循环中执行，在此之前提交注册的连接也会被关闭
+func (h *Hub) CloseToken(tokenID string) {
+	if tokenID == "" {
+		return
+	}
+	h.revoke <- tokenID
+}
+
+// closeTokenClients 关闭使用指定令牌认证的所有连接，返回关闭的连接数
+func (h *Hub) closeTokenClients(tokenID string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	closed := 0
+	for client := range h.clients {
+		if client.tokenID != tokenID {
+			continue
+		}
+		if h.removeClientLocked(client, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "令牌已被吊销")) {
+			closed++
+			log.Printf("用户 %s 的令牌已被吊销，关闭设备 %s 的WebSocket连接", client.userID, client.session.DeviceID)
+		}
+	}
+	return closed
+}
+
+// clientCount 返回当前连接数
+func (h *Hub) clientCount() int {
 	h.mu.RLock()
-	client, ok := h.userClients[userID]
-	h.mu.RUnlock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
 
+// SetPresenceTracker 设置在线状态跟踪器，必须在Run之前调用
+func (h *Hub) SetPresenceTracker(tracker PresenceTracker) {
+	h.presence = tracker
+}
+
+// SetSlowConsumerPolicy 设置慢连接策略，必须在Run之前调用
+func (h *Hub) SetSlowConsumerPolicy(policy SlowConsumerPolicy) {
+	h.policy = policy
+}
+
+// SetOfflineQueue 设置spill策略使用的离线队列，必须在Run之前调用
+func (h *Hub) SetOfflineQueue(queue OfflineQueue) {
+	h.offline = queue
+}
+
+// Stats 返回当前连接数和帧计数
+func (h *Hub) Stats() HubStats {
+	return HubStats{
+		Connections:     h.clientCount(),
+		Policy:          h.policy,
+		FramesSent:      h.metrics.framesSent.Load(),
+		FramesDropped:   h.metrics.framesDropped.Load(),
+		FramesSpilled:   h.metrics.framesSpilled.Load(),
+		SlowDisconnects: h.metrics.slowDisconnects.Load(),
+	}
+}
+
+// deliver 向一个连接发送一帧，缓冲区已满时按慢连接策略处理，调用方不能持有锁
+func (h *Hub) deliver(client *Client, message []byte) bool {
+	switch client.enqueue(message, h.policy) {
+	case enqueueSent:
+		h.metrics.framesSent.Add(1)
+		return true
+
+	case enqueueDroppedOldest:
+		h.metrics.framesSent.Add(1)
+		h.metrics.framesDropped.Add(1)
+		return true
+
+	case enqueueFull:
+		h.disconnectSlowClient(client, message)
+		return false
+	}
+
+	return false
+}
+
+// disconnectSlowClient 断开发送缓冲区已满的连接
+// spill策略下将帧写入离线队列，并在设备重新连接或离线队列过期之前继续写入发给该设备的帧
+// 客户端没有提供设备ID时无法在重连后找到离线队列，按disconnect策略处理
+func (h *Hub) disconnectSlowClient(client *Client, message []byte) {
+	spill := h.policy == PolicySpill && h.offline != nil && client.session.stableDevice
+
+	// 在移除连接之前持有屏障的读锁，设备立即重连时也会等待这一帧写入完成后再读取离线队列
+	h.spillMu.RLock()
+	h.mu.Lock()
+	removed := h.removeClientLocked(client, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "连接过慢"))
+	if removed && spill {
+		h.startSpillingLocked(client.userID, client.session.DeviceID, time.Now().Add(offlineQueueTTL))
+	}
+	if spill {
+		// 连接已被其他原因移除时只有设备仍在写入离线队列才保存这一帧
+		_, spill = h.spilling[client.userID][client.session.DeviceID]
+	}
+	h.mu.Unlock()
+
+	if spill {
+		h.spill(client.userID, client.session.DeviceID, message)
+	} else {
+		h.metrics.framesDropped.Add(1)
+	}
+	h.spillMu.RUnlock()
+
+	if removed {
+		h.metrics.slowDisconnects.Add(1)
+		log.Printf("用户 %s 设备 %s 的发送缓冲区已满，断开连接", client.userID, client.session.DeviceID)
+	}
+}
+
+// spill 将一帧写入设备的离线队列，调用方必须持有spillMu的读锁
+func (h *Hub) spill(userID, deviceID string, message []byte) {
+	if err := h.offline.SpillFrame(userID, deviceID, message); err != nil {
+		h.metrics.framesDropped.Add(1)
+		log.Printf("写入用户 %s 设备 %s 的离线队列失败: %v", userID, deviceID, err)
+		return
+	}
+	h.metrics.framesSpilled.Add(1)
+}
+
+// startSpillingLocked 开始将发给设备的帧写入离线队列，直到until，调用方必须持有写锁
+func (h *Hub) startSpillingLocked(userID, deviceID string, until time.Time) {
+	if h.spilling[userID] == nil {
+		h.spilling[userID] = make(map[string]time.Time)
+	}
+	h.spilling[userID][deviceID] = until
+}
+
+// stopSpillingLocked 停止向设备的离线队列写入帧，设备之前处于写入状态时返回true，调用方必须持有写锁
+func (h *Hub) stopSpillingLocked(userID, deviceID string) bool {
+	devices, ok := h.spilling[userID]
 	if !ok {
 		return false
 	}
+	if _, ok := devices[deviceID]; !ok {
+		return false
+	}
 
-	select {
-	case client.send <- message:
-		return true
-	default:
-		h.mu.Lock()
-		delete(h.clients, client)
-		delete(h.userClients, userID)
-		close(client.send)
-		h.mu.Unlock()
+	delete(devices, deviceID)
+	if len(devices) == 0 {
+		delete(h.spilling, userID)
+	}
+	return true
+}
+
+// pruneSpilling 移除离线队列已过期的设备，之后发给这些设备的帧直接丢弃
+func (h *Hub) pruneSpilling(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for userID, devices := range h.spilling {
+		for deviceID, until := range devices {
+			if now.Before(until) {
+				continue
+			}
+			h.stopSpillingLocked(userID, deviceID)
+		}
+	}
+}
+
+// RegisterHandler 注册客户端帧处理器
+func (h *Hub) RegisterHandler(frameType string, handler FrameHandler) {
+	h.dispatcher.Register(frameType, handler)
+}
+
+// SendToUser 发送消息给用户的所有设备，至少一个设备收到时返回true
+// spill策略下，因过慢被断开的设备在重新连接之前收到的帧写入离线队列，不计为已收到
+func (h *Hub) SendToUser(userID string, message []byte) bool {
+	// 在获取连接快照之前持有屏障的读锁，保证重连设备读取离线队列时不会遗漏本次写入的帧
+	h.spillMu.RLock()
+	now := time.Now()
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.userClients[userID]))
+	for client := range h.userClients[userID] {
+		clients = append(clients, client)
+	}
+	var spillDevices []string
+	for deviceID, until := range h.spilling[userID] {
+		if now.Before(until) {
+			spillDevices = append(spillDevices, deviceID)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, deviceID := range spillDevices {
+		h.spill(userID, deviceID, message)
+	}
+	h.spillMu.RUnlock()
+
+	delivered := false
+	for _, client := range clients {
+		if h.deliver(client, message) {
+			delivered = true
+		}
+	}
+	return delivered
+}
+
+// Sessions 返回用户当前在线的设备会话
+func (h *Hub) Sessions(userID string) []SessionInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	sessions := make([]SessionInfo, 0, len(h.userClients[userID]))
+	for client := range h.userClients[userID] {
+		sessions = append(sessions, client.session)
+	}
+	return sessions
+}
+
+// KickSession 断开用户在指定设备上的连接，设备不在线时返回false
+func (h *Hub) KickSession(userID, deviceID string) bool {
+	h.mu.Lock()
+	var kicked *Client
+	var others []*Client
+	for client := range h.userClients[userID] {
+		if kicked == nil && client.session.DeviceID == deviceID {
+			kicked = client
+			continue
+		}
+		others = append(others, client)
+	}
+	if kicked != nil {
+		h.removeClientLocked(kicked, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "已在其他设备上被下线"))
+	}
+	h.mu.Unlock()
+
+	if kicked == nil {
 		return false
 	}
-} 
\ No newline at end of file
+
+	log.Printf("用户 %s 的设备 %s 已被下线", userID, deviceID)
+	h.notifySessionChange(others, "device_logout", kicked.session)
+	return true
+}
+
+// notifySessionChange 向用户的其他设备推送会话变化的system帧
+func (h *Hub) notifySessionChange(clients []*Client, event string, session SessionInfo) {
+	if len(clients) == 0 {
+		return
+	}
+
+	frame, err := NewFrame(FrameSystem, map[string]interface{}{
+		"event":   event,
+		"session": session,
+	})
+	if err != nil {
+		log.Printf("编码会话变化帧失败: %v", err)
+		return
+	}
+
+	for _, client := range clients {
+		client.Send(frame)
+	}
+}
@@ -0,0 +1,405 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryOfflineQueue 保存在内存中的离线队列
+type memoryOfflineQueue struct {
+	mu     sync.Mutex
+	frames map[string][][]byte
+	err    error
+}
+
+func newMemoryOfflineQueue() *memoryOfflineQueue {
+	return &memoryOfflineQueue{frames: make(map[string][][]byte)}
+}
+
+func (q *memoryOfflineQueue) SpillFrame(userID, deviceID string, frame []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.err != nil {
+		return q.err
+	}
+	key := userID + ":" + deviceID
+	q.frames[key] = append(q.frames[key], frame)
+	return nil
+}
+
+func (q *memoryOfflineQueue) DrainFrames(userID, deviceID string) ([][]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := userID + ":" + deviceID
+	frames := q.frames[key]
+	delete(q.frames, key)
+	return frames, nil
+}
+
+func (q *memoryOfflineQueue) len(userID, deviceID string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.frames[userID+":"+deviceID])
+}
+
+func newTestHub(policy SlowConsumerPolicy, queue OfflineQueue) *Hub {
+	hub := NewHub()
+	hub.SetSlowConsumerPolicy(policy)
+	if queue != nil {
+		hub.SetOfflineQueue(queue)
+	}
+	return hub
+}
+
+// newTestClient 创建没有WebSocket连接的客户端，测试直接读取send通道
+func newTestClient(hub *Hub, userID, deviceID string) *Client {
+	return NewClient(hub, nil, userID, SessionInfo{
+		DeviceID:     deviceID,
+		Platform:     "test",
+		ConnectedAt:  time.Now(),
+		stableDevice: true,
+	}, "", time.Time{})
+}
+
+// drain 读取send通道直到被关闭，返回读到的所有帧
+func drain(client *Client) [][]byte {
+	var frames [][]byte
+	for frame := range client.send {
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// fillBuffer 填满客户端的发送缓冲区
+func fillBuffer(t *testing.T, hub *Hub, userID string) {
+	t.Helper()
+	for i := 0; i < sendBufferSize; i++ {
+		if !hub.SendToUser(userID, []byte("fill")) {
+			t.Fatalf("第 %d 帧未能放入缓冲区", i)
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHubConcurrentClients(t *testing.T) {
+	for _, policy := range []SlowConsumerPolicy{PolicyDropOldest, PolicyDisconnect, PolicySpill} {
+		t.Run(string(policy), func(t *testing.T) {
+			hub := newTestHub(policy, newMemoryOfflineQueue())
+			go hub.Run()
+
+			const users = 8
+			const devices = 4
+			const frames = 2 * sendBufferSize
+
+			var clients sync.WaitGroup
+			var senders sync.WaitGroup
+			for u := 0; u < users; u++ {
+				userID := strconv.Itoa(u)
+				for d := 0; d < devices; d++ {
+					client := newTestClient(hub, userID, fmt.Sprintf("device-%d", d))
+					slow := d%2 == 0
+
+					clients.Add(1)
+					go func() {
+						defer clients.Done()
+						hub.register <- client
+
+						// 一半的设备在发送过程中主动断开，与慢连接断开并发
+						received := 0
+						for range client.send {
+							received++
+							if slow {
+								time.Sleep(10 * time.Microsecond)
+							} else if received == 16 {
+								hub.unregister <- client
+							}
+						}
+					}()
+				}
+			}
+			waitFor(t, "连接全部注册", func() bool { return hub.clientCount() == users*devices })
+
+			for u := 0; u < users; u++ {
+				userID := strconv.Itoa(u)
+				senders.Add(1)
+				go func() {
+					defer senders.Done()
+					for i := 0; i < frames; i++ {
+						hub.SendToUser(userID, []byte(strconv.Itoa(i)))
+						if i%64 == 0 {
+							hub.Sessions(userID)
+							hub.Stats()
+						}
+					}
+				}()
+			}
+			senders.Wait()
+
+			// 移除所有剩余的连接，读协程在send通道关闭后退出
+			hub.mu.RLock()
+			remaining := make([]*Client, 0, len(hub.clients))
+			for client := range hub.clients {
+				remaining = append(remaining, client)
+			}
+			hub.mu.RUnlock()
+			for _, client := range remaining {
+				hub.unregister <- client
+			}
+
+			clients.Wait()
+			waitFor(t, "连接全部移除", func() bool { return hub.clientCount() == 0 })
+
+			stats := hub.Stats()
+			if policy == PolicyDropOldest && stats.SlowDisconnects != 0 {
+				t.Errorf("drop_oldest策略不应断开连接，实际断开 %d 个", stats.SlowDisconnects)
+			}
+			if policy != PolicySpill && stats.FramesSpilled != 0 {
+				t.Errorf("%s策略不应写入离线队列，实际写入 %d 帧", policy, stats.FramesSpilled)
+			}
+		})
+	}
+}
+
+func TestHubDropOldest(t *testing.T) {
+	hub := newTestHub(PolicyDropOldest, nil)
+	client := newTestClient(hub, "1", "phone")
+	hub.registerClient(client)
+
+	fillBuffer(t, hub, "1")
+	for i := 0; i < 10; i++ {
+		if !hub.SendToUser("1", []byte("new-"+strconv.Itoa(i))) {
+			t.Fatalf("drop_oldest策略下第 %d 个新帧未能发送", i)
+		}
+	}
+
+	if hub.clientCount() != 1 {
+		t.Fatalf("drop_oldest策略不应断开连接")
+	}
+	if stats := hub.Stats(); stats.FramesDropped != 10 {
+		t.Errorf("FramesDropped = %d, 期望 10", stats.FramesDropped)
+	}
+
+	hub.removeClient(client, nil)
+	frames := drain(client)
+	if len(frames) != sendBufferSize {
+		t.Fatalf("缓冲区中有 %d 帧, 期望 %d", len(frames), sendBufferSize)
+	}
+	if last := string(frames[len(frames)-1]); last != "new-9" {
+		t.Errorf("最后一帧为 %q, 期望最新的帧", last)
+	}
+}
+
+func TestHubDisconnect(t *testing.T) {
+	queue := newMemoryOfflineQueue()
+	hub := newTestHub(PolicyDisconnect, queue)
+	client := newTestClient(hub, "1", "phone")
+	hub.registerClient(client)
+
+	fillBuffer(t, hub, "1")
+	if hub.SendToUser("1", []byte("overflow")) {
+		t.Fatalf("缓冲区已满时不应发送成功")
+	}
+
+	if hub.clientCount() != 0 {
+		t.Fatalf("disconnect策略应断开慢连接")
+	}
+	if frames := drain(client); len(frames) != sendBufferSize {
+		t.Errorf("关闭前缓冲区中有 %d 帧, 期望 %d", len(frames), sendBufferSize)
+	}
+	if queue.len("1", "phone") != 0 {
+		t.Errorf("disconnect策略不应写入离线队列")
+	}
+
+	stats := hub.Stats()
+	if stats.SlowDisconnects != 1 || stats.FramesDropped != 1 {
+		t.Errorf("SlowDisconnects = %d, FramesDropped = %d, 期望 1, 1", stats.SlowDisconnects, stats.FramesDropped)
+	}
+}
+
+func TestHubSpillUntilReconnect(t *testing.T) {
+	queue := newMemoryOfflineQueue()
+	hub := newTestHub(PolicySpill, queue)
+	client := newTestClient(hub, "1", "phone")
+	hub.registerClient(client)
+
+	fillBuffer(t, hub, "1")
+	hub.SendToUser("1", []byte("overflow"))
+	if hub.clientCount() != 0 {
+		t.Fatalf("spill策略应断开慢连接")
+	}
+	drain(client)
+
+	// 断开期间的帧继续写入离线队列
+	for i := 0; i < 3; i++ {
+		hub.SendToUser("1", []byte("offline-"+strconv.Itoa(i)))
+	}
+	if n := queue.len("1", "phone"); n != 4 {
+		t.Fatalf("离线队列中有 %d 帧, 期望 4", n)
+	}
+
+	reconnected := newTestClient(hub, "1", "phone")
+	hub.registerClient(reconnected)
+	waitFor(t, "离线队列被读取", func() bool { return queue.len("1", "phone") == 0 })
+	waitFor(t, "离线帧发送给重连的设备", func() bool { return len(reconnected.send) == 4 })
+
+	// 重连之后的帧直接发送，不再写入离线队列
+	hub.SendToUser("1", []byte("online"))
+	hub.removeClient(reconnected, nil)
+
+	var got []string
+	for _, frame := range drain(reconnected) {
+		got = append(got, string(frame))
+	}
+	want := []string{"overflow", "offline-0", "offline-1", "offline-2", "online"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("重连后收到 %v, 期望 %v", got, want)
+	}
+	if queue.len("1", "phone") != 0 {
+		t.Errorf("重连后不应再写入离线队列")
+	}
+	if stats := hub.Stats(); stats.FramesSpilled != 4 {
+		t.Errorf("FramesSpilled = %d, 期望 4", stats.FramesSpilled)
+	}
+}
+
+func TestHubSpillExpires(t *testing.T) {
+	queue := newMemoryOfflineQueue()
+	hub := newTestHub(PolicySpill, queue)
+	client := newTestClient(hub, "1", "phone")
+	hub.registerClient(client)
+
+	fillBuffer(t, hub, "1")
+	hub.SendToUser("1", []byte("overflow"))
+	drain(client)
+
+	hub.pruneSpilling(time.Now().Add(offlineQueueTTL))
+	hub.SendToUser("1", []byte("expired"))
+	if n := queue.len("1", "phone"); n != 1 {
+		t.Errorf("离线队列过期后不应继续写入，队列中有 %d 帧", n)
+	}
+}
+
+func TestHubSpillRequiresDeviceID(t *testing.T) {
+	queue := newMemoryOfflineQueue()
+	hub := newTestHub(PolicySpill, queue)
+	client := newTestClient(hub, "1", "random")
+	client.session.stableDevice = false
+	hub.registerClient(client)
+
+	fillBuffer(t, hub, "1")
+	hub.SendToUser("1", []byte("overflow"))
+	hub.SendToUser("1", []byte("after"))
+
+	if hub.clientCount() != 0 {
+		t.Fatalf("缓冲区已满时应断开连接")
+	}
+	if n := queue.len("1", "random"); n != 0 {
+		t.Errorf("没有设备ID的连接不应写入离线队列，队列中有 %d 帧", n)
+	}
+	if stats := hub.Stats(); stats.FramesSpilled != 0 || stats.FramesDropped != 1 {
+		t.Errorf("FramesSpilled = %d, FramesDropped = %d, 期望 0, 1", stats.FramesSpilled, stats.FramesDropped)
+	}
+}
+
+func TestHubSpillFailureDropsFrame(t *testing.T) {
+	queue := newMemoryOfflineQueue()
+	queue.err = errors.New("离线队列不可用")
+	hub := newTestHub(PolicySpill, queue)
+	client := newTestClient(hub, "1", "phone")
+	hub.registerClient(client)
+
+	fillBuffer(t, hub, "1")
+	hub.SendToUser("1", []byte("overflow"))
+
+	if stats := hub.Stats(); stats.FramesSpilled != 0 || stats.FramesDropped != 1 {
+		t.Errorf("FramesSpilled = %d, FramesDropped = %d, 期望 0, 1", stats.FramesSpilled, stats.FramesDropped)
+	}
+}
+
+func TestHubDoubleClose(t *testing.T) {
+	hub := newTestHub(PolicyDisconnect, nil)
+	client := newTestClient(hub, "1", "phone")
+	hub.registerClient(client)
+	fillBuffer(t, hub, "1")
+
+	// 慢连接断开、主动断开、令牌过期和被踢下线同时移除同一个连接
+	client.expiresAt = time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			switch i {
+			case 0:
+				hub.SendToUser("1", []byte("overflow"))
+			case 1:
+				hub.removeClient(client, nil)
+			case 2:
+				hub.closeExpiredClients(time.Now())
+			case 3:
+				hub.KickSession("1", "phone")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if hub.clientCount() != 0 {
+		t.Fatalf("连接应已被移除")
+	}
+	if hub.removeClient(client, nil) {
+		t.Errorf("重复移除连接应返回false")
+	}
+	if client.close(nil) {
+		t.Errorf("重复关闭连接应返回false")
+	}
+	if result := client.enqueue([]byte("late"), PolicyDropOldest); result != enqueueClosed {
+		t.Errorf("连接关闭后enqueue返回 %v, 期望enqueueClosed", result)
+	}
+	if client.Send([]byte("late")) {
+		t.Errorf("连接关闭后Send应返回false")
+	}
+
+	drain(client)
+}
+
+func TestHubCloseToken(t *testing.T) {
+	hub := newTestHub(PolicyDisconnect, nil)
+	go hub.Run()
+
+	phone := newTestClient(hub, "1", "phone")
+	phone.tokenID = "revoked"
+	laptop := newTestClient(hub, "1", "laptop")
+	laptop.tokenID = "revoked"
+	tablet := newTestClient(hub, "1", "tablet")
+	tablet.tokenID = "other"
+
+	for _, client := range []*Client{phone, laptop, tablet} {
+		hub.register <- client
+	}
+	// 注册之后提交的吊销一定在注册完成后处理
+	hub.CloseToken("revoked")
+	hub.CloseToken("revoked")
+
+	waitFor(t, "使用被吊销令牌的连接关闭", func() bool { return hub.clientCount() == 1 })
+	drain(phone)
+	drain(laptop)
+	if sessions := hub.Sessions("1"); len(sessions) != 1 || sessions[0].DeviceID != "tablet" {
+		t.Errorf("剩余的会话为 %v, 期望只剩tablet", sessions)
+	}
+}
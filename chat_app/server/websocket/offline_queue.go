@@ -0,0 +1,43 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"chat_app/server/database"
+)
+
+const (
+	// 每个设备的离线队列最多保留的帧数
+	offlineQueueMaxLen = 1000
+
+	// 离线队列的有效期，过期后客户端只能通过增量同步补齐消息
+	offlineQueueTTL = 24 * time.Hour
+
+	// 访问离线队列的超时时间
+	offlineQueueTimeout = 5 * time.Second
+)
+
+// redisOfflineQueue 基于Redis列表的离线队列
+type redisOfflineQueue struct {
+	redisDB *database.RedisDB
+}
+
+// NewRedisOfflineQueue 创建基于Redis的离线队列
+func NewRedisOfflineQueue(redisDB *database.RedisDB) OfflineQueue {
+	return &redisOfflineQueue{redisDB: redisDB}
+}
+
+// SpillFrame 保存一帧
+func (q *redisOfflineQueue) SpillFrame(userID, deviceID string, frame []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), offlineQueueTimeout)
+	defer cancel()
+	return q.redisDB.PushOfflineFrame(ctx, userID, deviceID, frame, offlineQueueMaxLen, offlineQueueTTL)
+}
+
+// DrainFrames 取出并删除设备的所有帧
+func (q *redisOfflineQueue) DrainFrames(userID, deviceID string) ([][]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), offlineQueueTimeout)
+	defer cancel()
+	return q.redisDB.PopOfflineFrames(ctx, userID, deviceID)
+}
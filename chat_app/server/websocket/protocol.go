@@ -0,0 +1,190 @@
+package websocket
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ProtocolVersion 当前WebSocket协议版本
+const ProtocolVersion = 1
+
+// 帧类型
+const (
+	// FramePing 客户端心跳
+	FramePing = "ping"
+
+	// FramePong 心跳回复
+	FramePong = "pong"
+
+	// FrameAck 对请求确认的帧的回复
+	FrameAck = "ack"
+
+	// FrameError 结构化错误回复
+	FrameError = "error"
+
+	// FrameMessage 聊天消息
+	FrameMessage = "message"
+
+	// FrameSendMessage 客户端通过WebSocket发送消息
+	FrameSendMessage = "send_message"
+
+	// FrameUserStatus 用户在线状态变化
+	FrameUserStatus = "user_status"
+
+	// FrameTyping 正在输入状态
+	FrameTyping = "typing"
+
+	// FrameReadReceipt 已读回执
+	FrameReadReceipt = "read_receipt"
+
+	// FrameSystem 系统通知
+	FrameSystem = "system"
+
+	// FrameSync 增量同步请求及其回复
+	FrameSync = "sync"
+
+	// FrameDeliveryAck 接收者设备确认收到消息推送
+	FrameDeliveryAck = "delivery_ack"
+
+	// FrameMessageStatus 消息投递状态变化，推送给发送者
+	FrameMessageStatus = "message_status"
+
+	// FrameMessageEdited 消息内容被编辑
+	FrameMessageEdited = "message_edited"
+
+	// FrameMessageRecalled 消息被撤回
+	FrameMessageRecalled = "message_recalled"
+
+	// FrameAddReaction 客户端为消息添加表情回应
+	FrameAddReaction = "add_reaction"
+
+	// FrameRemoveReaction 客户端移除对消息的表情回应
+	FrameRemoveReaction = "remove_reaction"
+
+	// FrameReactionUpdated 消息的表情回应发生变化
+	FrameReactionUpdated = "reaction_updated"
+
+	// FrameMessagePinned 会话中的消息被置顶
+	FrameMessagePinned = "message_pinned"
+
+	// FrameMessageUnpinned 会话中的消息被取消置顶
+	FrameMessageUnpinned = "message_unpinned"
+
+	// FrameGroupAnnouncement 群组发布或删除了公告
+	FrameGroupAnnouncement = "group_announcement"
+
+	// FrameScheduledMessage 用户的定时消息被创建、修改、取消或发送，同步给该用户的所有设备
+	FrameScheduledMessage = "scheduled_message"
+
+	// FrameThreadReply 用户参与的话题有新回复
+	FrameThreadReply = "thread_reply"
+
+	// FrameMentioned 用户在群组消息中被@
+	FrameMentioned = "mentioned"
+
+	// FrameMessageDeleted 用户删除了自己的消息副本，同步给该用户的其他设备
+	FrameMessageDeleted = "message_deleted"
+
+	// FrameConversationCleared 用户清空了会话记录，同步给该用户的其他设备
+	FrameConversationCleared = "conversation_cleared"
+)
+
+// 错误码
+const (
+	// ErrCodeMalformedFrame 帧不是合法的JSON或缺少类型
+	ErrCodeMalformedFrame = "malformed_frame"
+
+	// ErrCodeUnsupportedVersion 不支持的协议版本
+	ErrCodeUnsupportedVersion = "unsupported_version"
+
+	// ErrCodeUnknownType 没有注册处理器的帧类型
+	ErrCodeUnknownType = "unknown_type"
+
+	// ErrCodeInvalidPayload 负载格式或内容无效
+	ErrCodeInvalidPayload = "invalid_payload"
+
+	// ErrCodeForbidden 无权执行该操作
+	ErrCodeForbidden = "forbidden"
+
+	// ErrCodeInternal 服务端处理失败
+	ErrCodeInternal = "internal_error"
+)
+
+// Envelope 是WebSocket帧的统一信封
+type Envelope struct {
+	// 协议版本，旧客户端不携带时视为当前版本
+	Version int `json:"v"`
+
+	// 帧类型
+	Type string `json:"type"`
+
+	// 客户端生成的帧ID，回复的ack和error帧会带回该ID
+	ID string `json:"id,omitempty"`
+
+	// 帧负载
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	// 是否需要服务端确认
+	Ack bool `json:"ack,omitempty"`
+}
+
+// DecodePayload 将帧负载解析到v
+func (e *Envelope) DecodePayload(v interface{}) error {
+	if len(e.Payload) == 0 {
+		return NewProtocolError(ErrCodeInvalidPayload, "缺少负载")
+	}
+	if err := json.Unmarshal(e.Payload, v); err != nil {
+		return NewProtocolError(ErrCodeInvalidPayload, "负载格式无效: "+err.Error())
+	}
+	return nil
+}
+
+// ErrorPayload error帧的负载
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PongPayload pong帧的负载
+type PongPayload struct {
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ProtocolError 帧处理器返回的带错误码的错误
+type ProtocolError struct {
+	Code    string
+	Message string
+}
+
+// NewProtocolError 创建带错误码的协议错误
+func NewProtocolError(code, message string) *ProtocolError {
+	return &ProtocolError{Code: code, Message: message}
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// NewFrame 编码一个服务端下发的帧
+func NewFrame(frameType string, payload interface{}) ([]byte, error) {
+	return newReplyFrame(frameType, "", payload)
+}
+
+// newReplyFrame 编码一个回复帧，id为被回复帧的ID
+func newReplyFrame(frameType, id string, payload interface{}) ([]byte, error) {
+	var raw json.RawMessage
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		raw = data
+	}
+
+	return json.Marshal(&Envelope{
+		Version: ProtocolVersion,
+		Type:    frameType,
+		ID:      id,
+		Payload: raw,
+	})
+}
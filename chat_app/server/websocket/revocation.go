@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"chat_app/server/database"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 检查令牌吊销状态和建立订阅的超时时间
+const revocationCheckTimeout = 5 * time.Second
+
+// RevocationListener 接收所有节点发布的令牌吊销通知，关闭本节点上使用被吊销令牌的连接
+type RevocationListener struct {
+	hub     *Hub
+	redisDB *database.RedisDB
+	pubsub  *redis.PubSub
+	done    chan struct{}
+}
+
+// NewRevocationListener 创建令牌吊销通知的监听器
+func NewRevocationListener(hub *Hub, redisDB *database.RedisDB) *RevocationListener {
+	return &RevocationListener{hub: hub, redisDB: redisDB}
+}
+
+// Start 订阅令牌吊销通知，Redis不可用时不做任何操作
+func (l *RevocationListener) Start() error {
+	if l.redisDB == nil || l.pubsub != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), revocationCheckTimeout)
+	defer cancel()
+
+	pubsub := l.redisDB.SubscribeRevokedTokens(context.Background())
+	// 等待订阅确认，之后发布的通知不会丢失
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+
+	l.pubsub = pubsub
+	l.done = make(chan struct{})
+	go l.run(pubsub.Channel(), l.done)
+	return nil
+}
+
+// Stop 取消订阅并等待处理循环退出
+func (l *RevocationListener) Stop() {
+	if l.pubsub == nil {
+		return
+	}
+	if err := l.pubsub.Close(); err != nil {
+		log.Printf("取消订阅 %s 失败: %v", database.TokenRevokedChannel, err)
+	}
+	<-l.done
+	l.pubsub = nil
+}
+
+// run 关闭每个被吊销的令牌对应的连接，直到订阅被关闭
+func (l *RevocationListener) run(messages <-chan *redis.Message, done chan struct{}) {
+	defer close(done)
+	for msg := range messages {
+		l.hub.CloseToken(msg.Payload)
+	}
+}